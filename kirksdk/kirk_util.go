@@ -8,6 +8,9 @@ import (
 	"net/http"
 	"runtime"
 	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
 )
 
 const (
@@ -113,6 +116,37 @@ func stdCopy(dstout, dsterr io.Writer, src io.Reader) (written int64, err error)
 	}
 }
 
+// execInput reads the input of an exec until it is stopped. A Read that is
+// still blocked when stopped is left to return, and its data is dropped.
+type execInput struct {
+	r       io.Reader
+	mu      sync.Mutex
+	stopped bool
+}
+
+func (p *execInput) Read(b []byte) (n int, err error) {
+	if p.isStopped() {
+		return 0, io.EOF
+	}
+	n, err = p.r.Read(b)
+	if p.isStopped() {
+		return 0, io.EOF
+	}
+	return
+}
+
+func (p *execInput) isStopped() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stopped
+}
+
+func (p *execInput) stop() {
+	p.mu.Lock()
+	p.stopped = true
+	p.mu.Unlock()
+}
+
 func cleanHost(host string) string {
 	for strings.HasSuffix(host, "/") {
 		host = strings.TrimSuffix(host, "/")
@@ -151,3 +185,21 @@ func (p *kirksdkTransport) RoundTrip(req *http.Request) (resp *http.Response, er
 	resp, err = p.transport.RoundTrip(req)
 	return
 }
+
+func isNotFound(err error) bool {
+	return httpCodeOf(err) == http.StatusNotFound
+}
+
+func loggerOf(client QcosClient, logger *logrus.Logger) *logrus.Logger {
+	return firstLogger(logger, client.GetConfig().Logger)
+}
+
+// firstLogger returns the first non nil logger, or a new one.
+func firstLogger(loggers ...*logrus.Logger) *logrus.Logger {
+	for _, l := range loggers {
+		if l != nil {
+			return l
+		}
+	}
+	return logrus.New()
+}
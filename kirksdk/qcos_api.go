@@ -178,14 +178,35 @@ type QcosClient interface {
 	StartContainerExec(ctx context.Context,
 		ip string, execID string, args StartContainerExecArgs, opts StartContainerExecOpts) (err error)
 
+	// GET /v3/containers/<ip>/exec/<execId>
+	InspectContainerExec(ctx context.Context,
+		ip string, execID string) (ret ExecInspectInfo, err error)
+
+	// RunCommand runs cmd in the container and waits for it to exit. Both
+	// outputs are returned, along with the exit code of cmd.
+	RunCommand(ctx context.Context,
+		ip string, cmd []string, opts RunCommandOpts) (stdout, stderr []byte, exitCode int, err error)
+
 	// PUT /v3/containers/<ip>/webdav/files/<filePath>
 	UploadToContainer(ctx context.Context,
 		ip string, filePath string, rd io.Reader) (err error)
 
+	// PUT /v3/containers/<ip>/webdav/files/<filePath>
+	// If-Match: <ifMatch>
+	// If-None-Match: <ifNoneMatch>
+	UploadToContainerWithArgs(ctx context.Context,
+		ip string, filePath string, rd io.Reader, args UploadToContainerArgs) (err error)
+
 	// GET /v3/containers/<ip>/webdav/files/<filePath>
 	DownloadFromContainer(ctx context.Context,
 		ip string, filePath string) (rc io.ReadCloser, err error)
 
+	// GET /v3/containers/<ip>/webdav/files/<filePath>
+	// Range: bytes=<offset>-[<last>]
+	// If-Range: <ifRange>
+	DownloadRangeFromContainer(ctx context.Context,
+		ip string, filePath string, args DownloadRangeArgs) (ret DownloadRangeResult, err error)
+
 	// PROPFIND /v3/containers/<ip>/webdav/files/<filePath>
 	StatContainerFile(ctx context.Context, ip string,
 		filePath string, args StatContainerFileArgs) (rc io.ReadCloser, err error)
@@ -194,6 +215,22 @@ type QcosClient interface {
 	MkdirInContainer(ctx context.Context,
 		ip string, filePath string) (err error)
 
+	// DELETE /v3/containers/<ip>/webdav/files/<filePath>
+	DeleteInContainer(ctx context.Context,
+		ip string, filePath string) (err error)
+
+	// MOVE /v3/containers/<ip>/webdav/files/<filePath>
+	// Destination: <host>/v3/containers/<ip>/webdav/files/<destPath>
+	// Overwrite: T|F
+	MoveInContainer(ctx context.Context,
+		ip string, filePath, destPath string, args MoveInContainerArgs) (err error)
+
+	// COPY /v3/containers/<ip>/webdav/files/<filePath>
+	// Destination: <host>/v3/containers/<ip>/webdav/files/<destPath>
+	// Overwrite: T|F
+	CopyInContainer(ctx context.Context,
+		ip string, filePath, destPath string, args CopyInContainerArgs) (err error)
+
 	// GET /v3/logs/containers/<ip>/realtime?since=<since>&tail=<tail>
 	GetContainerLogsRealtime(ctx context.Context,
 		ip, since, tail string, opts GetContainerLogsRealtimeOpts) (stream io.ReadCloser, err error)
@@ -571,6 +608,14 @@ type ResizeContainerExecTermArgs struct {
 	Width  int `json:"width"`
 }
 
+// Modes of StartContainerExecArgs.
+const (
+	// Stdout and stderr are multiplexed, the default.
+	ExecModeNonTTY = ""
+	// The exec gets a tty, and the output is a single raw stream.
+	ExecModeTTY = "tty"
+)
+
 type StartContainerExecArgs struct {
 	Mode string `json:"mode"`
 }
@@ -729,18 +774,89 @@ type ExecContainerRet struct {
 	ExecID string `json:"execId"`
 }
 
+type ExecInspectInfo struct {
+	ExecID   string `json:"execId"`
+	Running  bool   `json:"running"`
+	ExitCode int    `json:"exitCode"`
+	Pid      int    `json:"pid"`
+}
+
+type RunCommandOpts struct {
+	// Input of the command, none if nil.
+	Stdin io.Reader
+
+	// The command is abandoned after Timeout, it may keep running in the
+	// container. 0 means no timeout.
+	Timeout time.Duration
+
+	// Output beyond MaxOutputBytes is discarded, for each of stdout and
+	// stderr, and ErrOutputTruncated is returned. Default 1 MiB, negative
+	// means no limit.
+	MaxOutputBytes int64
+}
+
 type StartContainerExecOpts struct {
 	InStream  io.Reader
 	OutStream io.Writer
 	ErrStream io.Writer
 	ReadyCh   chan struct{}
 	ErrorCh   chan error
+
+	// Closing ExitCh detaches from the exec, which keeps running. The
+	// connection to the exec is then closed, as when ctx is done, and the
+	// streams are no longer used once StartContainerExec returns. A Read
+	// of InStream still blocked then is left to return and its data is
+	// dropped, so pass a reader that can be interrupted, such as a pipe,
+	// rather than os.Stdin.
+	ExitCh chan struct{}
+
+	// Records the exec in the asciicast format.
+	Recorder *ExecRecorder
 }
 
 type StatContainerFileArgs struct {
 	Depth int
 }
 
+type DownloadRangeArgs struct {
+	Offset int64
+	Length int64 // to the end of the file if not positive
+
+	// Only return the range if the ETag of the file is IfRange, the whole
+	// file otherwise.
+	IfRange string
+}
+
+type DownloadRangeResult struct {
+	Body   io.ReadCloser
+	Offset int64 // of Body in the file, 0 if the whole file is returned
+	Size   int64 // of the whole file, -1 if unknown
+	ETag   string
+}
+
+// UploadToContainerArgs makes an upload conditional, failing with
+// ErrPreconditionFailed if the condition does not hold. Use the ETag from
+// StatContainerPath as IfMatch to replace a file only if it is unchanged,
+// and "*" as IfNoneMatch to create a file only if it does not exist.
+type UploadToContainerArgs struct {
+	IfMatch     string
+	IfNoneMatch string
+}
+
+// Without Overwrite, the move fails with ErrPreconditionFailed if the
+// destination exists. It fails with ErrConflict if the destination can not
+// be created, for example when its parent is missing.
+type MoveInContainerArgs struct {
+	Overwrite bool
+}
+
+// Without Overwrite, the copy fails with ErrPreconditionFailed if the
+// destination exists. It fails with ErrConflict if the destination can not
+// be created, for example when its parent is missing.
+type CopyInContainerArgs struct {
+	Overwrite bool
+}
+
 type GetContainerLogsRealtimeOpts struct {
 	ExitCh  chan struct{}
 	ErrorCh chan error
@@ -835,6 +951,12 @@ var (
 	ErrNoSuchExec    = errors.New("no such exec")
 	ErrResultError   = errors.New("result error")
 	ErrNoSuchEntry   = errors.New("no such entry")
+
+	ErrPreconditionFailed = errors.New("precondition failed")
+	ErrConflict           = errors.New("conflict")
+	ErrInvalidRange       = errors.New("invalid range")
+
+	ErrOutputTruncated = errors.New("output truncated")
 )
 
 type JobTaskSpec struct {
@@ -0,0 +1,304 @@
+package kirksdk
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+)
+
+// AsciicastHeader is the first line of an asciicast v2 recording, see
+// https://github.com/asciinema/asciinema/blob/develop/doc/asciicast-v2.md
+type AsciicastHeader struct {
+	Version       int               `json:"version"`
+	Width         int               `json:"width"`
+	Height        int               `json:"height"`
+	Timestamp     int64             `json:"timestamp,omitempty"`
+	IdleTimeLimit float64           `json:"idle_time_limit,omitempty"`
+	Command       string            `json:"command,omitempty"`
+	Title         string            `json:"title,omitempty"`
+	Env           map[string]string `json:"env,omitempty"`
+
+	// Audit metadata, not part of the format and ignored by players.
+	Operator  string `json:"operator,omitempty"`
+	App       string `json:"app,omitempty"`
+	Container string `json:"container,omitempty"`
+}
+
+const (
+	AsciicastOutput = "o"
+	AsciicastInput  = "i"
+	AsciicastResize = "r"
+)
+
+// AsciicastEvent is a line of a recording after the header.
+type AsciicastEvent struct {
+	Time float64 // seconds since the start of the recording
+	Type string
+	Data string
+}
+
+func (p AsciicastEvent) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(p.Data)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("[%s, %q, %s]", strconv.FormatFloat(p.Time, 'f', 6, 64), p.Type, data)), nil
+}
+
+func (p *AsciicastEvent) UnmarshalJSON(b []byte) error {
+	var v []interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if len(v) != 3 {
+		return fmt.Errorf("invalid asciicast event: %s", b)
+	}
+	t, ok1 := v[0].(float64)
+	typ, ok2 := v[1].(string)
+	data, ok3 := v[2].(string)
+	if !ok1 || !ok2 || !ok3 {
+		return fmt.Errorf("invalid asciicast event: %s", b)
+	}
+	*p = AsciicastEvent{Time: t, Type: typ, Data: data}
+	return nil
+}
+
+// ExecRecorder records an exec in the asciicast v2 format. The header is
+// written when the exec starts, with the container IP, and the command of
+// an ExecSession, if not set. Stdin is recorded as input events, and both
+// stdout and stderr as output events, as they are seen on a terminal.
+//
+// A recorder records a single exec. Write errors stop the recording, they
+// are returned by Err.
+type ExecRecorder struct {
+	mu      sync.Mutex
+	w       io.Writer
+	header  AsciicastHeader
+	start   time.Time
+	started bool
+	stopped bool
+	writers []*recordWriter
+	err     error
+}
+
+// NewExecRecorder returns a recorder writing to w. The terminal size in the
+// header defaults to 80x24.
+func NewExecRecorder(w io.Writer, header AsciicastHeader) *ExecRecorder {
+	return &ExecRecorder{w: w, header: header}
+}
+
+// Err returns the first error writing the recording.
+func (p *ExecRecorder) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// Resize records a change of the terminal size. Before the exec starts,
+// it sets the size in the header.
+func (p *ExecRecorder) Resize(width, height int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.started {
+		p.header.Width, p.header.Height = width, height
+		return
+	}
+	p.event(AsciicastResize, fmt.Sprintf("%dx%d", width, height))
+}
+
+func (p *ExecRecorder) setCommand(command []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.header.Command == "" {
+		p.header.Command = strings.Join(command, " ")
+	}
+}
+
+// record writes the header and wraps the streams of the exec in opts.
+func (p *ExecRecorder) record(ip string, opts StartContainerExecOpts) StartContainerExecOpts {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.started {
+		return opts
+	}
+	p.started = true
+	p.start = time.Now()
+
+	h := p.header
+	h.Version = 2
+	if h.Width == 0 {
+		h.Width = 80
+	}
+	if h.Height == 0 {
+		h.Height = 24
+	}
+	if h.Timestamp == 0 {
+		h.Timestamp = p.start.Unix()
+	}
+	if h.Container == "" {
+		h.Container = ip
+	}
+	b, err := json.Marshal(h)
+	if err == nil {
+		_, err = fmt.Fprintf(p.w, "%s\n", b)
+	}
+	if err != nil {
+		p.err = err
+		return opts
+	}
+
+	// each stream holds back its own incomplete UTF-8 sequence
+	if opts.InStream != nil {
+		opts.InStream = io.TeeReader(opts.InStream, p.newWriter(AsciicastInput))
+	}
+	opts.OutStream = &teeWriter{w: opts.OutStream, rec: p.newWriter(AsciicastOutput)}
+	opts.ErrStream = &teeWriter{w: opts.ErrStream, rec: p.newWriter(AsciicastOutput)}
+	return opts
+}
+
+func (p *ExecRecorder) newWriter(typ string) *recordWriter {
+	w := &recordWriter{recorder: p, typ: typ}
+	p.writers = append(p.writers, w)
+	return w
+}
+
+// stop ends the recording when the exec returns, recording what the streams
+// still hold back.
+func (p *ExecRecorder) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, w := range p.writers {
+		if len(w.partial) > 0 {
+			p.event(w.typ, string(w.partial))
+			w.partial = nil
+		}
+	}
+	p.stopped = true
+}
+
+// event writes an event, p.mu being held.
+func (p *ExecRecorder) event(typ, data string) {
+	if p.err != nil || p.stopped {
+		return
+	}
+	b, err := json.Marshal(AsciicastEvent{Time: time.Since(p.start).Seconds(), Type: typ, Data: data})
+	if err == nil {
+		_, err = fmt.Fprintf(p.w, "%s\n", b)
+	}
+	p.err = err
+}
+
+// recordWriter records what is written as events, holding back incomplete
+// UTF-8 sequences so that they are not mangled in the JSON strings.
+type recordWriter struct {
+	recorder *ExecRecorder
+	typ      string
+	partial  []byte
+}
+
+func (p *recordWriter) Write(b []byte) (int, error) {
+	p.recorder.mu.Lock()
+	defer p.recorder.mu.Unlock()
+	data := append(p.partial, b...)
+	i := len(data)
+	for j := len(data) - 1; j >= 0 && j >= len(data)-utf8.UTFMax; j-- {
+		if utf8.RuneStart(data[j]) {
+			if !utf8.FullRune(data[j:]) {
+				i = j
+			}
+			break
+		}
+	}
+	p.partial = append([]byte(nil), data[i:]...)
+	if i > 0 {
+		p.recorder.event(p.typ, string(data[:i]))
+	}
+	return len(b), nil
+}
+
+// teeWriter writes to w, or discards if w is nil, and records what is
+// written.
+type teeWriter struct {
+	w   io.Writer
+	rec io.Writer
+}
+
+func (p *teeWriter) Write(b []byte) (n int, err error) {
+	w := p.w
+	if w == nil {
+		w = ioutil.Discard
+	}
+	n, err = w.Write(b)
+	p.rec.Write(b[:n])
+	return
+}
+
+type ReplayOpts struct {
+	// Playback speed, default 1.
+	Speed float64
+
+	// Pauses longer than IdleLimit are shortened to IdleLimit. Default the
+	// idle_time_limit of the recording, no limit if both are zero.
+	IdleLimit time.Duration
+}
+
+// ReplayAsciicast plays the output of a recording back to w, in real time
+// unless opts say otherwise. It returns the header of the recording.
+func ReplayAsciicast(ctx context.Context, r io.Reader, w io.Writer, opts ReplayOpts) (header AsciicastHeader, err error) {
+	if opts.Speed <= 0 {
+		opts.Speed = 1
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	if !scanner.Scan() {
+		if err = scanner.Err(); err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	if err = json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return header, fmt.Errorf("asciicast header: %v", err)
+	}
+	if header.Version != 2 {
+		return header, fmt.Errorf("unsupported asciicast version %d", header.Version)
+	}
+	if opts.IdleLimit == 0 && header.IdleTimeLimit > 0 {
+		opts.IdleLimit = time.Duration(header.IdleTimeLimit * float64(time.Second))
+	}
+
+	last := 0.0
+	for line := 2; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var ev AsciicastEvent
+		if err = json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			return header, fmt.Errorf("asciicast line %d: %v", line, err)
+		}
+		if ev.Type != AsciicastOutput {
+			continue
+		}
+		delay := time.Duration((ev.Time - last) * float64(time.Second))
+		last = ev.Time
+		if opts.IdleLimit > 0 && delay > opts.IdleLimit {
+			delay = opts.IdleLimit
+		}
+		if err = sleepContext(ctx, time.Duration(float64(delay)/opts.Speed)); err != nil {
+			return
+		}
+		if _, err = io.WriteString(w, ev.Data); err != nil {
+			return
+		}
+	}
+	err = scanner.Err()
+	return
+}
@@ -0,0 +1,136 @@
+package kirksdk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecRecorder(t *testing.T) {
+	client := newHookTestClient()
+	client.execHandler = func(ip string, command []string, stdin io.Reader, stdout, stderr io.Writer) int {
+		in, _ := ioutil.ReadAll(stdin)
+		stdout.Write(in)
+		// "é" split across writes
+		stdout.Write([]byte{'c', 0xc3})
+		stdout.Write([]byte{0xa9, '\n'})
+		io.WriteString(stderr, "warning\n")
+		return 0
+	}
+
+	var rec, stdout bytes.Buffer
+	recorder := NewExecRecorder(&rec, AsciicastHeader{Operator: "alice", App: "1380000000.web"})
+	recorder.Resize(120, 40)
+	err := (&ExecSession{
+		Client:   client,
+		IP:       "10.0.0.1",
+		Command:  []string{"sh", "-i"},
+		Stdin:    strings.NewReader("ls\n"),
+		Stdout:   &stdout,
+		Recorder: recorder,
+	}).Run(context.TODO())
+	assert.NoError(t, err)
+	assert.NoError(t, recorder.Err())
+	assert.Equal(t, "ls\ncé\n", stdout.String())
+
+	scanner := bufio.NewScanner(bytes.NewReader(rec.Bytes()))
+	assert.True(t, scanner.Scan())
+	var header AsciicastHeader
+	assert.NoError(t, json.Unmarshal(scanner.Bytes(), &header))
+	assert.Equal(t, 2, header.Version)
+	assert.Equal(t, 120, header.Width)
+	assert.Equal(t, 40, header.Height)
+	assert.Equal(t, "alice", header.Operator)
+	assert.Equal(t, "1380000000.web", header.App)
+	assert.Equal(t, "10.0.0.1", header.Container)
+	assert.Equal(t, "sh -i", header.Command)
+	assert.NotZero(t, header.Timestamp)
+
+	var input, output string
+	for scanner.Scan() {
+		var ev AsciicastEvent
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &ev))
+		switch ev.Type {
+		case AsciicastInput:
+			input += ev.Data
+		case AsciicastOutput:
+			output += ev.Data
+		default:
+			t.Errorf("unexpected event %v", ev)
+		}
+	}
+	assert.Equal(t, "ls\n", input)
+	assert.Equal(t, "ls\ncé\nwarning\n", output)
+
+	var replay bytes.Buffer
+	header, err = ReplayAsciicast(context.TODO(), bytes.NewReader(rec.Bytes()), &replay, ReplayOpts{})
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", header.Container)
+	assert.Equal(t, "ls\ncé\nwarning\n", replay.String())
+}
+
+func TestExecRecorderStreams(t *testing.T) {
+	client := newHookTestClient()
+	client.execHandler = func(ip string, command []string, stdin io.Reader, stdout, stderr io.Writer) int {
+		// "你" split across writes to stdout, with "好" written to stderr
+		// in between
+		stdout.Write([]byte{0xe4, 0xbd})
+		io.WriteString(stderr, "好")
+		stdout.Write([]byte{0xa0})
+		// left incomplete when the exec ends
+		stderr.Write([]byte{'!', 0xe4})
+		return 0
+	}
+
+	var rec bytes.Buffer
+	err := (&ExecSession{
+		Client:   client,
+		IP:       "10.0.0.1",
+		Command:  []string{"sh"},
+		Recorder: NewExecRecorder(&rec, AsciicastHeader{}),
+	}).Run(context.TODO())
+	assert.NoError(t, err)
+
+	var outputs []string
+	lines := strings.Split(strings.TrimSuffix(rec.String(), "\n"), "\n")
+	for _, line := range lines[1:] {
+		var ev AsciicastEvent
+		assert.NoError(t, json.Unmarshal([]byte(line), &ev))
+		outputs = append(outputs, ev.Data)
+	}
+	assert.Equal(t, []string{"好", "你", "!", "\ufffd"}, outputs)
+}
+
+func TestReplayAsciicast(t *testing.T) {
+	recording := `{"version": 2, "width": 80, "height": 24, "idle_time_limit": 0.05}
+[0.000000, "o", "$ "]
+[0.100000, "i", "date\r"]
+[0.200000, "o", "date\r\n"]
+[60.000000, "o", "Tue Oct 18\r\n"]
+`
+	var out bytes.Buffer
+	start := time.Now()
+	_, err := ReplayAsciicast(context.TODO(), strings.NewReader(recording), &out, ReplayOpts{Speed: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, "$ date\r\nTue Oct 18\r\n", out.String())
+	assert.True(t, time.Since(start) < time.Second)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+	out.Reset()
+	_, err = ReplayAsciicast(ctx, strings.NewReader(recording), &out, ReplayOpts{})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, "$ ", out.String())
+
+	_, err = ReplayAsciicast(context.TODO(), strings.NewReader(`{"version": 1}`), &out, ReplayOpts{})
+	assert.Error(t, err)
+}
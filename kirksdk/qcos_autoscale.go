@@ -0,0 +1,358 @@
+package kirksdk
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+type ScaleMetric string
+
+const (
+	// Average CPU cores used per container.
+	ScaleMetricCPU = ScaleMetric("cpu")
+	// Average memory bytes used per container.
+	ScaleMetricMemory = ScaleMetric("memory")
+	// Average received bytes per second per container.
+	ScaleMetricNetworkRx = ScaleMetric("network-rx")
+	// Average sent bytes per second per container.
+	ScaleMetricNetworkTx = ScaleMetric("network-tx")
+)
+
+// ScaleRule is a target tracking rule: the instance count is changed so that
+// the average of Metric per container gets close to Target.
+type ScaleRule struct {
+	Metric ScaleMetric `json:"metric"`
+	Target float64     `json:"target"`
+}
+
+type AutoscalerOpts struct {
+	Rules        []ScaleRule
+	MinInstances int
+	MaxInstances int
+
+	// Interval of sampling the containers. Default 30s.
+	Interval time.Duration
+
+	// Samples within Window are averaged. Default 5 minutes.
+	Window time.Duration
+
+	// Minimal number of samples taken since the start or the last scaling
+	// before scaling. Default 3, or fewer if the window does not hold as
+	// many.
+	MinSamples int
+
+	// Minimal time since the last scaling before scaling up or down.
+	ScaleUpCooldown   time.Duration
+	ScaleDownCooldown time.Duration
+
+	// Maximal instances added or removed at once, 0 means no limit.
+	MaxScaleUpStep   int
+	MaxScaleDownStep int
+
+	// No scaling while every metric is within Tolerance of its target, as a
+	// ratio. Default 0.1.
+	Tolerance float64
+
+	// If Account is set, the quota of AppURI is checked before scaling up:
+	// no quota item may be exhausted, and the items named in QuotaNames
+	// must have room for the added instances.
+	Account    AccountClient
+	AppURI     string
+	QuotaNames []string
+
+	Logger *logrus.Logger
+}
+
+// ScaleDecision is the outcome of one autoscaler step.
+type ScaleDecision struct {
+	At      time.Time               `json:"at"`
+	Current int                     `json:"current"`
+	Desired int                     `json:"desired"`
+	Metrics map[ScaleMetric]float64 `json:"metrics"`
+	Samples int                     `json:"samples"`
+	Scaled  bool                    `json:"scaled"`
+	Reason  string                  `json:"reason"`
+}
+
+type autoscaleSample struct {
+	at     time.Time
+	values map[ScaleMetric]float64
+}
+
+// Autoscaler scales a service according to the resource usage of its
+// containers, sampled with GetContainerInspect.
+type Autoscaler struct {
+	client  QcosClient
+	stack   string
+	service string
+	opts    AutoscalerOpts
+	log     *logrus.Logger
+
+	samples   []autoscaleSample
+	lastScale time.Time
+	now       func() time.Time
+}
+
+// NewAutoscaler returns an autoscaler of the service. Rules must have a
+// known metric and a positive target.
+func NewAutoscaler(client QcosClient, stackName, serviceName string, opts AutoscalerOpts) (*Autoscaler, error) {
+	for _, rule := range opts.Rules {
+		switch rule.Metric {
+		case ScaleMetricCPU, ScaleMetricMemory, ScaleMetricNetworkRx, ScaleMetricNetworkTx:
+		default:
+			return nil, fmt.Errorf("invalid scale rule: unknown metric %q", rule.Metric)
+		}
+		if rule.Target <= 0 {
+			return nil, fmt.Errorf("invalid scale rule: target of %s must be positive", rule.Metric)
+		}
+	}
+	if stackName == "" {
+		stackName = DefaultStack
+	}
+	if opts.Interval == 0 {
+		opts.Interval = 30 * time.Second
+	}
+	if opts.Window == 0 {
+		opts.Window = 5 * time.Minute
+	}
+	if opts.Tolerance == 0 {
+		opts.Tolerance = 0.1
+	}
+	if opts.MinSamples <= 0 {
+		opts.MinSamples = 3
+		if n := int(opts.Window / opts.Interval); n < opts.MinSamples {
+			opts.MinSamples = n
+		}
+		if opts.MinSamples < 1 {
+			opts.MinSamples = 1
+		}
+	}
+	return &Autoscaler{
+		client:  client,
+		stack:   stackName,
+		service: serviceName,
+		opts:    opts,
+		log:     loggerOf(client, opts.Logger),
+		now:     time.Now,
+	}, nil
+}
+
+// Run calls Step every interval until ctx is done. Errors of a step are
+// logged and do not stop the autoscaler.
+func (p *Autoscaler) Run(ctx context.Context) error {
+	for {
+		if _, err := p.Step(ctx); err != nil {
+			p.log.WithField("service", p.stack+"/"+p.service).Warnf("autoscale: %v", err)
+		}
+		if err := sleepContext(ctx, p.opts.Interval); err != nil {
+			return err
+		}
+	}
+}
+
+// Step samples the containers once, and scales the service if the averages
+// over the window call for it.
+func (p *Autoscaler) Step(ctx context.Context) (ret ScaleDecision, err error) {
+	ret.At = p.now()
+	svc, err := p.client.GetServiceInspect(ctx, p.stack, p.service)
+	if err != nil {
+		return
+	}
+	ret.Current = svc.InstanceNum
+	ret.Desired = svc.InstanceNum
+
+	sample, err := p.sample(ctx, svc.ContainerIPs)
+	if err != nil {
+		return
+	}
+	if sample != nil {
+		p.samples = append(p.samples, autoscaleSample{ret.At, sample})
+	}
+	p.trimSamples(ret.At)
+	ret.Samples = len(p.samples)
+	ret.Metrics = p.averages()
+
+	p.decide(ctx, &ret)
+	p.log.WithFields(logrus.Fields{
+		"service": p.stack + "/" + p.service,
+		"current": ret.Current,
+		"desired": ret.Desired,
+		"metrics": formatScaleMetrics(ret.Metrics),
+		"scaled":  ret.Scaled,
+	}).Info("autoscale: " + ret.Reason)
+	return
+}
+
+func (p *Autoscaler) decide(ctx context.Context, ret *ScaleDecision) {
+	if len(p.samples) == 0 {
+		ret.Reason = "no running container to sample"
+		return
+	}
+	ret.Desired = p.desired(ret.Current, ret.Metrics)
+	if ret.Desired == ret.Current {
+		ret.Reason = "within target"
+		return
+	}
+	if len(p.samples) < p.opts.MinSamples {
+		ret.Reason = fmt.Sprintf("%d of %d samples taken", len(p.samples), p.opts.MinSamples)
+		return
+	}
+
+	up := ret.Desired > ret.Current
+	cooldown := p.opts.ScaleDownCooldown
+	if up {
+		cooldown = p.opts.ScaleUpCooldown
+	}
+	if since := ret.At.Sub(p.lastScale); !p.lastScale.IsZero() && since < cooldown {
+		ret.Reason = fmt.Sprintf("in cooldown, %v left", cooldown-since)
+		return
+	}
+	if up && p.opts.Account != nil {
+		if err := p.checkQuota(ctx, ret.Desired-ret.Current); err != nil {
+			ret.Reason = err.Error()
+			return
+		}
+	}
+
+	err := p.client.ScaleService(ctx, p.stack, p.service, ScaleServiceArgs{InstanceNum: ret.Desired})
+	if err != nil {
+		ret.Reason = fmt.Sprintf("scale failed: %v", err)
+		return
+	}
+	ret.Scaled = true
+	ret.Reason = fmt.Sprintf("scaled from %d to %d", ret.Current, ret.Desired)
+	p.lastScale = ret.At
+	// samples of the old instance count no longer apply
+	p.samples = nil
+}
+
+// desired applies the rules, taking the largest instance count, within the
+// step limits and the min and max instances. Without any rule applying, the
+// instance count is kept.
+func (p *Autoscaler) desired(current int, metrics map[ScaleMetric]float64) int {
+	desired, applied := 0, false
+	for _, rule := range p.opts.Rules {
+		avg, ok := metrics[rule.Metric]
+		if !ok {
+			continue
+		}
+		n := current
+		if ratio := avg / rule.Target; math.Abs(ratio-1) > p.opts.Tolerance {
+			n = int(math.Ceil(float64(current) * ratio))
+		}
+		if !applied || n > desired {
+			desired, applied = n, true
+		}
+	}
+	if !applied {
+		return current
+	}
+
+	if p.opts.MaxScaleUpStep > 0 && desired > current+p.opts.MaxScaleUpStep {
+		desired = current + p.opts.MaxScaleUpStep
+	}
+	if p.opts.MaxScaleDownStep > 0 && desired < current-p.opts.MaxScaleDownStep {
+		desired = current - p.opts.MaxScaleDownStep
+	}
+	if p.opts.MaxInstances > 0 && desired > p.opts.MaxInstances {
+		desired = p.opts.MaxInstances
+	}
+	if desired < p.opts.MinInstances {
+		desired = p.opts.MinInstances
+	}
+	if desired < 1 {
+		desired = 1
+	}
+	return desired
+}
+
+func (p *Autoscaler) checkQuota(ctx context.Context, added int) error {
+	items, err := p.opts.Account.GetAppQuota(ctx, p.opts.AppURI)
+	if err != nil {
+		return fmt.Errorf("get quota failed: %v", err)
+	}
+	for _, item := range items {
+		if item.Max == UnlimitedQuota {
+			continue
+		}
+		need := int64(0)
+		if containsString(p.opts.QuotaNames, item.Name) {
+			need = int64(added)
+		}
+		if item.Used+need > item.Max || item.Used >= item.Max {
+			return fmt.Errorf("quota %s exhausted, %d/%d used", item.Name, item.Used, item.Max)
+		}
+	}
+	return nil
+}
+
+// sample returns the averages over the running containers, nil if none is
+// running.
+func (p *Autoscaler) sample(ctx context.Context, ips []string) (map[ScaleMetric]float64, error) {
+	sum := make(map[ScaleMetric]float64)
+	n := 0
+	for _, ip := range ips {
+		info, err := p.client.GetContainerInspect(ctx, ip)
+		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if info.Status != StatusRunning {
+			continue
+		}
+		sum[ScaleMetricCPU] += info.CPU.CoreUsage
+		sum[ScaleMetricMemory] += float64(info.Memory.Usage)
+		sum[ScaleMetricNetworkRx] += info.Network.RxBs
+		sum[ScaleMetricNetworkTx] += info.Network.TxBs
+		n++
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	for k := range sum {
+		sum[k] /= float64(n)
+	}
+	return sum, nil
+}
+
+func (p *Autoscaler) trimSamples(now time.Time) {
+	i := 0
+	for i < len(p.samples) && now.Sub(p.samples[i].at) > p.opts.Window {
+		i++
+	}
+	p.samples = p.samples[i:]
+}
+
+func (p *Autoscaler) averages() map[ScaleMetric]float64 {
+	ret := make(map[ScaleMetric]float64)
+	if len(p.samples) == 0 {
+		return ret
+	}
+	for _, s := range p.samples {
+		for k, v := range s.values {
+			ret[k] += v
+		}
+	}
+	for k := range ret {
+		ret[k] /= float64(len(p.samples))
+	}
+	return ret
+}
+
+func formatScaleMetrics(metrics map[ScaleMetric]float64) string {
+	keys := []ScaleMetric{ScaleMetricCPU, ScaleMetricMemory, ScaleMetricNetworkRx, ScaleMetricNetworkTx}
+	var parts []string
+	for _, k := range keys {
+		if v, ok := metrics[k]; ok {
+			parts = append(parts, fmt.Sprintf("%s=%.3g", k, v))
+		}
+	}
+	return strings.Join(parts, " ")
+}
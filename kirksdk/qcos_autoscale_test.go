@@ -0,0 +1,130 @@
+package kirksdk
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setContainerCPU(client *mockQcosClient, ip string, cpu float64) {
+	info := client.containers[ip]
+	info.CPU.CoreUsage = cpu
+	client.containers[ip] = info
+}
+
+func TestAutoscaler(t *testing.T) {
+	client := newRolloutTestClient()
+	client.ScaleService(context.TODO(), "web", "nginx", ScaleServiceArgs{InstanceNum: 2})
+	client.calls = nil
+	account := &mockAccountClient{quota: []QuotaItem{{Name: "containers", Used: 2, Max: 3}}}
+
+	now := time.Unix(1000, 0)
+	scaler, err := NewAutoscaler(client, "web", "nginx", AutoscalerOpts{
+		Rules:             []ScaleRule{{ScaleMetricCPU, 0.5}},
+		MinInstances:      1,
+		MaxInstances:      10,
+		Window:            time.Minute,
+		MinSamples:        2,
+		ScaleUpCooldown:   time.Minute,
+		ScaleDownCooldown: 5 * time.Minute,
+		MaxScaleUpStep:    4,
+		MaxScaleDownStep:  3,
+		Account:           account,
+		QuotaNames:        []string{"containers"},
+	})
+	assert.NoError(t, err)
+	scaler.now = func() time.Time { return now }
+	step := func(d time.Duration) ScaleDecision {
+		now = now.Add(d)
+		ret, err := scaler.Step(context.TODO())
+		assert.NoError(t, err)
+		return ret
+	}
+
+	setContainerCPU(client, "10.0.0.1", 0.5)
+	setContainerCPU(client, "10.0.0.2", 0.55)
+	ret := step(0)
+	assert.Equal(t, "within target", ret.Reason)
+	assert.Equal(t, 2, ret.Desired)
+
+	// averaged over the window: (0.525 + 1.525) / 2 = 1.025 => 5 instances,
+	// blocked by the quota
+	setContainerCPU(client, "10.0.0.1", 1.5)
+	setContainerCPU(client, "10.0.0.2", 1.55)
+	ret = step(10 * time.Second)
+	assert.Equal(t, 2, ret.Samples)
+	assert.Equal(t, 5, ret.Desired)
+	assert.False(t, ret.Scaled)
+	assert.Equal(t, "quota containers exhausted, 2/3 used", ret.Reason)
+
+	account.quota[0].Max = UnlimitedQuota
+	ret = step(10 * time.Second)
+	assert.True(t, ret.Scaled)
+	assert.Equal(t, "scaled from 2 to 5", ret.Reason)
+	assert.Equal(t, []string{"ScaleService web/nginx"}, client.calls)
+	assert.Equal(t, 5, client.services["web/nginx"].InstanceNum)
+
+	// load drops, but scaling down waits for new samples and the cooldown,
+	// and removes at most 3 instances
+	setContainerCPU(client, "10.0.0.1", 0.1)
+	setContainerCPU(client, "10.0.0.2", 0.1)
+	ret = step(time.Minute)
+	assert.Equal(t, 2, ret.Desired)
+	assert.False(t, ret.Scaled)
+	assert.Equal(t, "1 of 2 samples taken", ret.Reason)
+
+	ret = step(10 * time.Second)
+	assert.Equal(t, 2, ret.Desired)
+	assert.Equal(t, "in cooldown, 3m50s left", ret.Reason)
+
+	step(4 * time.Minute)
+	ret = step(10 * time.Second)
+	assert.True(t, ret.Scaled)
+	assert.Equal(t, 2, ret.Samples)
+	assert.Equal(t, 2, client.services["web/nginx"].InstanceNum)
+}
+
+func TestAutoscalerRules(t *testing.T) {
+	client := newRolloutTestClient()
+	_, err := NewAutoscaler(client, "web", "nginx", AutoscalerOpts{
+		Rules: []ScaleRule{{ScaleMetricCPU, 0}},
+	})
+	assert.Error(t, err)
+	_, err = NewAutoscaler(client, "web", "nginx", AutoscalerOpts{
+		Rules: []ScaleRule{{"disk", 10}},
+	})
+	assert.Error(t, err)
+
+	// without any rule, the instance count is kept, even below
+	// MinInstances
+	scaler, err := NewAutoscaler(client, "web", "nginx", AutoscalerOpts{MinInstances: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, scaler.desired(2, map[ScaleMetric]float64{ScaleMetricCPU: 1}))
+
+	// the default minimal samples fit in the window
+	scaler, err = NewAutoscaler(client, "web", "nginx", AutoscalerOpts{
+		Interval: time.Minute,
+		Window:   2 * time.Minute,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, scaler.opts.MinSamples)
+}
+
+func TestAutoscalerNoSample(t *testing.T) {
+	client := newRolloutTestClient()
+	for ip, info := range client.containers {
+		info.Status = StatusNotRunning
+		client.containers[ip] = info
+	}
+	scaler, err := NewAutoscaler(client, "web", "nginx", AutoscalerOpts{
+		Rules: []ScaleRule{{ScaleMetricCPU, 0.5}},
+	})
+	assert.NoError(t, err)
+	ret, err := scaler.Step(context.TODO())
+	assert.NoError(t, err)
+	assert.False(t, ret.Scaled)
+	assert.Equal(t, "no running container to sample", ret.Reason)
+}
@@ -0,0 +1,780 @@
+package kirksdk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// BackupFormatVersion is the version of the directory layout written by BackupApp.
+//
+// The layout of a backup directory is:
+//
+//	manifest.json
+//	configservices/<namespace>.json
+//	stacks/<stack>/stack.json
+//	stacks/<stack>/services/<service>.json
+//	stacks/<stack>/alerts/<service>.json
+//	jobs/<job>.json
+//	aps/<apid>/ap.json
+//	aps/<apid>/alerts.json
+//	containers/<ip>/alerts.json
+const BackupFormatVersion = 1
+
+var (
+	ErrBackupVersion   = errors.New("unsupported backup version")
+	ErrRestoreConflict = errors.New("resource already exists")
+)
+
+type RestoreConflictPolicy string
+
+const (
+	// Keep the existing resource untouched.
+	RestoreConflictSkip = RestoreConflictPolicy("skip")
+	// Update the existing resource to the backed up spec.
+	RestoreConflictOverwrite = RestoreConflictPolicy("overwrite")
+	// Abort the restore with ErrRestoreConflict.
+	RestoreConflictFail = RestoreConflictPolicy("fail")
+)
+
+type BackupManifest struct {
+	Version        int       `json:"version"`
+	CreatedAt      time.Time `json:"createdAt"`
+	Host           string    `json:"host"`
+	Stacks         []string  `json:"stacks"`
+	Jobs           []string  `json:"jobs"`
+	Aps            []string  `json:"aps"`
+	ConfigServices []string  `json:"configServices"`
+	Containers     []string  `json:"containers"`
+}
+
+type BackupAppOpts struct {
+	Logger *logrus.Logger
+}
+
+type RestoreAppOpts struct {
+	Conflict RestoreConflictPolicy // default RestoreConflictSkip
+	Sync     bool                  // wait for restored stacks to be running
+	Logger   *logrus.Logger
+}
+
+type RestoreAppResult struct {
+	Created []string `json:"created"`
+	Updated []string `json:"updated"`
+	Skipped []string `json:"skipped"`
+}
+
+// ContainerAlertBackup records the alert rules of a single container
+// together with the service it belonged to.
+type ContainerAlertBackup struct {
+	IP      string               `json:"ip"`
+	Stack   string               `json:"stack"`
+	Service string               `json:"service"`
+	Alerts  []ContainerAlertInfo `json:"alerts"`
+}
+
+// BackupApp dumps the configuration of the app behind client into dir.
+func BackupApp(ctx context.Context,
+	client QcosClient, dir string, opts BackupAppOpts) (ret BackupManifest, err error) {
+
+	log := loggerOf(client, opts.Logger)
+	ret = BackupManifest{
+		Version:   BackupFormatVersion,
+		CreatedAt: time.Now(),
+		Host:      client.GetConfig().Host,
+	}
+
+	specs, err := client.ListConfigServiceSpecs(ctx)
+	if err != nil {
+		return
+	}
+	for _, spec := range specs {
+		log.WithField("namespace", spec.Namespace).Info("backup config service")
+		err = writeBackupFile(dir, spec, "configservices", backupName(spec.Namespace)+".json")
+		if err != nil {
+			return
+		}
+		ret.ConfigServices = append(ret.ConfigServices, spec.Namespace)
+	}
+
+	stacks, err := client.ListStacks(ctx)
+	if err != nil {
+		return
+	}
+	for _, stack := range stacks {
+		log.WithField("stack", stack.Name).Info("backup stack")
+		var containers []ContainerAlertBackup
+		containers, err = backupStack(ctx, client, dir, stack.Name)
+		if err != nil {
+			return
+		}
+		ret.Stacks = append(ret.Stacks, stack.Name)
+		for _, c := range containers {
+			err = writeBackupFile(dir, c, "containers", backupName(c.IP), "alerts.json")
+			if err != nil {
+				return
+			}
+			ret.Containers = append(ret.Containers, c.IP)
+		}
+	}
+
+	jobs, err := client.ListJobs(ctx)
+	if err != nil {
+		return
+	}
+	for _, job := range jobs {
+		log.WithField("job", job.Name).Info("backup job")
+		err = writeBackupFile(dir, job, "jobs", backupName(job.Name)+".json")
+		if err != nil {
+			return
+		}
+		ret.Jobs = append(ret.Jobs, job.Name)
+	}
+
+	aps, err := client.ListAps(ctx, ListApsArgs{})
+	if err != nil {
+		return
+	}
+	for _, ap := range aps {
+		log.WithField("apid", ap.ApID).Info("backup ap")
+		err = backupAp(ctx, client, dir, ap.ApID)
+		if err != nil {
+			return
+		}
+		ret.Aps = append(ret.Aps, ap.ApID)
+	}
+
+	err = writeBackupFile(dir, ret, "manifest.json")
+	return
+}
+
+func backupStack(ctx context.Context,
+	client QcosClient, dir string, stackName string) (containers []ContainerAlertBackup, err error) {
+
+	export, err := client.GetStackExport(ctx, stackName)
+	if err != nil {
+		return
+	}
+	stackDir := filepath.Join("stacks", backupName(stackName))
+	err = writeBackupFile(dir, export, stackDir, "stack.json")
+	if err != nil {
+		return
+	}
+
+	services, err := client.ListServices(ctx, stackName)
+	if err != nil {
+		return
+	}
+	for _, svc := range services {
+		var (
+			svcExport ServiceExportInfo
+			alerts    []ContainerAlertInfo
+		)
+		svcExport, err = client.GetServiceExport(ctx, stackName, svc.Name)
+		if err != nil {
+			return
+		}
+		err = writeBackupFile(dir, svcExport, stackDir, "services", backupName(svc.Name)+".json")
+		if err != nil {
+			return
+		}
+
+		alerts, err = client.GetServiceAlert(ctx, stackName, svc.Name, "")
+		if err != nil && !isNotFound(err) {
+			return
+		}
+		err = nil
+		if len(alerts) > 0 {
+			err = writeBackupFile(dir, alerts, stackDir, "alerts", backupName(svc.Name)+".json")
+			if err != nil {
+				return
+			}
+		}
+
+		for _, ip := range svc.ContainerIPs {
+			alerts, err = client.GetContainerAlert(ctx, ip, "")
+			if err != nil && !isNotFound(err) {
+				return
+			}
+			err = nil
+			if len(alerts) > 0 {
+				containers = append(containers, ContainerAlertBackup{
+					IP:      ip,
+					Stack:   stackName,
+					Service: svc.Name,
+					Alerts:  alerts,
+				})
+			}
+		}
+	}
+	return
+}
+
+func backupAp(ctx context.Context, client QcosClient, dir string, apid string) (err error) {
+	ap, err := client.GetAp(ctx, apid)
+	if err != nil {
+		return
+	}
+	apDir := filepath.Join("aps", backupName(apid))
+	err = writeBackupFile(dir, ap, apDir, "ap.json")
+	if err != nil {
+		return
+	}
+
+	alerts, err := client.GetApAlert(ctx, apid, "")
+	if err != nil {
+		if isNotFound(err) {
+			err = nil
+		}
+		return
+	}
+	if len(alerts) > 0 {
+		err = writeBackupFile(dir, alerts, apDir, "alerts.json")
+	}
+	return
+}
+
+// RestoreApp recreates the configuration saved by BackupApp in dir into the
+// app behind client. Resources are restored in dependency order: config
+// services, stacks and their services, jobs, APs, and finally alert rules.
+//
+// APs are matched against existing APs by title; an AP without title is
+// always created. Container options of AP ports are not restored since
+// container IPs change, and container alerts are only restored for IPs that
+// still belong to the same service.
+func RestoreApp(ctx context.Context,
+	client QcosClient, dir string, opts RestoreAppOpts) (ret RestoreAppResult, err error) {
+
+	if opts.Conflict == "" {
+		opts.Conflict = RestoreConflictSkip
+	}
+	r := &appRestorer{
+		client:  client,
+		dir:     dir,
+		opts:    opts,
+		log:     loggerOf(client, opts.Logger),
+		apids:   make(map[string]string),
+		skipped: make(map[string]bool),
+	}
+
+	var manifest BackupManifest
+	err = readBackupFile(dir, &manifest, "manifest.json")
+	if err != nil {
+		return
+	}
+	if manifest.Version <= 0 || manifest.Version > BackupFormatVersion {
+		err = ErrBackupVersion
+		return
+	}
+
+	for _, namespace := range manifest.ConfigServices {
+		if err = r.restoreConfigService(ctx, namespace); err != nil {
+			return r.result, err
+		}
+	}
+	for _, stack := range manifest.Stacks {
+		if err = r.restoreStack(ctx, stack); err != nil {
+			return r.result, err
+		}
+	}
+	for _, job := range manifest.Jobs {
+		if err = r.restoreJob(ctx, job); err != nil {
+			return r.result, err
+		}
+	}
+	for _, apid := range manifest.Aps {
+		if err = r.restoreAp(ctx, apid); err != nil {
+			return r.result, err
+		}
+	}
+	for _, stack := range manifest.Stacks {
+		if err = r.restoreServiceAlerts(ctx, stack); err != nil {
+			return r.result, err
+		}
+	}
+	for _, apid := range manifest.Aps {
+		if err = r.restoreApAlerts(ctx, apid); err != nil {
+			return r.result, err
+		}
+	}
+	for _, ip := range manifest.Containers {
+		if err = r.restoreContainerAlerts(ctx, ip); err != nil {
+			return r.result, err
+		}
+	}
+	return r.result, nil
+}
+
+type appRestorer struct {
+	client  QcosClient
+	dir     string
+	opts    RestoreAppOpts
+	log     *logrus.Logger
+	result  RestoreAppResult
+	apids   map[string]string // backed up apid -> restored apid
+	skipped map[string]bool
+}
+
+// conflict decides what to do with an existing resource. It returns true if
+// the resource should be overwritten.
+func (r *appRestorer) conflict(name string) (overwrite bool, err error) {
+	switch r.opts.Conflict {
+	case RestoreConflictOverwrite:
+		return true, nil
+	case RestoreConflictFail:
+		return false, fmt.Errorf("%s: %v", name, ErrRestoreConflict)
+	default:
+		r.log.WithField("resource", name).Info("restore skip existing")
+		r.result.Skipped = append(r.result.Skipped, name)
+		r.skipped[name] = true
+		return false, nil
+	}
+}
+
+func (r *appRestorer) created(name string) {
+	r.log.WithField("resource", name).Info("restore created")
+	r.result.Created = append(r.result.Created, name)
+}
+
+func (r *appRestorer) updated(name string) {
+	r.log.WithField("resource", name).Info("restore updated")
+	r.result.Updated = append(r.result.Updated, name)
+}
+
+func (r *appRestorer) restoreConfigService(ctx context.Context, namespace string) (err error) {
+	var spec ConfigServiceSpecInfo
+	err = readBackupFile(r.dir, &spec, "configservices", backupName(namespace)+".json")
+	if err != nil {
+		return
+	}
+
+	name := "configservice/" + namespace
+	_, err = r.client.GetConfigServiceSpec(ctx, namespace)
+	if isNotFound(err) {
+		err = r.client.CreateConfigServiceSpec(ctx, CreateConfigServiceSpecArgs(spec))
+		if err == nil {
+			r.created(name)
+		}
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	overwrite, err := r.conflict(name)
+	if err != nil || !overwrite {
+		return
+	}
+	err = r.client.UpdateConfigServiceSpec(ctx, namespace, UpdateConfigServiceSpecArgs{
+		Vars:     spec.Vars,
+		Listvars: spec.Listvars,
+	})
+	if err == nil {
+		r.updated(name)
+	}
+	return
+}
+
+func (r *appRestorer) restoreStack(ctx context.Context, stackName string) (err error) {
+	stackDir := filepath.Join("stacks", backupName(stackName))
+
+	var export CreateStackArgs
+	err = readBackupFile(r.dir, &export, stackDir, "stack.json")
+	if err != nil {
+		return
+	}
+	services, err := r.readServiceExports(stackDir, export)
+	if err != nil {
+		return
+	}
+
+	name := "stack/" + stackName
+	_, err = r.client.GetStack(ctx, stackName)
+	if isNotFound(err) {
+		args := CreateStackArgs{
+			Name:     export.Name,
+			Metadata: export.Metadata,
+		}
+		for _, svc := range services {
+			args.Services = append(args.Services, svc.ToCreateServiceArgs())
+		}
+		if r.opts.Sync {
+			err = r.client.SyncCreateStack(ctx, args)
+		} else {
+			err = r.client.CreateStack(ctx, args)
+		}
+		if err == nil {
+			r.created(name)
+		}
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	overwrite, err := r.conflict(name)
+	if err != nil {
+		return
+	}
+	if overwrite {
+		err = r.client.UpdateStack(ctx, stackName, UpdateStackArgs{Metadata: export.Metadata})
+		if err != nil {
+			return
+		}
+		r.updated(name)
+	}
+
+	// the policy applies to each service, those missing from the existing
+	// stack being created
+	for _, svc := range services {
+		err = r.restoreService(ctx, stackName, svc)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// readServiceExports prefers the per service export files and falls back to
+// the services embedded in the stack export.
+func (r *appRestorer) readServiceExports(
+	stackDir string, export CreateStackArgs) (ret []ServiceExportInfo, err error) {
+
+	for _, svc := range export.Services {
+		var info ServiceExportInfo
+		err = readBackupFile(r.dir, &info, stackDir, "services", backupName(svc.Name)+".json")
+		if os.IsNotExist(err) {
+			info = ServiceExportInfo{
+				InstanceNum:       svc.InstanceNum,
+				UpdateParallelism: svc.UpdateParallelism,
+				Metadata:          svc.Metadata,
+				Name:              svc.Name,
+				Spec:              serviceSpecToExport(svc.Spec),
+				Stateful:          svc.Stateful,
+				Volumes:           svc.Volumes,
+			}
+			err = nil
+		}
+		if err != nil {
+			return
+		}
+		ret = append(ret, info)
+	}
+	return
+}
+
+func (r *appRestorer) restoreService(
+	ctx context.Context, stackName string, svc ServiceExportInfo) (err error) {
+
+	name := "service/" + stackName + "/" + svc.Name
+	current, err := r.client.GetServiceInspect(ctx, stackName, svc.Name)
+	if isNotFound(err) {
+		if r.opts.Sync {
+			err = r.client.SyncCreateService(ctx, stackName, svc.ToCreateServiceArgs())
+		} else {
+			err = r.client.CreateService(ctx, stackName, svc.ToCreateServiceArgs())
+		}
+		if err == nil {
+			r.created(name)
+		}
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	overwrite, err := r.conflict(name)
+	if err != nil || !overwrite {
+		return
+	}
+	if r.opts.Sync {
+		err = r.client.SyncUpdateService(ctx, stackName, svc.Name, svc.ToUpdateServiceArgs())
+	} else {
+		err = r.client.UpdateService(ctx, stackName, svc.Name, svc.ToUpdateServiceArgs())
+	}
+	if err != nil {
+		return
+	}
+	if current.InstanceNum != svc.InstanceNum {
+		err = r.client.ScaleService(ctx, stackName, svc.Name, ScaleServiceArgs{InstanceNum: svc.InstanceNum})
+		if err != nil {
+			return
+		}
+	}
+	r.updated(name)
+	return
+}
+
+func (r *appRestorer) restoreJob(ctx context.Context, jobName string) (err error) {
+	var job JobInfo
+	err = readBackupFile(r.dir, &job, "jobs", backupName(jobName)+".json")
+	if err != nil {
+		return
+	}
+
+	name := "job/" + jobName
+	_, err = r.client.GetJob(ctx, jobName)
+	if isNotFound(err) {
+		err = r.client.CreateJob(ctx, job.ToCreateJobArgs())
+		if err == nil {
+			r.created(name)
+		}
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	overwrite, err := r.conflict(name)
+	if err != nil || !overwrite {
+		return
+	}
+	err = r.client.UpdateJob(ctx, jobName, job.ToUpdateJobArgs())
+	if err == nil {
+		r.updated(name)
+	}
+	return
+}
+
+func (r *appRestorer) restoreAp(ctx context.Context, apid string) (err error) {
+	var ap FullApInfo
+	err = readBackupFile(r.dir, &ap, "aps", backupName(apid), "ap.json")
+	if err != nil {
+		return
+	}
+
+	name := "ap/" + apid
+	existing, err := r.findAp(ctx, ap)
+	if err != nil {
+		return
+	}
+
+	target := existing
+	published := make(map[string]bool)
+	if target == "" {
+		var created ListApInfo
+		created, err = r.client.CreateAp(ctx, ap.ToCreateApArgs())
+		if err != nil {
+			return
+		}
+		target = created.ApID
+		r.created(name)
+	} else {
+		var overwrite bool
+		overwrite, err = r.conflict(name)
+		if err != nil {
+			return
+		}
+		if !overwrite {
+			return
+		}
+		// domains already bound to the AP can not be published again
+		var info FullApInfo
+		if info, err = r.client.GetAp(ctx, target); err != nil {
+			return
+		}
+		for _, domain := range info.UserDomains {
+			published[domain] = true
+		}
+		err = r.client.UpdateAp(ctx, target, ap.ToSetApDescArgs())
+		if err != nil {
+			return
+		}
+		r.updated(name)
+	}
+	r.apids[apid] = target
+
+	for _, port := range ap.Ports {
+		err = r.restoreApPort(ctx, target, port)
+		if err != nil {
+			return
+		}
+	}
+	for _, domain := range ap.UserDomains {
+		if published[domain] {
+			continue
+		}
+		err = r.client.PublishUserDomain(ctx, target, SetUserDomainArgs{UserDomain: domain})
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (r *appRestorer) findAp(ctx context.Context, ap FullApInfo) (apid string, err error) {
+	if ap.Title == "" {
+		return
+	}
+	aps, err := r.client.ListAps(ctx, ListApsArgs{Title: ap.Title})
+	if err != nil {
+		return
+	}
+	for _, info := range aps {
+		if info.Title == ap.Title && info.Type == ap.Type {
+			return info.ApID, nil
+		}
+	}
+	return
+}
+
+func (r *appRestorer) restoreApPort(ctx context.Context, apid string, port ApPortInfo) (err error) {
+	if i := strings.Index(port.FPort, "-"); i > 0 {
+		from, to := port.FPort[:i], port.FPort[i+1:]
+		err = r.client.SetApPortRange(ctx, apid, from, to, SetApPortRangeArgs{
+			Proto:             port.Proto,
+			SessionTimeoutSec: port.SessionTmoSec,
+			Backends:          port.BackendArgs(),
+		})
+		if err == nil && !port.Enabled {
+			err = r.client.DisableApPortRange(ctx, apid, from, to)
+		}
+		return
+	}
+
+	args, err := port.ToSetApPortArgs()
+	if err != nil {
+		return fmt.Errorf("ap %s: %v", apid, err)
+	}
+	err = r.client.SetApPort(ctx, apid, port.FPort, args)
+	if err == nil && !port.Enabled {
+		err = r.client.DisableApPort(ctx, apid, port.FPort)
+	}
+	return
+}
+
+func (r *appRestorer) restoreServiceAlerts(ctx context.Context, stackName string) (err error) {
+	alertDir := filepath.Join(r.dir, "stacks", backupName(stackName), "alerts")
+	files, err := ioutil.ReadDir(alertDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	for _, fi := range files {
+		if fi.IsDir() || filepath.Ext(fi.Name()) != ".json" {
+			continue
+		}
+		var (
+			alerts  []ContainerAlertInfo
+			service string
+		)
+		service, err = url.QueryUnescape(strings.TrimSuffix(fi.Name(), ".json"))
+		if err != nil {
+			return
+		}
+		if r.skipped["service/"+stackName+"/"+service] {
+			continue
+		}
+		err = readBackupFile(alertDir, &alerts, fi.Name())
+		if err != nil {
+			return
+		}
+		for _, alert := range alerts {
+			err = r.client.UpdateServiceAlert(ctx, stackName, service, UpdateContainerAlertArgs(alert))
+			if err != nil {
+				return
+			}
+		}
+		r.updated("alert/service/" + stackName + "/" + service)
+	}
+	return
+}
+
+func (r *appRestorer) restoreApAlerts(ctx context.Context, apid string) (err error) {
+	target, ok := r.apids[apid]
+	if !ok {
+		return
+	}
+
+	var alerts []ApAlertInfo
+	err = readBackupFile(r.dir, &alerts, "aps", backupName(apid), "alerts.json")
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	for _, alert := range alerts {
+		err = r.client.UpdateApAlert(ctx, target, UpdateApAlertArgs(alert))
+		if err != nil {
+			return
+		}
+	}
+	r.updated("alert/ap/" + apid)
+	return
+}
+
+func (r *appRestorer) restoreContainerAlerts(ctx context.Context, ip string) (err error) {
+	var backup ContainerAlertBackup
+	err = readBackupFile(r.dir, &backup, "containers", backupName(ip), "alerts.json")
+	if err != nil {
+		return
+	}
+
+	name := "alert/container/" + ip
+	ips, err := r.client.ListContainers(ctx, ListContainersArgs{
+		StackName:   backup.Stack,
+		ServiceName: backup.Service,
+	})
+	if err != nil {
+		return
+	}
+	found := false
+	for _, cur := range ips {
+		if cur == ip {
+			found = true
+			break
+		}
+	}
+	if !found {
+		r.log.WithField("resource", name).Warn("restore skip alerts of missing container")
+		r.result.Skipped = append(r.result.Skipped, name)
+		return
+	}
+
+	for _, alert := range backup.Alerts {
+		err = r.client.UpdateContainerAlert(ctx, ip, UpdateContainerAlertArgs(alert))
+		if err != nil {
+			return
+		}
+	}
+	r.updated(name)
+	return
+}
+
+func backupName(name string) string {
+	return url.QueryEscape(name)
+}
+
+func writeBackupFile(dir string, v interface{}, elem ...string) (err error) {
+	file := filepath.Join(append([]string{dir}, elem...)...)
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return
+	}
+	return ioutil.WriteFile(file, b, 0644)
+}
+
+func readBackupFile(dir string, v interface{}, elem ...string) (err error) {
+	b, err := ioutil.ReadFile(filepath.Join(append([]string{dir}, elem...)...))
+	if err != nil {
+		return
+	}
+	return json.Unmarshal(b, v)
+}
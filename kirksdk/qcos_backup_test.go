@@ -0,0 +1,182 @@
+package kirksdk
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newBackupSource() *mockQcosClient {
+	src := newMockQcosClient()
+	src.CreateStack(context.TODO(), CreateStackArgs{
+		Name:     "web",
+		Metadata: []string{"team=payments"},
+		Services: []CreateServiceArgs{{
+			Name:        "nginx",
+			InstanceNum: 2,
+			Spec:        ServiceSpec{Image: "nginx:1.11", Envs: []string{"a=1"}},
+		}},
+	})
+	src.serviceAlerts["web/nginx"] = []ContainerAlertInfo{{Level: "warn"}}
+	src.CreateJob(context.TODO(), CreateJobArgs{Name: "cron", Mode: "MANUAL"})
+	src.CreateConfigServiceSpec(context.TODO(), CreateConfigServiceSpecArgs{Namespace: "conf"})
+	src.aps["1001"] = FullApInfo{
+		Type:  ApTypeDomainStr,
+		Title: "www",
+		Ports: []ApPortInfo{{FPort: "80", BPort: "8080", Proto: "HTTP", Enabled: true}},
+	}
+	src.aps["1001"].Ports[0].Backends = append(src.aps["1001"].Ports[0].Backends, apBackend("web", "nginx", ApBackendDefaultWeight))
+	src.apAlerts["1001"] = []ApAlertInfo{{Level: "warn"}}
+	src.calls = nil
+	return src
+}
+
+func TestBackupRestoreApp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kirk-backup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := newBackupSource()
+	manifest, err := BackupApp(context.TODO(), src, dir, BackupAppOpts{})
+	assert.NoError(t, err)
+	assert.Equal(t, BackupFormatVersion, manifest.Version)
+	assert.Equal(t, []string{"web"}, manifest.Stacks)
+	assert.Equal(t, []string{"1001"}, manifest.Aps)
+
+	for _, file := range []string{
+		"manifest.json",
+		"stacks/web/stack.json",
+		"stacks/web/services/nginx.json",
+		"stacks/web/alerts/nginx.json",
+		"jobs/cron.json",
+		"aps/1001/ap.json",
+		"aps/1001/alerts.json",
+		"configservices/conf.json",
+	} {
+		_, err := os.Stat(filepath.Join(dir, file))
+		assert.NoError(t, err, file)
+	}
+
+	dst := newMockQcosClient()
+	ret, err := RestoreApp(context.TODO(), dst, dir, RestoreAppOpts{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"CreateConfigServiceSpec conf",
+		"CreateStack web",
+		"CreateJob cron",
+		"CreateAp www",
+		"SetApPort new-www 80",
+		"UpdateServiceAlert web/nginx",
+		"UpdateApAlert new-www",
+	}, dst.calls)
+	assert.Equal(t, 4, len(ret.Created))
+	assert.Equal(t, "nginx:1.11", dst.services["web/nginx"].Spec.Image)
+	assert.Equal(t, 2, dst.services["web/nginx"].InstanceNum)
+}
+
+func TestRestoreAppConflict(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kirk-backup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := newBackupSource()
+	_, err = BackupApp(context.TODO(), src, dir, BackupAppOpts{})
+	assert.NoError(t, err)
+
+	ret, err := RestoreApp(context.TODO(), src, dir, RestoreAppOpts{})
+	assert.NoError(t, err)
+	assert.Empty(t, ret.Created)
+	assert.Equal(t, []string{"configservice/conf", "stack/web", "service/web/nginx", "job/cron", "ap/1001"}, ret.Skipped)
+	assert.Empty(t, src.calls)
+
+	_, err = RestoreApp(context.TODO(), src, dir, RestoreAppOpts{Conflict: RestoreConflictFail})
+	assert.Error(t, err)
+
+	src.calls = nil
+	src.configServices = map[string]ConfigServiceSpecInfo{}
+	src.jobs = map[string]JobInfo{}
+	src.aps = map[string]FullApInfo{}
+	src.apAlerts = map[string][]ApAlertInfo{}
+	src.serviceAlerts = map[string][]ContainerAlertInfo{}
+	_, err = RestoreApp(context.TODO(), src, dir, RestoreAppOpts{Conflict: RestoreConflictOverwrite})
+	assert.NoError(t, err)
+	assert.Contains(t, src.calls, "UpdateStack web")
+	assert.Contains(t, src.calls, "UpdateService web/nginx")
+}
+
+func TestRestoreAppPartialStack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kirk-backup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := newBackupSource()
+	src.CreateService(context.TODO(), "web", CreateServiceArgs{
+		Name:        "redis",
+		InstanceNum: 1,
+		Spec:        ServiceSpec{Image: "redis:3.2"},
+	})
+	src.serviceAlerts["web/redis"] = []ContainerAlertInfo{{Level: "warn"}}
+	_, err = BackupApp(context.TODO(), src, dir, BackupAppOpts{})
+	assert.NoError(t, err)
+
+	// the stack exists with only nginx, changed since the backup
+	dst := newMockQcosClient()
+	dst.CreateStack(context.TODO(), CreateStackArgs{
+		Name:     "web",
+		Services: []CreateServiceArgs{{Name: "nginx", InstanceNum: 1, Spec: ServiceSpec{Image: "nginx:1.12"}}},
+	})
+	dst.calls = nil
+	ret, err := RestoreApp(context.TODO(), dst, dir, RestoreAppOpts{})
+	assert.NoError(t, err)
+	assert.Contains(t, ret.Skipped, "stack/web")
+	assert.Contains(t, ret.Skipped, "service/web/nginx")
+	assert.Contains(t, ret.Created, "service/web/redis")
+	assert.Contains(t, dst.calls, "CreateService web/redis")
+	assert.Contains(t, dst.calls, "UpdateServiceAlert web/redis")
+	assert.NotContains(t, dst.calls, "UpdateStack web")
+	assert.NotContains(t, dst.calls, "UpdateServiceAlert web/nginx")
+	assert.Equal(t, "nginx:1.12", dst.services["web/nginx"].Spec.Image)
+	assert.Equal(t, "redis:3.2", dst.services["web/redis"].Spec.Image)
+}
+
+func TestRestoreAppOverwriteAp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kirk-backup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := newBackupSource()
+	ap := src.aps["1001"]
+	ap.UserDomains = []string{"www.a.com", "www.b.com"}
+	src.aps["1001"] = ap
+	_, err = BackupApp(context.TODO(), src, dir, BackupAppOpts{})
+	assert.NoError(t, err)
+
+	// the AP is reused, with a domain already bound
+	ap.UserDomains = []string{"www.a.com"}
+	src.aps["1001"] = ap
+	src.configServices = map[string]ConfigServiceSpecInfo{}
+	src.jobs = map[string]JobInfo{}
+	src.calls = nil
+	_, err = RestoreApp(context.TODO(), src, dir, RestoreAppOpts{Conflict: RestoreConflictOverwrite})
+	assert.NoError(t, err)
+	assert.Contains(t, src.calls, "UpdateAp 1001")
+	assert.Contains(t, src.calls, "PublishUserDomain 1001 www.b.com")
+	assert.NotContains(t, src.calls, "PublishUserDomain 1001 www.a.com")
+	assert.Equal(t, []string{"www.a.com", "www.b.com"}, src.aps["1001"].UserDomains)
+
+	// options not exported are left to the AP
+	args, err := src.aps["1001"].Ports[0].ToSetApPortArgs()
+	assert.NoError(t, err)
+	assert.Nil(t, args.ProxyOpt)
+	assert.Nil(t, args.HealthCheck)
+	port := ApPortInfo{FPort: "80", BPort: "8080", ProxyOpts: DefaultApProxyOpts}
+	args, err = port.ToSetApPortArgs()
+	assert.NoError(t, err)
+	assert.Equal(t, &DefaultApProxyOpts, args.ProxyOpt)
+	assert.Nil(t, args.HealthCheck)
+}
@@ -0,0 +1,371 @@
+package kirksdk
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+var (
+	ErrNoBlueBackend = errors.New("ap port has no backend of the blue service")
+	ErrGreenExists   = errors.New("green service already exists")
+)
+
+const greenSuffix = "-green"
+
+// ApPortRef refers to a port of an AP.
+type ApPortRef struct {
+	ApID string
+	Port string
+}
+
+// BlueGreenEvent reports the progress of a blue/green deployment.
+// GreenPercent is the share of the blue traffic sent to green.
+type BlueGreenEvent struct {
+	Stack        string       `json:"stack"`
+	Blue         string       `json:"blue"`
+	Green        string       `json:"green"`
+	Phase        RolloutPhase `json:"phase"`
+	Step         int          `json:"step"`
+	Steps        int          `json:"steps"`
+	GreenPercent int          `json:"greenPercent"`
+	Gate         string       `json:"gate,omitempty"`
+	Err          error        `json:"-"`
+}
+
+type BlueGreenOpts struct {
+	// Name of the green service. Default "<blue>-green", or blue without
+	// the suffix if it has one, so that successive deployments alternate
+	// between two names.
+	Green string
+
+	Ports []ApPortRef
+
+	// Cumulative percentages of traffic shifted to green after each step.
+	// Default {10, 50, 100}.
+	Increments []int
+
+	// Time to wait after a step before checking the gates.
+	Bake time.Duration
+
+	// Timeout of waiting for green to run. Default 120s.
+	WaitTimeout time.Duration
+
+	// Interval of polling the green service. Default 2s.
+	PollInterval time.Duration
+
+	// Gates checked on the green service after each step, in addition to
+	// the health check of every port.
+	Gates []RolloutGate
+
+	// Delete the blue service once all traffic goes to green. Otherwise blue
+	// is kept with weight 0 so that it can be switched back, and must be
+	// deleted before the next deployment reuses its name.
+	DeleteBlue bool
+
+	// Keep the green service when the deployment fails.
+	KeepGreenOnFailure bool
+
+	OnEvent func(BlueGreenEvent)
+	Logger  *logrus.Logger
+}
+
+// BlueGreenDeploy creates a green copy of the blue service with spec, waits
+// for it to run, then shifts the traffic of the given AP ports from blue to
+// green step by step with SetApPort. The health of every port and the gates
+// are checked after each step. On failure the port weights are restored and
+// green is deleted, both with their own context so that this is done even
+// if ctx is canceled. The container ratios of the ports are kept.
+//
+// The deployment fails with ErrGreenExists if green is already a service.
+// Once all traffic goes to green, a failure to delete blue is returned as a
+// plain error rather than a RolloutError, the deployment being complete.
+func BlueGreenDeploy(ctx context.Context, client QcosClient,
+	stackName, blue string, spec ServiceSpec, opts BlueGreenOpts) (green string, err error) {
+
+	if stackName == "" {
+		stackName = DefaultStack
+	}
+	if opts.Green == "" {
+		opts.Green = greenName(blue)
+	}
+	if len(opts.Increments) == 0 {
+		opts.Increments = []int{10, 50, 100}
+	}
+	if opts.WaitTimeout == 0 {
+		opts.WaitTimeout = waitTimeout
+	}
+	if opts.PollInterval == 0 {
+		opts.PollInterval = 2 * time.Second
+	}
+	d := &blueGreenDeploy{
+		client: client,
+		stack:  stackName,
+		blue:   blue,
+		green:  opts.Green,
+		opts:   opts,
+		log:    loggerOf(client, opts.Logger),
+		ports:  make(map[ApPortRef]SetApPortArgs),
+		ratios: make(map[ApPortRef][]SetApContainerOptionsArgs),
+	}
+	return d.green, d.run(ctx, spec)
+}
+
+// greenName alternates between the name without and with greenSuffix.
+func greenName(blue string) string {
+	if strings.HasSuffix(blue, greenSuffix) && len(blue) > len(greenSuffix) {
+		return strings.TrimSuffix(blue, greenSuffix)
+	}
+	return blue + greenSuffix
+}
+
+type blueGreenDeploy struct {
+	client QcosClient
+	stack  string
+	blue   string
+	green  string
+	opts   BlueGreenOpts
+	log    *logrus.Logger
+	steps  []int
+
+	// original settings of the ports, restored on failure, and their
+	// container ratios, which SetApPort drops
+	ports  map[ApPortRef]SetApPortArgs
+	ratios map[ApPortRef][]SetApContainerOptionsArgs
+}
+
+func (p *blueGreenDeploy) emit(phase RolloutPhase, step int, gate string, err error) {
+	ev := BlueGreenEvent{
+		Stack: p.stack,
+		Blue:  p.blue,
+		Green: p.green,
+		Phase: phase,
+		Step:  step,
+		Steps: len(p.steps),
+		Gate:  gate,
+		Err:   err,
+	}
+	if step > 0 {
+		ev.GreenPercent = p.steps[step-1]
+	}
+	entry := p.log.WithFields(logrus.Fields{
+		"service": p.stack + "/" + p.blue,
+		"green":   p.green,
+	})
+	if err != nil {
+		entry.Warnf("blue/green %s at %d%%: %v", phase, ev.GreenPercent, err)
+	} else {
+		entry.Infof("blue/green %s at %d%%", phase, ev.GreenPercent)
+	}
+	if p.opts.OnEvent != nil {
+		p.opts.OnEvent(ev)
+	}
+}
+
+func (p *blueGreenDeploy) run(ctx context.Context, spec ServiceSpec) (err error) {
+	p.steps = blueGreenSteps(p.opts.Increments)
+
+	for _, port := range p.opts.Ports {
+		var args SetApPortArgs
+		if args, err = p.portArgs(ctx, port); err != nil {
+			return &RolloutError{Err: err}
+		}
+		p.ports[port] = args
+	}
+
+	export, err := p.client.GetServiceExport(ctx, p.stack, p.blue)
+	if err != nil {
+		return &RolloutError{Err: err}
+	}
+	_, err = p.client.GetServiceInspect(ctx, p.stack, p.green)
+	if err == nil {
+		return &RolloutError{Err: ErrGreenExists}
+	}
+	if !isNotFound(err) {
+		return &RolloutError{Err: err}
+	}
+	args := export.ToCreateServiceArgs()
+	args.Name = p.green
+	args.Spec = spec
+	if err = p.client.CreateService(ctx, p.stack, args); err != nil {
+		return &RolloutError{Err: err}
+	}
+	p.emit(RolloutStarted, 0, "", nil)
+
+	_, err = pollService(ctx, p.client, p.stack, p.green, p.opts.PollInterval, p.opts.WaitTimeout,
+		func(svc ServiceInfo) (bool, error) {
+			if svc.Status == StatusFault {
+				return false, fmt.Errorf("service is %s", svc.Status)
+			}
+			return svc.State == StateDeployed && svc.Status == StatusRunning, nil
+		})
+	if err != nil {
+		return p.rollback(0, "", err)
+	}
+
+	for i, pct := range p.steps {
+		step := i + 1
+		for _, port := range p.opts.Ports {
+			if err = p.setPort(ctx, port, p.shift(p.ports[port], pct)); err != nil {
+				return p.rollback(step, "", err)
+			}
+		}
+		p.emit(RolloutStepDone, step, "", nil)
+
+		if err = sleepContext(ctx, p.opts.Bake); err != nil {
+			return p.rollback(step, "", err)
+		}
+		var svc ServiceInfo
+		if svc, err = p.client.GetServiceInspect(ctx, p.stack, p.green); err != nil {
+			return p.rollback(step, "", err)
+		}
+		gates := make([]RolloutGate, 0, len(p.opts.Ports)+len(p.opts.Gates))
+		for _, port := range p.opts.Ports {
+			gates = append(gates, ApHealthGate(port.ApID, port.Port))
+		}
+		for _, gate := range append(gates, p.opts.Gates...) {
+			if err = gate.Check(ctx, p.client, svc); err != nil {
+				p.emit(RolloutGateFailed, step, gate.Name, err)
+				return p.rollback(step, gate.Name, err)
+			}
+			p.emit(RolloutGatePassed, step, gate.Name, nil)
+		}
+	}
+
+	p.emit(RolloutCompleted, len(p.steps), "", nil)
+
+	if p.opts.DeleteBlue {
+		for _, port := range p.opts.Ports {
+			if err = p.setPort(ctx, port, p.dropBlue(p.ports[port])); err != nil {
+				return fmt.Errorf("remove %s/%s from ap %s port %s: %v", p.stack, p.blue, port.ApID, port.Port, err)
+			}
+		}
+		if err = p.client.DeleteService(ctx, p.stack, p.blue); err != nil {
+			return fmt.Errorf("delete %s/%s: %v", p.stack, p.blue, err)
+		}
+	}
+	return nil
+}
+
+// setPort sets the port, then its container ratios back.
+func (p *blueGreenDeploy) setPort(ctx context.Context, port ApPortRef, args SetApPortArgs) error {
+	if err := p.client.SetApPort(ctx, port.ApID, port.Port, args); err != nil {
+		return err
+	}
+	if ratios := p.ratios[port]; len(ratios) > 0 {
+		return p.client.ApSetContainer(ctx, port.ApID, port.Port, ratios)
+	}
+	return nil
+}
+
+func (p *blueGreenDeploy) portArgs(ctx context.Context, port ApPortRef) (args SetApPortArgs, err error) {
+	ap, err := p.client.GetAp(ctx, port.ApID)
+	if err != nil {
+		return
+	}
+	for _, info := range ap.Ports {
+		if info.FPort != port.Port {
+			continue
+		}
+		if args, err = info.ToSetApPortArgs(); err != nil {
+			return
+		}
+		for _, opt := range info.ContainerOptions {
+			p.ratios[port] = append(p.ratios[port], SetApContainerOptionsArgs{IP: opt.CotainerIP, Ratio: opt.Ratio})
+		}
+		for _, b := range args.Backends {
+			if b.Stack == p.stack && b.Service == p.blue {
+				return args, nil
+			}
+		}
+		return args, fmt.Errorf("ap %s port %s: %v", port.ApID, port.Port, ErrNoBlueBackend)
+	}
+	return args, fmt.Errorf("ap %s port %s not found", port.ApID, port.Port)
+}
+
+// shift moves pct percent of the blue weight to green.
+func (p *blueGreenDeploy) shift(orig SetApPortArgs, pct int) SetApPortArgs {
+	args := orig
+	args.Backends = nil
+	for _, b := range orig.Backends {
+		if b.Stack == p.stack && b.Service == p.blue {
+			green := b.Weight * pct / 100
+			args.Backends = append(args.Backends,
+				ApBackendArgs{Stack: p.stack, Service: p.blue, Weight: b.Weight - green},
+				ApBackendArgs{Stack: p.stack, Service: p.green, Weight: green})
+			continue
+		}
+		args.Backends = append(args.Backends, b)
+	}
+	return args
+}
+
+// dropBlue sends all the blue weight to green and removes the blue backend.
+func (p *blueGreenDeploy) dropBlue(orig SetApPortArgs) SetApPortArgs {
+	args := p.shift(orig, 100)
+	backends := args.Backends[:0:0]
+	for _, b := range args.Backends {
+		if b.Stack != p.stack || b.Service != p.blue {
+			backends = append(backends, b)
+		}
+	}
+	args.Backends = backends
+	return args
+}
+
+func (p *blueGreenDeploy) rollback(step int, gate string, cause error) error {
+	p.emit(RolloutRollingBack, step, gate, cause)
+	ret := &RolloutError{Step: step, Gate: gate, Err: cause}
+
+	// before the first step, no traffic was moved to green
+	ctx, cancel := context.WithTimeout(context.Background(), p.opts.WaitTimeout)
+	defer cancel()
+	if step > 0 {
+		for _, port := range p.opts.Ports {
+			if err := p.setPort(ctx, port, p.ports[port]); err != nil {
+				p.log.Errorf("restore ap %s port %s failed: %v", port.ApID, port.Port, err)
+				if ret.RollbackErr == nil {
+					ret.RollbackErr = fmt.Errorf("restore ap %s port %s: %v", port.ApID, port.Port, err)
+				}
+			}
+		}
+	}
+	if ret.RollbackErr != nil {
+		return ret
+	}
+	if !p.opts.KeepGreenOnFailure {
+		if err := p.client.DeleteService(ctx, p.stack, p.green); err != nil {
+			p.log.Errorf("delete %s/%s failed: %v", p.stack, p.green, err)
+		}
+	}
+	if step > 0 {
+		ret.RolledBack = true
+		p.emit(RolloutRolledBack, step, gate, nil)
+	}
+	return ret
+}
+
+func blueGreenSteps(increments []int) (steps []int) {
+	pcts := append([]int(nil), increments...)
+	sort.Ints(pcts)
+	for _, pct := range pcts {
+		if pct <= 0 {
+			continue
+		}
+		if pct > 100 {
+			pct = 100
+		}
+		if len(steps) == 0 || pct > steps[len(steps)-1] {
+			steps = append(steps, pct)
+		}
+	}
+	if len(steps) == 0 || steps[len(steps)-1] != 100 {
+		steps = append(steps, 100)
+	}
+	return
+}
@@ -0,0 +1,169 @@
+package kirksdk
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newBlueGreenTestClient() *mockQcosClient {
+	client := newMockQcosClient()
+	client.CreateStack(context.TODO(), CreateStackArgs{
+		Name: "web",
+		Services: []CreateServiceArgs{
+			{Name: "nginx", InstanceNum: 2, Spec: ServiceSpec{Image: "nginx:1.10"}},
+			{Name: "static", InstanceNum: 1, Spec: ServiceSpec{Image: "nginx:1.10"}},
+		},
+	})
+	client.SetApPort(context.TODO(), "ap1", "80", SetApPortArgs{
+		Proto:       "HTTP",
+		BackendPort: 8080,
+		Backends: []ApBackendArgs{
+			{Stack: "web", Service: "nginx", Weight: 1000},
+			{Stack: "web", Service: "static", Weight: 100},
+		},
+	})
+	client.ApSetContainer(context.TODO(), "ap1", "80", []SetApContainerOptionsArgs{{IP: "10.0.0.9", Ratio: 0.5}})
+	client.healthchecks["ap1/80"] = map[string]string{"10.0.0.1": "ok"}
+	client.calls = nil
+	return client
+}
+
+func apWeights(client *mockQcosClient, apid, port string) map[string]int {
+	ret := make(map[string]int)
+	for _, info := range client.aps[apid].Ports {
+		if info.FPort == port {
+			for _, b := range info.Backends {
+				ret[b.Service] = b.DefaultWeight
+			}
+		}
+	}
+	return ret
+}
+
+func TestBlueGreenDeploy(t *testing.T) {
+	client := newBlueGreenTestClient()
+
+	var weights []map[string]int
+	green, err := BlueGreenDeploy(context.TODO(), client, "web", "nginx",
+		ServiceSpec{Image: "nginx:1.11"},
+		BlueGreenOpts{
+			Ports:        []ApPortRef{{"ap1", "80"}},
+			Increments:   []int{50, 10},
+			PollInterval: time.Millisecond,
+			DeleteBlue:   true,
+			OnEvent: func(ev BlueGreenEvent) {
+				if ev.Phase == RolloutStepDone {
+					weights = append(weights, apWeights(client, "ap1", "80"))
+				}
+			},
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, "nginx-green", green)
+	assert.Equal(t, []map[string]int{
+		{"nginx": 900, "nginx-green": 100, "static": 100},
+		{"nginx": 500, "nginx-green": 500, "static": 100},
+		{"nginx": 0, "nginx-green": 1000, "static": 100},
+	}, weights)
+	assert.Equal(t, map[string]int{"nginx-green": 1000, "static": 100}, apWeights(client, "ap1", "80"))
+	assert.Equal(t, map[string]float64{"10.0.0.9": 0.5}, apRatios(client, "ap1", "80"))
+	assert.Equal(t, "nginx:1.11", client.services["web/nginx-green"].Spec.Image)
+	assert.Equal(t, 2, client.services["web/nginx-green"].InstanceNum)
+	assert.Equal(t, "DeleteService web/nginx", client.calls[len(client.calls)-1])
+	_, ok := client.services["web/nginx"]
+	assert.False(t, ok)
+}
+
+func TestBlueGreenDeployCycles(t *testing.T) {
+	client := newBlueGreenTestClient()
+	opts := BlueGreenOpts{
+		Ports:        []ApPortRef{{"ap1", "80"}},
+		PollInterval: time.Millisecond,
+		DeleteBlue:   true,
+	}
+
+	green, err := BlueGreenDeploy(context.TODO(), client, "web", "nginx", ServiceSpec{Image: "nginx:1.11"}, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "nginx-green", green)
+
+	green, err = BlueGreenDeploy(context.TODO(), client, "web", green, ServiceSpec{Image: "nginx:1.12"}, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "nginx", green)
+	assert.Equal(t, map[string]int{"nginx": 1000, "static": 100}, apWeights(client, "ap1", "80"))
+	assert.Equal(t, "nginx:1.12", client.services["web/nginx"].Spec.Image)
+	_, ok := client.services["web/nginx-green"]
+	assert.False(t, ok)
+
+	// blue is kept, so the next deployment cannot reuse its name
+	opts.DeleteBlue = false
+	green, err = BlueGreenDeploy(context.TODO(), client, "web", "nginx", ServiceSpec{Image: "nginx:1.13"}, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "nginx-green", green)
+	client.calls = nil
+	_, err = BlueGreenDeploy(context.TODO(), client, "web", green, ServiceSpec{Image: "nginx:1.14"}, opts)
+	if assert.IsType(t, &RolloutError{}, err) {
+		assert.Equal(t, ErrGreenExists, err.(*RolloutError).Err)
+	}
+	assert.NotContains(t, client.calls, "CreateService web/nginx")
+	assert.Equal(t, "nginx:1.12", client.services["web/nginx"].Spec.Image)
+}
+
+func TestBlueGreenDeployRollback(t *testing.T) {
+	client := newBlueGreenTestClient()
+
+	gate := RolloutGate{
+		Name: "fail-at-half",
+		Check: func(ctx context.Context, c QcosClient, svc ServiceInfo) error {
+			if apWeights(client, "ap1", "80")["nginx-green"] >= 500 {
+				return errors.New("latency too high")
+			}
+			return nil
+		},
+	}
+	_, err := BlueGreenDeploy(context.TODO(), client, "web", "nginx",
+		ServiceSpec{Image: "nginx:1.11"},
+		BlueGreenOpts{
+			Ports:        []ApPortRef{{"ap1", "80"}},
+			PollInterval: time.Millisecond,
+			Gates:        []RolloutGate{gate},
+		})
+	assert.Equal(t, &RolloutError{Step: 2, Gate: "fail-at-half", Err: errors.New("latency too high"), RolledBack: true}, err)
+	assert.Equal(t, map[string]int{"nginx": 1000, "static": 100}, apWeights(client, "ap1", "80"))
+	assert.Equal(t, map[string]float64{"10.0.0.9": 0.5}, apRatios(client, "ap1", "80"))
+	assert.Equal(t, "DeleteService web/nginx-green", client.calls[len(client.calls)-1])
+	_, ok := client.services["web/nginx"]
+	assert.True(t, ok)
+
+	_, err = BlueGreenDeploy(context.TODO(), client, "web", "static",
+		ServiceSpec{Image: "nginx:1.11"},
+		BlueGreenOpts{Ports: []ApPortRef{{"ap1", "81"}}})
+	assert.EqualError(t, err, "rollout failed at step 0: ap ap1 port 81 not found")
+	assert.False(t, err.(*RolloutError).RolledBack)
+}
+
+func TestBlueGreenDeployDeleteBlueFailed(t *testing.T) {
+	client := newBlueGreenTestClient()
+	client.errs["DeleteService web/nginx"] = errors.New("busy")
+
+	var last BlueGreenEvent
+	_, err := BlueGreenDeploy(context.TODO(), client, "web", "nginx",
+		ServiceSpec{Image: "nginx:1.11"},
+		BlueGreenOpts{
+			Ports:        []ApPortRef{{"ap1", "80"}},
+			Increments:   []int{100},
+			PollInterval: time.Millisecond,
+			DeleteBlue:   true,
+			OnEvent: func(ev BlueGreenEvent) {
+				last = ev
+			},
+		})
+	assert.EqualError(t, err, "delete web/nginx: busy")
+	_, ok := err.(*RolloutError)
+	assert.False(t, ok)
+	assert.Equal(t, RolloutCompleted, last.Phase)
+	assert.Equal(t, map[string]int{"nginx-green": 1000, "static": 100}, apWeights(client, "ap1", "80"))
+}
@@ -2,7 +2,9 @@ package kirksdk
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -304,21 +306,9 @@ func (p *qcosClientImp) SyncDeployService(ctx context.Context,
 	if err != nil {
 		return
 	}
-	if args.Operation == "ROLLBACK" || args.Operation == "COMPLETE" {
+	switch args.Op() {
+	case DeployOpComplete, DeployOpRollback:
 		err = p.wait4ServiceRunning(stackName, serviceName, waitTimeout)
-		if err != nil {
-			return
-		}
-	}
-	op := strings.Split(args.Operation, " ")
-	switch op[0] {
-	case "COMPLETE", "ROLLBACK":
-		err = p.wait4ServiceRunning(stackName, serviceName, waitTimeout)
-		if err != nil {
-			return
-		}
-	default:
-		return
 	}
 	return
 }
@@ -601,7 +591,11 @@ func (p *qcosClientImp) StartContainerExec(ctx context.Context, ip string, execI
 		}
 	}()
 
-	req, err := http.NewRequest("POST", url, nil)
+	body, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
@@ -669,111 +663,229 @@ func (p *qcosClientImp) StartContainerExec(ctx context.Context, ip string, execI
 		opts.ReadyCh <- struct{}{}
 		<-opts.ReadyCh
 	}
+	if opts.InStream != nil {
+		// the input is no longer read once returned
+		in := &execInput{r: opts.InStream}
+		defer in.stop()
+		opts.InStream = in
+	}
+	if opts.Recorder != nil {
+		defer opts.Recorder.stop()
+		opts = opts.Recorder.record(ip, opts)
+	}
 
-	errch := make(chan error, 2)
+	// The exec ends when its output ends. Once the input ends, the write
+	// side of the connection is closed so that the exec sees EOF.
+	errch := make(chan error, 1)
 	go func() {
-		_, err := stdCopy(opts.OutStream, opts.ErrStream, conn)
+		var err error
+		if args.Mode == ExecModeTTY {
+			// a tty has a single raw output stream
+			_, err = io.Copy(opts.OutStream, buf)
+		} else {
+			_, err = stdCopy(opts.OutStream, opts.ErrStream, buf)
+		}
 		errch <- err
 	}()
 	go func() {
-		_, err := io.Copy(conn, opts.InStream)
-		errch <- err
+		if opts.InStream != nil {
+			io.Copy(conn, opts.InStream)
+		}
+		if cw, ok := conn.(interface {
+			CloseWrite() error
+		}); ok {
+			cw.CloseWrite()
+		}
 	}()
 
-	err = <-errch
+	select {
+	case err = <-errch:
+	case <-opts.ExitCh:
+		// leave the exec running in the container, the outputs are no
+		// longer written once returned
+		conn.Close()
+		<-errch
+		return nil
+	case <-ctx.Done():
+		conn.Close()
+		<-errch
+		return ctx.Err()
+	}
 	if err == io.ErrClosedPipe {
 		err = nil
 	}
 	if err != nil {
 		err = fmt.Errorf("copy data: %v", err)
 	}
-	return nil
+	return
+}
+
+// GET /v3/containers/<ip>/exec/<execId>
+func (p *qcosClientImp) InspectContainerExec(ctx context.Context,
+	ip string, execID string) (ret ExecInspectInfo, err error) {
+
+	url := fmt.Sprintf("%s/v3/containers/%s/exec/%s", p.host, ip, execID)
+	err = p.client.Call(ctx, &ret, "GET", url)
+	return
+}
+
+func (p *qcosClientImp) RunCommand(ctx context.Context,
+	ip string, cmd []string, opts RunCommandOpts) (stdout, stderr []byte, exitCode int, err error) {
+
+	return runCommand(ctx, p, ip, cmd, opts)
 }
 
 func isUpgradeTCP(headers http.Header) bool {
 	return strings.Contains(strings.ToLower(headers.Get("Connection")), "upgrade") && headers.Get("Upgrade") != ""
 }
 
+// webdavURL returns the URL of a file in the container, with the path
+// escaped.
+func (p *qcosClientImp) webdavURL(ip string, filePath string) string {
+	u := &url.URL{Path: path.Join("/v3/containers", ip, "webdav/files", filePath)}
+	return p.host + u.EscapedPath()
+}
+
 // PUT /v3/containers/<ip>/webdav/files/<filePath>
 func (p *qcosClientImp) UploadToContainer(
 	ctx context.Context, ip string, filePath string, rd io.Reader) (
 	err error) {
 
-	url := fmt.Sprintf(
-		path.Join("%s/v3/containers/%s/webdav/files/", filePath), p.host, ip)
+	return p.UploadToContainerWithArgs(ctx, ip, filePath, rd, UploadToContainerArgs{})
+}
+
+// PUT /v3/containers/<ip>/webdav/files/<filePath>
+// If-Match: <ifMatch>
+// If-None-Match: <ifNoneMatch>
+func (p *qcosClientImp) UploadToContainerWithArgs(
+	ctx context.Context, ip string, filePath string, rd io.Reader, args UploadToContainerArgs) (
+	err error) {
 
-	p.logger.WithField("url", url).Debug("webdav request")
+	url := p.webdavURL(ip, filePath)
 
 	req, err := http.NewRequest("PUT", url, rd)
 	if err != nil {
 		return
 	}
-	resp, err := p.client.Do(ctx, req)
+	if args.IfMatch != "" {
+		req.Header.Set("If-Match", args.IfMatch)
+	}
+	if args.IfNoneMatch != "" {
+		req.Header.Set("If-None-Match", args.IfNoneMatch)
+	}
+	return p.doWebdav(ctx, req)
+}
+
+// GET /v3/containers/<ip>/webdav/files/<filePath>
+func (p *qcosClientImp) DownloadFromContainer(
+	ctx context.Context, ip string, filePath string) (
+	rc io.ReadCloser, err error) {
+
+	url := p.webdavURL(ip, filePath)
+
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return
 	}
-	defer resp.Body.Close()
-	text, err := ioutil.ReadAll(resp.Body)
+	p.logger.WithField("url", url).WithField("method", req.Method).Debug("webdav request")
+
+	resp, err := p.client.Do(ctx, req)
 	if err != nil {
 		return
 	}
-	p.logger.WithField("body", string(text)).WithField("code",
+	rc = resp.Body
+	p.logger.WithField("code",
 		resp.Status).WithField("header", resp.Header).Debug("webdav result")
 
 	switch resp.StatusCode {
-	case http.StatusCreated:
+	case http.StatusOK:
 		// do nothing
 	case http.StatusNotFound:
 		err = ErrNoSuchEntry
+		rc.Close()
 	default:
 		err = ErrResultError
+		rc.Close()
 	}
 
 	return
 }
 
 // GET /v3/containers/<ip>/webdav/files/<filePath>
-func (p *qcosClientImp) DownloadFromContainer(
-	ctx context.Context, ip string, filePath string) (
-	rc io.ReadCloser, err error) {
+// Range: bytes=<offset>-[<last>]
+// If-Range: <ifRange>
+func (p *qcosClientImp) DownloadRangeFromContainer(
+	ctx context.Context, ip string, filePath string, args DownloadRangeArgs) (
+	ret DownloadRangeResult, err error) {
 
-	url := fmt.Sprintf(
-		path.Join("%s/v3/containers/%s/webdav/files/", filePath), p.host, ip)
+	url := p.webdavURL(ip, filePath)
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return
 	}
+	if args.Offset > 0 || args.Length > 0 {
+		rng := fmt.Sprintf("bytes=%d-", args.Offset)
+		if args.Length > 0 {
+			rng += strconv.FormatInt(args.Offset+args.Length-1, 10)
+		}
+		req.Header.Set("Range", rng)
+		if args.IfRange != "" {
+			req.Header.Set("If-Range", args.IfRange)
+		}
+	}
 	p.logger.WithField("url", url).WithField("method", req.Method).Debug("webdav request")
 
 	resp, err := p.client.Do(ctx, req)
 	if err != nil {
 		return
 	}
-	rc = resp.Body
 	p.logger.WithField("code",
 		resp.Status).WithField("header", resp.Header).Debug("webdav result")
 
+	ret = DownloadRangeResult{Body: resp.Body, Size: resp.ContentLength, ETag: resp.Header.Get("ETag")}
 	switch resp.StatusCode {
 	case http.StatusOK:
-		// do nothing
+		// the whole file
+	case http.StatusPartialContent:
+		ret.Offset, ret.Size, err = parseContentRange(resp.Header.Get("Content-Range"))
 	case http.StatusNotFound:
 		err = ErrNoSuchEntry
-		rc.Close()
+	case http.StatusRequestedRangeNotSatisfiable:
+		err = ErrInvalidRange
 	default:
 		err = ErrResultError
-		rc.Close()
+	}
+	if err != nil {
+		resp.Body.Close()
+		ret = DownloadRangeResult{}
 	}
 
 	return
 }
 
+// parseContentRange parses "bytes <first>-<last>/<size>", size being "*"
+// if unknown.
+func parseContentRange(s string) (offset, size int64, err error) {
+	var last int64
+	var total string
+	if _, err = fmt.Sscanf(s, "bytes %d-%d/%s", &offset, &last, &total); err != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	if total == "*" {
+		return offset, -1, nil
+	}
+	if size, err = strconv.ParseInt(total, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	return
+}
+
 // PROPFIND /v3/containers/<ip>/webdav/files/<filePath>
 func (p *qcosClientImp) StatContainerFile(ctx context.Context, ip string,
 	filePath string, args StatContainerFileArgs) (rc io.ReadCloser, err error) {
 
-	url := fmt.Sprintf(
-		path.Join("%s/v3/containers/%s/webdav/files/", filePath), p.host, ip)
+	url := p.webdavURL(ip, filePath)
 
 	req, err := http.NewRequest("PROPFIND", url, nil)
 	if err != nil {
@@ -813,36 +925,101 @@ func (p *qcosClientImp) StatContainerFile(ctx context.Context, ip string,
 func (p *qcosClientImp) MkdirInContainer(ctx context.Context,
 	ip string, filePath string) (err error) {
 
-	url := fmt.Sprintf(
-		path.Join("%s/v3/containers/%s/webdav/files/", filePath), p.host, ip)
+	url := p.webdavURL(ip, filePath)
 
 	req, err := http.NewRequest("MKCOL", url, nil)
 	if err != nil {
 		return
 	}
-	p.logger.WithField("url", url).WithField("method", req.Method).Debug("webdav request")
+	return p.doWebdav(ctx, req)
+}
+
+// DELETE /v3/containers/<ip>/webdav/files/<filePath>
+func (p *qcosClientImp) DeleteInContainer(ctx context.Context,
+	ip string, filePath string) (err error) {
+
+	url := p.webdavURL(ip, filePath)
+
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return
+	}
+	return p.doWebdav(ctx, req)
+}
+
+// MOVE /v3/containers/<ip>/webdav/files/<filePath>
+// Destination: <host>/v3/containers/<ip>/webdav/files/<destPath>
+// Overwrite: T|F
+func (p *qcosClientImp) MoveInContainer(ctx context.Context,
+	ip string, filePath, destPath string, args MoveInContainerArgs) (err error) {
+
+	return p.copyInContainer(ctx, "MOVE", ip, filePath, destPath, args.Overwrite)
+}
+
+// COPY /v3/containers/<ip>/webdav/files/<filePath>
+// Destination: <host>/v3/containers/<ip>/webdav/files/<destPath>
+// Overwrite: T|F
+func (p *qcosClientImp) CopyInContainer(ctx context.Context,
+	ip string, filePath, destPath string, args CopyInContainerArgs) (err error) {
+
+	return p.copyInContainer(ctx, "COPY", ip, filePath, destPath, args.Overwrite)
+}
+
+func (p *qcosClientImp) copyInContainer(ctx context.Context,
+	method, ip string, filePath, destPath string, overwrite bool) (err error) {
+
+	url := p.webdavURL(ip, filePath)
+	dest := p.webdavURL(ip, destPath)
+
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Destination", dest)
+	if overwrite {
+		req.Header.Set("Overwrite", "T")
+	} else {
+		req.Header.Set("Overwrite", "F")
+	}
+	return p.doWebdav(ctx, req)
+}
+
+// doWebdav sends a webdav request without a result body.
+func (p *qcosClientImp) doWebdav(ctx context.Context, req *http.Request) (err error) {
+	p.logger.WithField("url", req.URL.String()).WithField("method", req.Method).Debug("webdav request")
 
 	resp, err := p.client.Do(ctx, req)
 	if err != nil {
 		return
 	}
-	rc := resp.Body
-	p.logger.WithField("code",
+	defer resp.Body.Close()
+	text, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	p.logger.WithField("body", string(text)).WithField("code",
 		resp.Status).WithField("header", resp.Header).Debug("webdav result")
 
 	switch resp.StatusCode {
-	case http.StatusOK, http.StatusCreated:
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
 		// do nothing
 	case http.StatusNotFound:
 		err = ErrNoSuchEntry
-		rc.Close()
+	case http.StatusConflict:
+		// the parent of the path is missing for MKCOL and PUT, the
+		// destination of MOVE and COPY conflicts
+		if req.Method == "MKCOL" || req.Method == "PUT" {
+			err = ErrNoSuchEntry
+		} else {
+			err = ErrConflict
+		}
+	case http.StatusPreconditionFailed:
+		err = ErrPreconditionFailed
 	default:
 		err = ErrResultError
-		rc.Close()
 	}
 
 	return
-
 }
 
 // GET /v3/logs/containers/<ip>/realtime?since=<since>&tail=<tail>
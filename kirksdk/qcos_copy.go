@@ -0,0 +1,424 @@
+package kirksdk
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+type CopyOpts struct {
+	// Maximal number of files transferred at once. Default 4.
+	Concurrency int
+
+	// Glob patterns, as in path.Match, selecting the files to copy. A
+	// pattern without "/" matches the base name of files and directories,
+	// others their path relative to the copied directory. Files matching
+	// Exclude, or in a directory matching it, are not copied. Without
+	// Include, every other file is copied.
+	Include []string
+	Exclude []string
+
+	Logger *logrus.Logger
+}
+
+type CopyResult struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+func (p *CopyOpts) validate() error {
+	for _, pattern := range append(append([]string(nil), p.Include...), p.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	if p.Concurrency <= 0 {
+		p.Concurrency = 4
+	}
+	return nil
+}
+
+func matchGlobs(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		name := rel
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(rel)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// excluded tells if a file or directory, or one of the directories it is
+// in, is excluded.
+func (p *CopyOpts) excluded(rel string) bool {
+	for dir := rel; dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if matchGlobs(p.Exclude, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// selected tells if a file is copied.
+func (p *CopyOpts) selected(rel string) bool {
+	return !p.excluded(rel) && (len(p.Include) == 0 || matchGlobs(p.Include, rel))
+}
+
+// CopyToContainer copies the files of a local directory to a directory in
+// the container, keeping their relative paths. Directories are created as
+// needed by the files copied. Symbolic links and other special files are
+// skipped.
+func CopyToContainer(ctx context.Context, client QcosClient,
+	ip, localDir, remoteDir string, opts CopyOpts) (ret CopyResult, err error) {
+
+	if err = opts.validate(); err != nil {
+		return
+	}
+	log := loggerOf(client, opts.Logger).WithField("container", ip)
+
+	files, err := listLocalFiles(localDir, &opts, log)
+	if err != nil {
+		return
+	}
+	rels := make([]string, len(files))
+	for i, f := range files {
+		rels[i] = f.rel
+	}
+
+	dirs := newContainerDirs(client, ip)
+	for _, rel := range rels {
+		if err = dirs.mkdirAll(ctx, path.Dir(path.Join(remoteDir, rel))); err != nil {
+			return
+		}
+	}
+	ret, _, err = copyFiles(ctx, rels, opts, func(ctx context.Context, rel string) (n int64, err error) {
+		f, err := os.Open(filepath.Join(localDir, filepath.FromSlash(rel)))
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		log.Debugf("upload %s", rel)
+		r := &countingReader{r: f}
+		err = client.UploadToContainer(ctx, ip, path.Join(remoteDir, rel), r)
+		return r.n, err
+	})
+	return
+}
+
+// CopyFromContainer copies the files of a directory in the container to a
+// local directory, keeping their relative paths and modification times.
+// Directories are created as needed by the files copied.
+func CopyFromContainer(ctx context.Context, client QcosClient,
+	ip, remoteDir, localDir string, opts CopyOpts) (ret CopyResult, err error) {
+
+	if err = opts.validate(); err != nil {
+		return
+	}
+	log := loggerOf(client, opts.Logger).WithField("container", ip)
+
+	files, err := listContainerFiles(ctx, client, ip, remoteDir, &opts)
+	if err != nil {
+		return
+	}
+	rels := make([]string, len(files))
+	infos := make(map[string]ContainerFileInfo, len(files))
+	for i, f := range files {
+		rels[i] = f.rel
+		infos[f.rel] = f.info
+	}
+	ret, _, err = copyFiles(ctx, rels, opts, func(ctx context.Context, rel string) (n int64, err error) {
+		name := filepath.Join(localDir, filepath.FromSlash(rel))
+		if err = os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return
+		}
+		log.Debugf("download %s", rel)
+		rc, err := client.DownloadFromContainer(ctx, ip, infos[rel].Path)
+		if err != nil {
+			return
+		}
+		defer rc.Close()
+		f, err := os.Create(name)
+		if err != nil {
+			return
+		}
+		n, err = io.Copy(f, rc)
+		if e := f.Close(); err == nil {
+			err = e
+		}
+		if mtime := infos[rel].ModTime; err == nil && !mtime.IsZero() {
+			err = os.Chtimes(name, mtime, mtime)
+		}
+		return
+	})
+	return
+}
+
+// TarFromContainer writes the files of a directory in the container to w
+// as a tar.gz archive, with paths relative to the directory. The files are
+// downloaded one at a time.
+func TarFromContainer(ctx context.Context, client QcosClient,
+	ip, remoteDir string, w io.Writer, opts CopyOpts) (ret CopyResult, err error) {
+
+	if err = opts.validate(); err != nil {
+		return
+	}
+	files, err := listContainerFiles(ctx, client, ip, remoteDir, &opts)
+	if err != nil {
+		return
+	}
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, f := range files {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		hdr := &tar.Header{
+			Name:     f.rel,
+			Mode:     0644,
+			Size:     f.info.Size,
+			ModTime:  f.info.ModTime,
+			Typeflag: tar.TypeReg,
+		}
+		if err = tw.WriteHeader(hdr); err != nil {
+			return
+		}
+		var rc io.ReadCloser
+		if rc, err = client.DownloadFromContainer(ctx, ip, f.info.Path); err != nil {
+			return ret, fmt.Errorf("%s: %v", f.rel, err)
+		}
+		// the archive has the size listed, should the file have changed
+		_, err = io.CopyN(tw, rc, hdr.Size)
+		rc.Close()
+		if err != nil {
+			return ret, fmt.Errorf("%s: %v", f.rel, err)
+		}
+		ret.Files++
+		ret.Bytes += hdr.Size
+	}
+	if err = tw.Close(); err != nil {
+		return
+	}
+	err = gw.Close()
+	return
+}
+
+// UntarToContainer extracts the regular files of a tar.gz archive from r
+// to a directory in the container, one at a time. Directories are created
+// as needed by the files extracted.
+func UntarToContainer(ctx context.Context, client QcosClient,
+	ip string, r io.Reader, remoteDir string, opts CopyOpts) (ret CopyResult, err error) {
+
+	if err = opts.validate(); err != nil {
+		return
+	}
+	log := loggerOf(client, opts.Logger).WithField("container", ip)
+
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return
+	}
+	tr := tar.NewReader(gr)
+	dirs := newContainerDirs(client, ip)
+	for {
+		var hdr *tar.Header
+		if hdr, err = tr.Next(); err == io.EOF {
+			return ret, nil
+		} else if err != nil {
+			return
+		}
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		// entries can not escape the directory
+		rel := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		if rel == "" {
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			if hdr.Typeflag != tar.TypeDir {
+				log.Debugf("skip %s: not a regular file", hdr.Name)
+			}
+			continue
+		}
+		if !opts.selected(rel) {
+			continue
+		}
+		if err = dirs.mkdirAll(ctx, path.Dir(path.Join(remoteDir, rel))); err != nil {
+			return
+		}
+		log.Debugf("upload %s", rel)
+		cr := &countingReader{r: tr}
+		if err = client.UploadToContainer(ctx, ip, path.Join(remoteDir, rel), cr); err != nil {
+			return ret, fmt.Errorf("%s: %v", rel, err)
+		}
+		ret.Files++
+		ret.Bytes += cr.n
+	}
+}
+
+type localFile struct {
+	rel   string
+	size  int64
+	mtime time.Time
+}
+
+// listLocalFiles lists the regular files selected under a local directory,
+// sorted by path.
+func listLocalFiles(localDir string, opts *CopyOpts, log *logrus.Entry) (files []localFile, err error) {
+	err = filepath.Walk(localDir, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(localDir, name)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if fi.IsDir() {
+			if opts.excluded(rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fi.Mode().IsRegular() {
+			log.Debugf("skip %s: not a regular file", name)
+			return nil
+		}
+		if opts.selected(rel) {
+			files = append(files, localFile{rel: rel, size: fi.Size(), mtime: fi.ModTime()})
+		}
+		return nil
+	})
+	return
+}
+
+type containerFile struct {
+	rel  string
+	info ContainerFileInfo
+}
+
+// listContainerFiles lists the files selected under a directory of the
+// container, sorted by path.
+func listContainerFiles(ctx context.Context, client QcosClient,
+	ip, remoteDir string, opts *CopyOpts) (files []containerFile, err error) {
+
+	infos, err := ListContainerDir(ctx, client, ip, remoteDir, StatContainerFileArgs{Depth: -1})
+	if err != nil {
+		return
+	}
+	root := path.Clean("/" + remoteDir)
+	for _, info := range infos {
+		if info.IsDir {
+			continue
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(info.Path, root), "/")
+		if opts.selected(rel) {
+			files = append(files, containerFile{rel: rel, info: info})
+		}
+	}
+	return
+}
+
+// copyFiles runs transfer on the files in parallel, stopping at the first
+// error. It returns the files transferred, sorted, even on error.
+func copyFiles(ctx context.Context, rels []string, opts CopyOpts,
+	transfer func(ctx context.Context, rel string) (int64, error)) (ret CopyResult, done []string, err error) {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, opts.Concurrency)
+	)
+	for _, rel := range rels {
+		sem <- struct{}{}
+		mu.Lock()
+		failed := err != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		wg.Add(1)
+		go func(rel string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			n, e := transfer(ctx, rel)
+			mu.Lock()
+			defer mu.Unlock()
+			if e != nil {
+				if err == nil {
+					err = fmt.Errorf("%s: %v", rel, e)
+					cancel()
+				}
+				return
+			}
+			ret.Files++
+			ret.Bytes += n
+			done = append(done, rel)
+		}(rel)
+	}
+	wg.Wait()
+	sort.Strings(done)
+	return
+}
+
+// containerDirs creates directories in a container, remembering those
+// known to exist.
+type containerDirs struct {
+	client QcosClient
+	ip     string
+	known  map[string]bool
+}
+
+func newContainerDirs(client QcosClient, ip string) *containerDirs {
+	return &containerDirs{client: client, ip: ip, known: map[string]bool{"/": true}}
+}
+
+func (p *containerDirs) mkdirAll(ctx context.Context, dir string) error {
+	dir = path.Clean("/" + dir)
+	if p.known[dir] {
+		return nil
+	}
+	info, err := StatContainerPath(ctx, p.client, p.ip, dir)
+	switch {
+	case err == nil && !info.IsDir:
+		return fmt.Errorf("%s: %v", dir, ErrNotDir)
+	case err == ErrNoSuchEntry:
+		if err = p.mkdirAll(ctx, path.Dir(dir)); err != nil {
+			return err
+		}
+		if err = p.client.MkdirInContainer(ctx, p.ip, dir); err != nil {
+			return fmt.Errorf("mkdir %s: %v", dir, err)
+		}
+	case err != nil:
+		return err
+	}
+	p.known[dir] = true
+	return nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (p *countingReader) Read(b []byte) (n int, err error) {
+	n, err = p.r.Read(b)
+	p.n += int64(n)
+	return
+}
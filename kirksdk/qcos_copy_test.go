@@ -0,0 +1,164 @@
+package kirksdk
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/webdav"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeLocalFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(name), 0755))
+		assert.NoError(t, ioutil.WriteFile(name, []byte(content), 0644))
+	}
+}
+
+func readLocalFiles(t *testing.T, dir string) map[string]string {
+	files := make(map[string]string)
+	filepath.Walk(dir, func(name string, fi os.FileInfo, err error) error {
+		assert.NoError(t, err)
+		if fi.Mode().IsRegular() {
+			b, _ := ioutil.ReadFile(name)
+			rel, _ := filepath.Rel(dir, name)
+			files[filepath.ToSlash(rel)] = string(b)
+		}
+		return nil
+	})
+	return files
+}
+
+func readContainerFiles(t *testing.T, fs webdav.FileSystem, dir string) map[string]string {
+	files := make(map[string]string)
+	var walk func(dir string)
+	walk = func(dir string) {
+		f, err := fs.OpenFile(dir, os.O_RDONLY, 0)
+		if !assert.NoError(t, err) {
+			return
+		}
+		fis, _ := f.Readdir(-1)
+		f.Close()
+		for _, fi := range fis {
+			name := dir + "/" + fi.Name()
+			if fi.IsDir() {
+				walk(name)
+				continue
+			}
+			f, _ := fs.OpenFile(name, os.O_RDONLY, 0)
+			b, _ := ioutil.ReadAll(f)
+			f.Close()
+			files[name] = string(b)
+		}
+	}
+	walk(dir)
+	return files
+}
+
+func TestCopyToContainer(t *testing.T) {
+	ts, fs := newWebdavTestServer(map[string]string{"/srv/": ""})
+	defer ts.Close()
+	client := NewQcosClient(QcosConfig{Host: ts.URL})
+
+	local, err := ioutil.TempDir("", "kirk-copy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(local)
+	writeLocalFiles(t, local, map[string]string{
+		"index.html":              "<html>",
+		"css/site.css":            "body {}",
+		"css/vendor/reset.css":    "* {}",
+		"node_modules/x/index.js": "module.exports = 1",
+		"100% #1?.txt":            "odd name",
+		"css/vendor/.DS_Store":    "",
+	})
+
+	ret, err := CopyToContainer(context.TODO(), client, "10.0.0.1", local, "/srv/www",
+		CopyOpts{Exclude: []string{"node_modules", ".DS_Store"}, Concurrency: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, CopyResult{Files: 4, Bytes: 6 + 7 + 4 + 8}, ret)
+	assert.Equal(t, map[string]string{
+		"/srv/www/index.html":           "<html>",
+		"/srv/www/css/site.css":         "body {}",
+		"/srv/www/css/vendor/reset.css": "* {}",
+		"/srv/www/100% #1?.txt":         "odd name",
+	}, readContainerFiles(t, fs, "/srv"))
+
+	// again, into existing directories
+	ret, err = CopyToContainer(context.TODO(), client, "10.0.0.1", local, "/srv/www",
+		CopyOpts{Include: []string{"css/*.css"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, ret.Files)
+
+	_, err = CopyToContainer(context.TODO(), client, "10.0.0.1", local, "/srv/www/index.html", CopyOpts{})
+	assert.Error(t, err)
+	_, err = CopyToContainer(context.TODO(), client, "10.0.0.1", local, "/srv", CopyOpts{Include: []string{"["}})
+	assert.Error(t, err)
+}
+
+func TestCopyFromContainer(t *testing.T) {
+	ts, _ := newWebdavTestServer(map[string]string{
+		"/var/":                "",
+		"/var/log/":            "",
+		"/var/log/app.log":     "started\n",
+		"/var/log/old/":        "",
+		"/var/log/old/app.log": "stopped\n",
+		"/var/log/core":        "\x7fELF",
+	})
+	defer ts.Close()
+	client := NewQcosClient(QcosConfig{Host: ts.URL})
+
+	local, err := ioutil.TempDir("", "kirk-copy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(local)
+
+	ret, err := CopyFromContainer(context.TODO(), client, "10.0.0.1", "/var/log", local,
+		CopyOpts{Include: []string{"*.log"}})
+	assert.NoError(t, err)
+	assert.Equal(t, CopyResult{Files: 2, Bytes: 16}, ret)
+	assert.Equal(t, map[string]string{
+		"app.log":     "started\n",
+		"old/app.log": "stopped\n",
+	}, readLocalFiles(t, local))
+
+	_, err = CopyFromContainer(context.TODO(), client, "10.0.0.1", "/var/run", local, CopyOpts{})
+	assert.Equal(t, ErrNoSuchEntry, err)
+}
+
+func TestTarContainerFiles(t *testing.T) {
+	ts, fs := newWebdavTestServer(map[string]string{
+		"/app/":              "",
+		"/app/conf/":         "",
+		"/app/conf/app.yaml": "port: 80\n",
+		"/app/bin/":          "",
+		"/app/bin/server":    "binary",
+		"/app/tmp/":          "",
+		"/app/tmp/cache":     "cache",
+	})
+	defer ts.Close()
+	client := NewQcosClient(QcosConfig{Host: ts.URL})
+
+	var archive bytes.Buffer
+	ret, err := TarFromContainer(context.TODO(), client, "10.0.0.1", "/app", &archive,
+		CopyOpts{Exclude: []string{"tmp"}})
+	assert.NoError(t, err)
+	assert.Equal(t, CopyResult{Files: 2, Bytes: 15}, ret)
+
+	ret, err = UntarToContainer(context.TODO(), client, "10.0.0.1", &archive, "/backup/app", CopyOpts{})
+	assert.NoError(t, err)
+	assert.Equal(t, CopyResult{Files: 2, Bytes: 15}, ret)
+	files := readContainerFiles(t, fs, "/backup")
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{"/backup/app/bin/server", "/backup/app/conf/app.yaml"}, names)
+	assert.Equal(t, "port: 80\n", files["/backup/app/conf/app.yaml"])
+}
@@ -0,0 +1,238 @@
+package kirksdk
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// DependsOnKey is the metadata key declaring the services of the same stack
+// a service depends on, separated by commas, e.g. "depends-on=mysql,redis".
+const DependsOnKey = "depends-on"
+
+// ServiceDependencies returns the dependencies declared in the metadata of
+// a service. Several depends-on entries are merged, so the entries are split
+// one by one rather than parsed with ParseMetadata, which rejects duplicate
+// keys.
+func ServiceDependencies(metadata []string) (deps []string) {
+	md := NewMetadata()
+	for _, entry := range metadata {
+		key, value, _ := md.split(entry)
+		if key != DependsOnKey {
+			continue
+		}
+		for _, dep := range strings.Split(value, ",") {
+			if dep = strings.TrimSpace(dep); dep != "" && !containsString(deps, dep) {
+				deps = append(deps, dep)
+			}
+		}
+	}
+	return
+}
+
+// DependencyCycleError is returned when the services of a stack depend on
+// each other.
+type DependencyCycleError struct {
+	Cycle []string
+}
+
+func (p *DependencyCycleError) Error() string {
+	return "dependency cycle: " + strings.Join(p.Cycle, " -> ")
+}
+
+// ServiceGraph is the dependency graph of the services of a stack.
+type ServiceGraph struct {
+	Stack string
+	Deps  map[string][]string // service -> services it depends on
+}
+
+// StackServiceGraph builds the dependency graph of a stack from the metadata
+// of its services. It fails on unknown dependencies and on cycles.
+func StackServiceGraph(ctx context.Context, client QcosClient, stackName string) (ret ServiceGraph, err error) {
+	if stackName == "" {
+		stackName = DefaultStack
+	}
+	services, err := client.ListServices(ctx, stackName)
+	if err != nil {
+		return
+	}
+	ret = ServiceGraph{Stack: stackName, Deps: make(map[string][]string)}
+	for _, svc := range services {
+		ret.Deps[svc.Name] = ServiceDependencies(svc.Metadata)
+	}
+	for _, name := range ret.Services() {
+		for _, dep := range ret.Deps[name] {
+			if _, ok := ret.Deps[dep]; !ok {
+				return ret, fmt.Errorf("%s/%s depends on unknown service %s", stackName, name, dep)
+			}
+			if dep == name {
+				return ret, fmt.Errorf("%s/%s depends on itself", stackName, name)
+			}
+		}
+	}
+	if cycle := dependencyCycle(ret.Services(), ret.Deps); cycle != nil {
+		return ret, &DependencyCycleError{Cycle: cycle}
+	}
+	return
+}
+
+// Services returns the services of the graph, sorted by name.
+func (p ServiceGraph) Services() []string {
+	names := make([]string, 0, len(p.Deps))
+	for name := range p.Deps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Dependents returns the services depending on the service.
+func (p ServiceGraph) Dependents(service string) (ret []string) {
+	for _, name := range p.Services() {
+		if containsString(p.Deps[name], service) {
+			ret = append(ret, name)
+		}
+	}
+	return
+}
+
+// Levels returns the services in topological order, grouped in levels
+// depending only on earlier levels.
+func (p ServiceGraph) Levels() (levels [][]string) {
+	level := make(map[string]int)
+	var depth func(name string) int
+	depth = func(name string) int {
+		if n, ok := level[name]; ok {
+			return n
+		}
+		n := 0
+		for _, dep := range p.Deps[name] {
+			if d := depth(dep) + 1; d > n {
+				n = d
+			}
+		}
+		level[name] = n
+		return n
+	}
+	for _, name := range p.Services() {
+		n := depth(name)
+		for len(levels) <= n {
+			levels = append(levels, nil)
+		}
+		levels[n] = append(levels[n], name)
+	}
+	return
+}
+
+type OrderedStackOpts struct {
+	// Maximal number of services started or stopped at once. Default 4.
+	Concurrency int
+
+	Logger *logrus.Logger
+}
+
+type OrderedStackResult struct {
+	Done    []string `json:"done"`
+	Failed  []string `json:"failed"`
+	Skipped []string `json:"skipped"` // because a service they wait for failed
+}
+
+// OrderedStartStack starts the services of a stack with SyncStartService,
+// each once the services it depends on are started. Independent services
+// are started in parallel.
+func OrderedStartStack(ctx context.Context, client QcosClient,
+	stackName string, opts OrderedStackOpts) (ret OrderedStackResult, err error) {
+
+	graph, err := StackServiceGraph(ctx, client, stackName)
+	if err != nil {
+		return
+	}
+	return graph.walk(ctx, client, "start", func(name string) []string {
+		return graph.Deps[name]
+	}, client.SyncStartService, opts)
+}
+
+// OrderedStopStack stops the services of a stack with SyncStopService,
+// each once the services depending on it are stopped. Independent services
+// are stopped in parallel.
+func OrderedStopStack(ctx context.Context, client QcosClient,
+	stackName string, opts OrderedStackOpts) (ret OrderedStackResult, err error) {
+
+	graph, err := StackServiceGraph(ctx, client, stackName)
+	if err != nil {
+		return
+	}
+	return graph.walk(ctx, client, "stop", graph.Dependents, client.SyncStopService, opts)
+}
+
+// walk runs do on every service after the services returned by waitFor.
+func (p ServiceGraph) walk(ctx context.Context, client QcosClient, verb string,
+	waitFor func(name string) []string,
+	do func(ctx context.Context, stackName, serviceName string) error,
+	opts OrderedStackOpts) (ret OrderedStackResult, err error) {
+
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+	log := loggerOf(client, opts.Logger)
+
+	services := p.Services()
+	done := make(map[string]chan struct{}, len(services))
+	for _, name := range services {
+		done[name] = make(chan struct{})
+	}
+	var (
+		mu  sync.Mutex
+		ok  = make(map[string]bool)
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, opts.Concurrency)
+	)
+	for _, name := range services {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			defer close(done[name])
+			for _, dep := range waitFor(name) {
+				<-done[dep]
+				mu.Lock()
+				depOK := ok[dep]
+				mu.Unlock()
+				if !depOK {
+					mu.Lock()
+					ret.Skipped = append(ret.Skipped, name)
+					mu.Unlock()
+					return
+				}
+			}
+			sem <- struct{}{}
+			entry := log.WithField("service", p.Stack+"/"+name)
+			entry.Infof("%s service", verb)
+			e := ctx.Err()
+			if e == nil {
+				e = do(ctx, p.Stack, name)
+			}
+			<-sem
+
+			mu.Lock()
+			defer mu.Unlock()
+			if e != nil {
+				entry.Errorf("%s service failed: %v", verb, e)
+				ret.Failed = append(ret.Failed, name)
+				if err == nil {
+					err = fmt.Errorf("%s %s/%s: %v", verb, p.Stack, name, e)
+				}
+				return
+			}
+			ok[name] = true
+			ret.Done = append(ret.Done, name)
+		}(name)
+	}
+	wg.Wait()
+	sort.Strings(ret.Failed)
+	sort.Strings(ret.Skipped)
+	return
+}
@@ -0,0 +1,95 @@
+package kirksdk
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceDependencies(t *testing.T) {
+	assert.Equal(t, []string{"mysql", "redis", "mq"},
+		ServiceDependencies([]string{"team=web", "depends-on=mysql, redis", "depends-on=mq,mysql"}))
+	assert.Empty(t, ServiceDependencies([]string{"depends-on="}))
+}
+
+func newDependsTestClient(services map[string]string) *mockQcosClient {
+	client := newMockQcosClient()
+	args := CreateStackArgs{Name: "app"}
+	for name, deps := range services {
+		svc := CreateServiceArgs{Name: name, InstanceNum: 1}
+		if deps != "" {
+			svc.Metadata = []string{"depends-on=" + deps}
+		}
+		args.Services = append(args.Services, svc)
+	}
+	client.CreateStack(context.TODO(), args)
+	client.calls = nil
+	return client
+}
+
+func callIndex(calls []string, call string) int {
+	for i, c := range calls {
+		if c == call {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestStackServiceGraph(t *testing.T) {
+	client := newDependsTestClient(map[string]string{
+		"mysql": "", "redis": "", "api": "mysql,redis", "web": "api", "worker": "mysql",
+	})
+	graph, err := StackServiceGraph(context.TODO(), client, "app")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"mysql", "redis"}, {"api", "worker"}, {"web"}}, graph.Levels())
+	assert.Equal(t, []string{"api", "worker"}, graph.Dependents("mysql"))
+
+	client = newDependsTestClient(map[string]string{"a": "b", "b": "c", "c": "a"})
+	_, err = StackServiceGraph(context.TODO(), client, "app")
+	assert.EqualError(t, err, "dependency cycle: a -> b -> c -> a")
+
+	client = newDependsTestClient(map[string]string{"a": "x"})
+	_, err = StackServiceGraph(context.TODO(), client, "app")
+	assert.EqualError(t, err, "app/a depends on unknown service x")
+}
+
+func TestOrderedStartStopStack(t *testing.T) {
+	client := newDependsTestClient(map[string]string{
+		"mysql": "", "redis": "", "api": "mysql,redis", "web": "api", "worker": "mysql",
+	})
+	ret, err := OrderedStartStack(context.TODO(), client, "app", OrderedStackOpts{})
+	assert.NoError(t, err)
+	assert.Len(t, ret.Done, 5)
+	start := func(svc string) int { return callIndex(client.calls, "SyncStartService app/"+svc) }
+	assert.True(t, start("mysql") < start("api"))
+	assert.True(t, start("redis") < start("api"))
+	assert.True(t, start("mysql") < start("worker"))
+	assert.True(t, start("api") < start("web"))
+
+	client.calls = nil
+	ret, err = OrderedStopStack(context.TODO(), client, "app", OrderedStackOpts{Concurrency: 1})
+	assert.NoError(t, err)
+	stop := func(svc string) int { return callIndex(client.calls, "SyncStopService app/"+svc) }
+	assert.True(t, stop("web") < stop("api"))
+	assert.True(t, stop("api") < stop("mysql"))
+	assert.True(t, stop("worker") < stop("mysql"))
+	assert.True(t, stop("api") < stop("redis"))
+	assert.Equal(t, StatusNotRunning, client.serviceInfos["app/mysql"].Status)
+}
+
+func TestOrderedStartStackFailed(t *testing.T) {
+	client := newDependsTestClient(map[string]string{
+		"mysql": "", "redis": "", "api": "mysql,redis", "web": "api", "cache": "redis",
+	})
+	client.errs["SyncStartService app/mysql"] = errors.New("disk full")
+	ret, err := OrderedStartStack(context.TODO(), client, "app", OrderedStackOpts{})
+	assert.EqualError(t, err, "start app/mysql: disk full")
+	assert.Equal(t, []string{"mysql"}, ret.Failed)
+	assert.Equal(t, []string{"api", "web"}, ret.Skipped)
+	assert.Len(t, ret.Done, 2)
+	assert.Equal(t, -1, callIndex(client.calls, "SyncStartService app/api"))
+}
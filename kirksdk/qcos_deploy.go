@@ -0,0 +1,138 @@
+package kirksdk
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Deploy operations of a manual update.
+const (
+	DeployOpComplete = "COMPLETE"
+	DeployOpRollback = "ROLLBACK"
+	DeployOpContinue = "CONTINUE"
+	DeployOpPause    = "PAUSE"
+)
+
+// DeployComplete updates all remaining instances and ends the manual update.
+func DeployComplete() DeployServiceArgs {
+	return DeployServiceArgs{Operation: DeployOpComplete}
+}
+
+// DeployRollback reverts all instances to the previous spec.
+func DeployRollback() DeployServiceArgs {
+	return DeployServiceArgs{Operation: DeployOpRollback}
+}
+
+// DeployContinue updates n more instances.
+func DeployContinue(n int) DeployServiceArgs {
+	return DeployServiceArgs{Operation: fmt.Sprintf("%s %d", DeployOpContinue, n)}
+}
+
+// DeployPause stops updating further instances.
+func DeployPause() DeployServiceArgs {
+	return DeployServiceArgs{Operation: DeployOpPause}
+}
+
+// Op returns the operation without its arguments, e.g. DeployOpContinue.
+func (p DeployServiceArgs) Op() string {
+	fields := strings.Fields(p.Operation)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// ContainerRevision is the revision a container runs during an update.
+type ContainerRevision struct {
+	IP       string `json:"ip"`
+	Revision int    `json:"revision"`
+	Status   Status `json:"status"`
+	Updated  bool   `json:"updated"`
+}
+
+// ServiceUpdateSnapshot is sent by WatchServiceUpdate. Updated counts the
+// containers running the revision of the service. Err is set on the last
+// snapshot if the watch failed.
+type ServiceUpdateSnapshot struct {
+	Service    ServiceInfo         `json:"service"`
+	Containers []ContainerRevision `json:"containers"`
+	Updated    int                 `json:"updated"`
+	At         time.Time           `json:"at"`
+	Err        error               `json:"-"`
+}
+
+// Updating returns true if the service is in a manual or auto update.
+func (p ServiceUpdateSnapshot) Updating() bool {
+	return isServiceUpdating(p.Service.State)
+}
+
+func isServiceUpdating(state State) bool {
+	return state == StateManualUpdating || state == StateAutoUpdating
+}
+
+// WatchServiceUpdate sends a snapshot of the service every interval while it
+// is updating. The channel is closed after the first snapshot in another
+// state, after an error, or when ctx is done.
+func WatchServiceUpdate(ctx context.Context, client QcosClient,
+	stackName, serviceName string, interval time.Duration) <-chan ServiceUpdateSnapshot {
+
+	if stackName == "" {
+		stackName = DefaultStack
+	}
+	if interval == 0 {
+		interval = 2 * time.Second
+	}
+	ch := make(chan ServiceUpdateSnapshot)
+	go func() {
+		defer close(ch)
+		for {
+			snap := ServiceUpdateSnapshotOf(ctx, client, stackName, serviceName)
+			select {
+			case ch <- snap:
+			case <-ctx.Done():
+				return
+			}
+			if snap.Err != nil || !snap.Updating() {
+				return
+			}
+			if sleepContext(ctx, interval) != nil {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// ServiceUpdateSnapshotOf inspects the service and its containers once.
+func ServiceUpdateSnapshotOf(ctx context.Context, client QcosClient, stackName, serviceName string) (ret ServiceUpdateSnapshot) {
+	ret.At = time.Now()
+	ret.Service, ret.Err = client.GetServiceInspect(ctx, stackName, serviceName)
+	if ret.Err != nil {
+		return
+	}
+	for _, ip := range ret.Service.ContainerIPs {
+		info, err := client.GetContainerInspect(ctx, ip)
+		if err != nil {
+			if isNotFound(err) {
+				// the container is replaced by the update
+				continue
+			}
+			ret.Err = err
+			return
+		}
+		c := ContainerRevision{
+			IP:       ip,
+			Revision: info.Revision,
+			Status:   info.Status,
+			Updated:  info.Revision == ret.Service.Revision,
+		}
+		if c.Updated {
+			ret.Updated++
+		}
+		ret.Containers = append(ret.Containers, c)
+	}
+	return
+}
@@ -0,0 +1,53 @@
+package kirksdk
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeployOps(t *testing.T) {
+	assert.Equal(t, DeployServiceArgs{"COMPLETE"}, DeployComplete())
+	assert.Equal(t, DeployServiceArgs{"ROLLBACK"}, DeployRollback())
+	assert.Equal(t, DeployServiceArgs{"PAUSE"}, DeployPause())
+	assert.Equal(t, DeployServiceArgs{"CONTINUE 3"}, DeployContinue(3))
+	assert.Equal(t, DeployOpContinue, DeployContinue(3).Op())
+	assert.Equal(t, "", DeployServiceArgs{}.Op())
+}
+
+func TestWatchServiceUpdate(t *testing.T) {
+	client := newRolloutTestClient()
+	info := client.serviceInfos["web/nginx"]
+	info.Revision = 2
+	info.State = StateManualUpdating
+	client.serviceInfos["web/nginx"] = info
+	client.containers["10.0.0.1"] = ContainerInfo{IP: "10.0.0.1", Revision: 2, Status: StatusRunning}
+	client.containers["10.0.0.2"] = ContainerInfo{IP: "10.0.0.2", Revision: 1, Status: StatusRunning}
+
+	var snaps []ServiceUpdateSnapshot
+	for snap := range WatchServiceUpdate(context.TODO(), client, "web", "nginx", time.Millisecond) {
+		snaps = append(snaps, snap)
+		if len(snaps) == 2 {
+			client.DeployService(context.TODO(), "web", "nginx", DeployComplete())
+		}
+	}
+	assert.True(t, len(snaps) >= 3)
+	assert.NoError(t, snaps[0].Err)
+	assert.True(t, snaps[0].Updating())
+	assert.Equal(t, 1, snaps[0].Updated)
+	assert.Equal(t, []ContainerRevision{
+		{IP: "10.0.0.1", Revision: 2, Status: StatusRunning, Updated: true},
+		{IP: "10.0.0.2", Revision: 1, Status: StatusRunning, Updated: false},
+	}, snaps[0].Containers)
+	assert.False(t, snaps[len(snaps)-1].Updating())
+
+	snaps = nil
+	for snap := range WatchServiceUpdate(context.TODO(), client, "web", "missing", time.Millisecond) {
+		snaps = append(snaps, snap)
+	}
+	assert.Len(t, snaps, 1)
+	assert.Equal(t, errMockNotFound, snaps[0].Err)
+}
@@ -0,0 +1,173 @@
+package kirksdk
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+type DownloadOpts struct {
+	// Number of times an interrupted download is resumed. Default 0.
+	Retries int
+
+	// Called as the file is written, with the bytes written so far,
+	// including those of a resumed partial file, and the size of the file,
+	// -1 if unknown.
+	Progress func(done, total int64)
+
+	Logger *logrus.Logger
+}
+
+// DownloadFileFromContainer downloads a file of the container to a local
+// file. The file is first written to localFile+".part", along with the
+// ETag of the remote file in localFile+".part.etag". When the download is
+// interrupted, the partial file is kept, and a later call resumes it with a
+// range request, provided the remote file has kept the same ETag.
+func DownloadFileFromContainer(ctx context.Context, client QcosClient,
+	ip, filePath, localFile string, opts DownloadOpts) (err error) {
+
+	log := loggerOf(client, opts.Logger).WithField("container", ip)
+	for retry := 0; ; retry++ {
+		err = downloadPart(ctx, client, ip, filePath, localFile, opts.Progress, log)
+		if err == nil || ctx.Err() != nil || retry >= opts.Retries || !isResumable(err) {
+			return
+		}
+		log.Warnf("download %s interrupted, resuming: %v", filePath, err)
+	}
+}
+
+// downloadError is an error reading the body of a download, after which
+// the download may be resumed.
+type downloadError struct {
+	err error
+}
+
+func (p *downloadError) Error() string {
+	return p.err.Error()
+}
+
+func isResumable(err error) bool {
+	_, ok := err.(*downloadError)
+	return ok
+}
+
+func downloadPart(ctx context.Context, client QcosClient,
+	ip, filePath, localFile string, progress func(done, total int64), log *logrus.Entry) (err error) {
+
+	part, etagFile := localFile+".part", localFile+".part.etag"
+	var offset int64
+	var etag string
+	if b, e := ioutil.ReadFile(etagFile); e == nil {
+		if fi, e := os.Stat(part); e == nil {
+			offset, etag = fi.Size(), strings.TrimSpace(string(b))
+		}
+	}
+
+	ret, err := client.DownloadRangeFromContainer(ctx, ip, filePath, DownloadRangeArgs{Offset: offset, IfRange: etag})
+	if err == ErrInvalidRange && offset > 0 {
+		// the partial file may be complete
+		var info ContainerFileInfo
+		if info, err = StatContainerPath(ctx, client, ip, filePath); err != nil {
+			return
+		}
+		if info.ETag == etag && info.Size == offset {
+			return finishDownload(part, etagFile, localFile)
+		}
+		ret, err = client.DownloadRangeFromContainer(ctx, ip, filePath, DownloadRangeArgs{})
+	}
+	if err == nil && offset > 0 && ret.Offset == offset && ret.ETag != etag {
+		// the range is of another version of the file, If-Range being
+		// ignored
+		ret.Body.Close()
+		ret, err = client.DownloadRangeFromContainer(ctx, ip, filePath, DownloadRangeArgs{})
+	}
+	if err != nil {
+		return
+	}
+	defer ret.Body.Close()
+
+	if offset > 0 && ret.Offset == offset {
+		log.Debugf("resume %s at %d", filePath, offset)
+	} else {
+		if ret.Offset != 0 {
+			return fmt.Errorf("%s: got range at %d, expected %d", filePath, ret.Offset, offset)
+		}
+		if offset > 0 {
+			log.Debugf("%s changed, downloading it again", filePath)
+		}
+		// record the ETag before any data, or nothing if the file can not
+		// be resumed
+		offset = 0
+		os.Remove(etagFile)
+		if ret.ETag != "" {
+			if err = ioutil.WriteFile(etagFile, []byte(ret.ETag), 0644); err != nil {
+				return
+			}
+		}
+	}
+
+	f, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	if err = f.Truncate(offset); err == nil {
+		_, err = f.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return
+	}
+	w := &progressWriter{w: f, done: offset, total: ret.Size, progress: progress}
+	if progress != nil {
+		progress(offset, ret.Size)
+	}
+	_, err = io.Copy(w, ret.Body)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		if w.werr == nil {
+			err = &downloadError{err: err}
+		}
+		return
+	}
+	if ret.Size >= 0 && w.done != ret.Size {
+		return &downloadError{err: fmt.Errorf("%s: got %d bytes of %d", filePath, w.done, ret.Size)}
+	}
+	return finishDownload(part, etagFile, localFile)
+}
+
+func finishDownload(part, etagFile, localFile string) error {
+	if err := os.Rename(part, localFile); err != nil {
+		return err
+	}
+	os.Remove(etagFile)
+	return nil
+}
+
+// progressWriter reports the bytes written to w. Errors of w are kept in
+// werr.
+type progressWriter struct {
+	w        io.Writer
+	done     int64
+	total    int64
+	progress func(done, total int64)
+	werr     error
+}
+
+func (p *progressWriter) Write(b []byte) (n int, err error) {
+	n, err = p.w.Write(b)
+	p.done += int64(n)
+	if err != nil {
+		p.werr = err
+	}
+	if p.progress != nil && n > 0 {
+		p.progress(p.done, p.total)
+	}
+	return
+}
@@ -0,0 +1,184 @@
+package kirksdk
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadRangeFromContainer(t *testing.T) {
+	ts, _ := newWebdavTestServer(map[string]string{"/core": "0123456789"})
+	defer ts.Close()
+	client := NewQcosClient(QcosConfig{Host: ts.URL})
+	read := func(ret DownloadRangeResult) string {
+		defer ret.Body.Close()
+		b, _ := ioutil.ReadAll(ret.Body)
+		return string(b)
+	}
+
+	ret, err := client.DownloadRangeFromContainer(context.TODO(), "10.0.0.1", "/core", DownloadRangeArgs{Offset: 5, Length: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), ret.Offset)
+	assert.Equal(t, int64(10), ret.Size)
+	assert.NotEmpty(t, ret.ETag)
+	assert.Equal(t, "567", read(ret))
+	etag := ret.ETag
+
+	ret, err = client.DownloadRangeFromContainer(context.TODO(), "10.0.0.1", "/core", DownloadRangeArgs{Offset: 8, IfRange: etag})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8), ret.Offset)
+	assert.Equal(t, "89", read(ret))
+
+	// the file changed, it is returned whole
+	ret, err = client.DownloadRangeFromContainer(context.TODO(), "10.0.0.1", "/core", DownloadRangeArgs{Offset: 8, IfRange: `"other"`})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), ret.Offset)
+	assert.Equal(t, int64(10), ret.Size)
+	assert.Equal(t, "0123456789", read(ret))
+
+	_, err = client.DownloadRangeFromContainer(context.TODO(), "10.0.0.1", "/core", DownloadRangeArgs{Offset: 10})
+	assert.Equal(t, ErrInvalidRange, err)
+	_, err = client.DownloadRangeFromContainer(context.TODO(), "10.0.0.1", "/none", DownloadRangeArgs{Offset: 1})
+	assert.Equal(t, ErrNoSuchEntry, err)
+}
+
+// interruptingHandler cuts the body of the first GET after n bytes.
+type interruptingHandler struct {
+	h http.Handler
+	n int
+
+	mu     sync.Mutex
+	ranges []string
+}
+
+func (p *interruptingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		p.h.ServeHTTP(w, r)
+		return
+	}
+	p.mu.Lock()
+	p.ranges = append(p.ranges, r.Header.Get("Range"))
+	first := len(p.ranges) == 1
+	p.mu.Unlock()
+	if !first {
+		p.h.ServeHTTP(w, r)
+		return
+	}
+	rec := httptest.NewRecorder()
+	p.h.ServeHTTP(rec, r)
+	for k, v := range rec.HeaderMap {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(rec.Code)
+	w.Write(rec.Body.Bytes()[:p.n])
+	w.(http.Flusher).Flush()
+	conn, _, _ := w.(http.Hijacker).Hijack()
+	conn.Close()
+}
+
+func TestDownloadFileFromContainer(t *testing.T) {
+	content := strings.Repeat("heap profile\n", 1000)
+	h, fs := newWebdavTestHandler(map[string]string{"/tmp/": "", "/tmp/heap.prof": content})
+	ih := &interruptingHandler{h: h, n: 5000}
+	ts := httptest.NewServer(ih)
+	defer ts.Close()
+	client := NewQcosClient(QcosConfig{Host: ts.URL})
+
+	dir, err := ioutil.TempDir("", "kirk-download")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	local := filepath.Join(dir, "heap.prof")
+
+	var last, total int64
+	progress := func(done, size int64) {
+		assert.True(t, done >= last)
+		last, total = done, size
+	}
+	err = DownloadFileFromContainer(context.TODO(), client, "10.0.0.1", "/tmp/heap.prof", local,
+		DownloadOpts{Retries: 1, Progress: progress})
+	assert.NoError(t, err)
+	b, _ := ioutil.ReadFile(local)
+	assert.Equal(t, content, string(b))
+	assert.Equal(t, []string{"", "bytes=5000-"}, ih.ranges)
+	assert.Equal(t, int64(len(content)), last)
+	assert.Equal(t, int64(len(content)), total)
+	_, err = os.Stat(local + ".part")
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(local + ".part.etag")
+	assert.True(t, os.IsNotExist(err))
+
+	// interrupted without retries, then the file changes
+	os.Remove(local)
+	ih.ranges = nil
+	err = DownloadFileFromContainer(context.TODO(), client, "10.0.0.1", "/tmp/heap.prof", local, DownloadOpts{})
+	assert.Error(t, err)
+	fi, err := os.Stat(local + ".part")
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(5000), fi.Size())
+	}
+	f, _ := fs.OpenFile("/tmp/heap.prof", os.O_WRONLY|os.O_TRUNC, 0)
+	f.Write([]byte("new profile\n"))
+	f.Close()
+	err = DownloadFileFromContainer(context.TODO(), client, "10.0.0.1", "/tmp/heap.prof", local, DownloadOpts{})
+	assert.NoError(t, err)
+	b, _ = ioutil.ReadFile(local)
+	assert.Equal(t, "new profile\n", string(b))
+}
+
+func TestDownloadFileFromContainerComplete(t *testing.T) {
+	ts, _ := newWebdavTestServer(map[string]string{"/core": "0123456789"})
+	defer ts.Close()
+	client := NewQcosClient(QcosConfig{Host: ts.URL})
+
+	dir, err := ioutil.TempDir("", "kirk-download")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	local := filepath.Join(dir, "core")
+
+	// interrupted after the last byte was written
+	info, err := StatContainerPath(context.TODO(), client, "10.0.0.1", "/core")
+	assert.NoError(t, err)
+	ioutil.WriteFile(local+".part", []byte("0123456789"), 0644)
+	ioutil.WriteFile(local+".part.etag", []byte(info.ETag), 0644)
+
+	err = DownloadFileFromContainer(context.TODO(), client, "10.0.0.1", "/core", local, DownloadOpts{})
+	assert.NoError(t, err)
+	b, _ := ioutil.ReadFile(local)
+	assert.Equal(t, "0123456789", string(b))
+}
+
+func TestDownloadFileFromContainerIfRangeIgnored(t *testing.T) {
+	h, _ := newWebdavTestHandler(map[string]string{"/core": "abcdefghij"})
+	var ranges []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		r.Header.Del("If-Range")
+		h.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	client := NewQcosClient(QcosConfig{Host: ts.URL})
+
+	dir, err := ioutil.TempDir("", "kirk-download")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	local := filepath.Join(dir, "core")
+
+	// the part is of a previous version of the file
+	ioutil.WriteFile(local+".part", []byte("01234"), 0644)
+	ioutil.WriteFile(local+".part.etag", []byte(`"old"`), 0644)
+
+	err = DownloadFileFromContainer(context.TODO(), client, "10.0.0.1", "/core", local, DownloadOpts{})
+	assert.NoError(t, err)
+	b, _ := ioutil.ReadFile(local)
+	assert.Equal(t, "abcdefghij", string(b))
+	assert.Equal(t, []string{"bytes=5-", ""}, ranges)
+}
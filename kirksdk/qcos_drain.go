@@ -0,0 +1,187 @@
+package kirksdk
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// defaultContainerRatio is the ratio of a container without container options.
+const defaultContainerRatio = 1
+
+var ErrContainerNotDrained = errors.New("container is not drained")
+
+// ContainerDrainer takes containers out of the APs fronting their service
+// by setting their ratio to 0, and remembers the previous ratios so that
+// UndrainContainer can restore them. It is safe for concurrent use.
+type ContainerDrainer struct {
+	client QcosClient
+
+	mu      sync.Mutex
+	drained map[string]map[ApPortRef]float64 // ip => port => previous ratio
+}
+
+func NewContainerDrainer(client QcosClient) *ContainerDrainer {
+	return &ContainerDrainer{
+		client:  client,
+		drained: make(map[string]map[ApPortRef]float64),
+	}
+}
+
+// DrainContainer sets the ratio of the container to 0 on every AP port
+// having its service as a backend, keeping the ratios of the other
+// containers. It returns the drained ports. Draining a drained container
+// again keeps the ratios remembered first.
+func (p *ContainerDrainer) DrainContainer(ctx context.Context, ip string) (ports []ApPortRef, err error) {
+	info, err := p.client.GetContainerInspect(ctx, ip)
+	if err != nil {
+		return
+	}
+	if ports, err = serviceApPorts(ctx, p.client, info.Stack, info.Service); err != nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	saved := p.drained[ip]
+	if saved == nil {
+		saved = make(map[ApPortRef]float64)
+		p.drained[ip] = saved
+	}
+	for _, port := range ports {
+		var prev map[string]float64
+		if prev, err = setContainerRatios(ctx, p.client, port, map[string]float64{ip: 0}); err != nil {
+			return
+		}
+		if _, ok := saved[port]; !ok {
+			saved[port] = prev[ip]
+		}
+	}
+	return
+}
+
+// UndrainContainer restores the ratios the container had before
+// DrainContainer. It returns ErrContainerNotDrained if the container was not
+// drained by p.
+func (p *ContainerDrainer) UndrainContainer(ctx context.Context, ip string) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	saved, ok := p.drained[ip]
+	if !ok {
+		return ErrContainerNotDrained
+	}
+	for _, port := range sortedApPortRefs(saved) {
+		if _, err = setContainerRatios(ctx, p.client, port, map[string]float64{ip: saved[port]}); err != nil {
+			return
+		}
+		delete(saved, port)
+	}
+	delete(p.drained, ip)
+	return
+}
+
+// Drained returns the drained containers, sorted.
+func (p *ContainerDrainer) Drained() (ips []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for ip := range p.drained {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	return
+}
+
+// DrainedRatios returns the remembered ratios of a drained container.
+func (p *ContainerDrainer) DrainedRatios(ip string) map[ApPortRef]float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ret := make(map[ApPortRef]float64, len(p.drained[ip]))
+	for port, ratio := range p.drained[ip] {
+		ret[port] = ratio
+	}
+	return ret
+}
+
+func sortedApPortRefs(m map[ApPortRef]float64) []ApPortRef {
+	ports := make([]ApPortRef, 0, len(m))
+	for port := range m {
+		ports = append(ports, port)
+	}
+	sort.Sort(apPortRefs(ports))
+	return ports
+}
+
+type apPortRefs []ApPortRef
+
+func (p apPortRefs) Len() int      { return len(p) }
+func (p apPortRefs) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p apPortRefs) Less(i, j int) bool {
+	if p[i].ApID != p[j].ApID {
+		return p[i].ApID < p[j].ApID
+	}
+	return p[i].Port < p[j].Port
+}
+
+// serviceApPorts returns the AP ports having the service as a backend.
+func serviceApPorts(ctx context.Context, client QcosClient, stackName, serviceName string) (ports []ApPortRef, err error) {
+	aps, err := client.ListAps(ctx, ListApsArgs{Stack: stackName, Service: serviceName})
+	if err != nil {
+		return
+	}
+	for _, ap := range aps {
+		var info FullApInfo
+		if info, err = client.GetAp(ctx, ap.ApID); err != nil {
+			return
+		}
+		for _, port := range info.Ports {
+			for _, b := range port.Backends {
+				if b.Stack == stackName && b.Service == serviceName {
+					ports = append(ports, ApPortRef{ApID: ap.ApID, Port: port.FPort})
+					break
+				}
+			}
+		}
+	}
+	return
+}
+
+// setContainerRatios sets the ratios of the given containers on an AP port,
+// keeping the ratios of the other containers, and returns the previous
+// ratios of the given containers.
+func setContainerRatios(ctx context.Context, client QcosClient,
+	port ApPortRef, ratios map[string]float64) (prev map[string]float64, err error) {
+
+	ap, err := client.GetAp(ctx, port.ApID)
+	if err != nil {
+		return
+	}
+	prev = make(map[string]float64, len(ratios))
+	for ip := range ratios {
+		prev[ip] = defaultContainerRatio
+	}
+	var args []SetApContainerOptionsArgs
+	for _, info := range ap.Ports {
+		if info.FPort != port.Port {
+			continue
+		}
+		for _, opt := range info.ContainerOptions {
+			if _, ok := ratios[opt.CotainerIP]; ok {
+				prev[opt.CotainerIP] = opt.Ratio
+				continue
+			}
+			args = append(args, SetApContainerOptionsArgs{IP: opt.CotainerIP, Ratio: opt.Ratio})
+		}
+	}
+	ips := make([]string, 0, len(ratios))
+	for ip := range ratios {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	for _, ip := range ips {
+		args = append(args, SetApContainerOptionsArgs{IP: ip, Ratio: ratios[ip]})
+	}
+	err = client.ApSetContainer(ctx, port.ApID, port.Port, args)
+	return
+}
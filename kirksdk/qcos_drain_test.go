@@ -0,0 +1,39 @@
+package kirksdk
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContainerDrainer(t *testing.T) {
+	client := newRestartTestClient()
+	client.SetApPort(context.TODO(), "ap1", "443", SetApPortArgs{
+		Proto:       "HTTPS",
+		BackendPort: 8443,
+		Backends:    []ApBackendArgs{{Stack: "web", Service: "nginx", Weight: 100}},
+	})
+	drainer := NewContainerDrainer(client)
+
+	ports, err := drainer.DrainContainer(context.TODO(), "10.0.0.3")
+	assert.NoError(t, err)
+	assert.Equal(t, []ApPortRef{{"ap1", "80"}, {"ap1", "443"}}, ports)
+	assert.Equal(t, map[string]float64{"10.0.0.3": 0, "10.0.0.9": 2}, apRatios(client, "ap1", "80"))
+	assert.Equal(t, map[string]float64{"10.0.0.3": 0}, apRatios(client, "ap1", "443"))
+
+	_, err = drainer.DrainContainer(context.TODO(), "10.0.0.3")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.3"}, drainer.Drained())
+	assert.Equal(t, map[ApPortRef]float64{{"ap1", "80"}: 0.5, {"ap1", "443"}: 1}, drainer.DrainedRatios("10.0.0.3"))
+
+	assert.NoError(t, drainer.UndrainContainer(context.TODO(), "10.0.0.3"))
+	assert.Equal(t, map[string]float64{"10.0.0.3": 0.5, "10.0.0.9": 2}, apRatios(client, "ap1", "80"))
+	assert.Equal(t, map[string]float64{"10.0.0.3": 1}, apRatios(client, "ap1", "443"))
+	assert.Empty(t, drainer.Drained())
+
+	assert.Equal(t, ErrContainerNotDrained, drainer.UndrainContainer(context.TODO(), "10.0.0.3"))
+	_, err = drainer.DrainContainer(context.TODO(), "10.0.0.100")
+	assert.Equal(t, errMockNotFound, err)
+}
@@ -0,0 +1,274 @@
+package kirksdk
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+const defaultMaxOutputBytes = 1 << 20
+
+// runCommand implements QcosClient.RunCommand on top of the exec calls.
+func runCommand(ctx context.Context, client QcosClient,
+	ip string, cmd []string, opts RunCommandOpts) (stdout, stderr []byte, exitCode int, err error) {
+
+	if opts.MaxOutputBytes == 0 {
+		opts.MaxOutputBytes = defaultMaxOutputBytes
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	outBuf := &cappedBuffer{max: opts.MaxOutputBytes}
+	errBuf := &cappedBuffer{max: opts.MaxOutputBytes}
+	exitCode, err = execWait(ctx, client, ip, cmd, opts.Stdin, outBuf, errBuf)
+	stdout, stderr = outBuf.Bytes(), errBuf.Bytes()
+	if err != nil {
+		return
+	}
+	if outBuf.truncated || errBuf.truncated {
+		err = ErrOutputTruncated
+	}
+	return
+}
+
+// execWait runs cmd in the container and waits for its exit code. When ctx
+// is done, it detaches from the exec before returning.
+func execWait(ctx context.Context, client QcosClient,
+	ip string, cmd []string, stdin io.Reader, stdout, stderr io.Writer) (exitCode int, err error) {
+
+	exitCode = -1
+	exec, err := client.ExecContainer(ctx, ip, ExecContainerArgs{Command: cmd})
+	if err != nil {
+		return
+	}
+	done := make(chan error, 1)
+	exitCh := make(chan struct{})
+	go func() {
+		done <- client.StartContainerExec(ctx, ip, exec.ExecID, StartContainerExecArgs{},
+			StartContainerExecOpts{InStream: stdin, OutStream: stdout, ErrStream: stderr, ExitCh: exitCh})
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// detach, and wait for the outputs to be no longer written
+		close(exitCh)
+		<-done
+		err = ctx.Err()
+	}
+	if err != nil {
+		err = fmt.Errorf("exec %v in %s: %v", cmd, ip, err)
+		return
+	}
+
+	// the exit code may be reported shortly after the output ends
+	for {
+		var info ExecInspectInfo
+		if info, err = client.InspectContainerExec(ctx, ip, exec.ExecID); err != nil {
+			return
+		}
+		if !info.Running {
+			return info.ExitCode, nil
+		}
+		if err = sleepContext(ctx, 100*time.Millisecond); err != nil {
+			return
+		}
+	}
+}
+
+// cappedBuffer keeps up to max bytes, or all if max is negative. It is safe
+// to read while an exec writes to it.
+type cappedBuffer struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	max       int64
+	truncated bool
+}
+
+func (p *cappedBuffer) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := len(b)
+	if p.max >= 0 {
+		if room := p.max - int64(p.buf.Len()); int64(len(b)) > room {
+			b = b[:room]
+			p.truncated = true
+		}
+	}
+	p.buf.Write(b)
+	return n, nil
+}
+
+func (p *cappedBuffer) Bytes() []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]byte(nil), p.buf.Bytes()...)
+}
+
+type ExecOnServiceOpts struct {
+	// Maximal number of containers running the command at once. Default 8.
+	Concurrency int
+
+	// Timeout of the command in each container. No timeout by default.
+	Timeout time.Duration
+
+	// Limit of the output kept per container, as in RunCommandOpts.
+	MaxOutputBytes int64
+
+	// Streaming mode: the output is written to Stdout and Stderr as it
+	// comes, each line prefixed with "[ip] ", and is not kept in the
+	// results. Either may be nil to discard the stream.
+	Stream bool
+	Stdout io.Writer
+	Stderr io.Writer
+
+	Logger *logrus.Logger
+}
+
+type ExecResult struct {
+	Container string        `json:"container"`
+	ExitCode  int           `json:"exitCode"`
+	Stdout    string        `json:"stdout,omitempty"`
+	Stderr    string        `json:"stderr,omitempty"`
+	Duration  time.Duration `json:"duration"`
+	Err       error         `json:"-"`
+}
+
+type ExecResults []ExecResult
+
+// Failed returns the results with an error or a non-zero exit code.
+func (p ExecResults) Failed() (ret ExecResults) {
+	for _, r := range p {
+		if r.Err != nil || r.ExitCode != 0 {
+			ret = append(ret, r)
+		}
+	}
+	return
+}
+
+// ExecOnService runs cmd in every container of the service in parallel.
+// The results are in the order of the containers. An error in a container
+// is reported in its result, err is only set if the containers can not be
+// listed.
+func ExecOnService(ctx context.Context, client QcosClient,
+	stackName, serviceName string, cmd []string, opts ExecOnServiceOpts) (ret ExecResults, err error) {
+
+	if stackName == "" {
+		stackName = DefaultStack
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 8
+	}
+	log := loggerOf(client, opts.Logger).WithField("service", stackName+"/"+serviceName)
+
+	ips, err := client.ListContainers(ctx, ListContainersArgs{StackName: stackName, ServiceName: serviceName})
+	if err != nil {
+		return
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("%s/%s has no container", stackName, serviceName)
+	}
+
+	var (
+		outMu, errMu sync.Mutex
+		wg           sync.WaitGroup
+		sem          = make(chan struct{}, opts.Concurrency)
+	)
+	ret = make(ExecResults, len(ips))
+	for i, ip := range ips {
+		wg.Add(1)
+		go func(i int, ip string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			r := ExecResult{Container: ip}
+			start := time.Now()
+			if opts.Stream {
+				stdout := newPrefixWriter(opts.Stdout, &outMu, "["+ip+"] ")
+				stderr := newPrefixWriter(opts.Stderr, &errMu, "["+ip+"] ")
+				ctx := ctx
+				if opts.Timeout > 0 {
+					var cancel context.CancelFunc
+					ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+					defer cancel()
+				}
+				r.ExitCode, r.Err = execWait(ctx, client, ip, cmd, nil, stdout, stderr)
+				// the exec no longer writes once execWait returns, even on
+				// timeout
+				stdout.Flush()
+				stderr.Flush()
+			} else {
+				var stdout, stderr []byte
+				stdout, stderr, r.ExitCode, r.Err = client.RunCommand(ctx, ip, cmd,
+					RunCommandOpts{Timeout: opts.Timeout, MaxOutputBytes: opts.MaxOutputBytes})
+				r.Stdout, r.Stderr = string(stdout), string(stderr)
+			}
+			r.Duration = time.Since(start)
+			if r.Err != nil {
+				log.Warnf("exec %v in %s failed: %v", cmd, ip, r.Err)
+			}
+			ret[i] = r
+		}(i, ip)
+	}
+	wg.Wait()
+	return
+}
+
+// prefixWriter writes whole lines to w, each prefixed with prefix. Writers
+// sharing w share mu, so that their lines do not interleave.
+type prefixWriter struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	prefix string
+	line   []byte
+}
+
+func newPrefixWriter(w io.Writer, mu *sync.Mutex, prefix string) *prefixWriter {
+	if w == nil {
+		w = ioutil.Discard
+	}
+	return &prefixWriter{w: w, mu: mu, prefix: prefix}
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			p.line = append(p.line, b...)
+			break
+		}
+		p.line = append(p.line, b[:i+1]...)
+		b = b[i+1:]
+		if err := p.writeLine(); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// Flush writes the last line if it does not end with a newline.
+func (p *prefixWriter) Flush() error {
+	if len(p.line) == 0 {
+		return nil
+	}
+	p.line = append(p.line, '\n')
+	return p.writeLine()
+}
+
+func (p *prefixWriter) writeLine() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := io.WriteString(p.w, p.prefix+string(p.line))
+	p.line = p.line[:0]
+	return err
+}
@@ -0,0 +1,286 @@
+package kirksdk
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+var ErrDetached = errors.New("detached from exec")
+
+// DefaultDetachKeys is ctrl-p ctrl-q, as in docker.
+var DefaultDetachKeys = []byte{0x10, 0x11}
+
+// ExecSession runs an interactive command in a container, like docker exec
+// -it. With TTY set, a terminal on Stdin is put into raw mode for the
+// session, and the size of the terminal is kept in sync with the exec.
+type ExecSession struct {
+	Client  QcosClient
+	IP      string
+	Command []string
+	TTY     bool
+
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Typing DetachKeys detaches from the exec, leaving it running. Default
+	// DefaultDetachKeys, empty and not nil to disable.
+	DetachKeys []byte
+
+	// Records the session in the asciicast format, with the size of the
+	// terminal.
+	Recorder *ExecRecorder
+
+	Logger *logrus.Logger
+}
+
+// NewExecSession returns a TTY session on the standard streams.
+func NewExecSession(client QcosClient, ip string, command []string) *ExecSession {
+	return &ExecSession{
+		Client:  client,
+		IP:      ip,
+		Command: command,
+		TTY:     true,
+		Stdin:   os.Stdin,
+		Stdout:  os.Stdout,
+		Stderr:  os.Stderr,
+	}
+}
+
+// Run runs the session until the command exits. It returns ErrDetached if
+// the user detached, and ctx.Err() if ctx is done, the command being left
+// running in both cases.
+func (p *ExecSession) Run(ctx context.Context) (err error) {
+	log := loggerOf(p.Client, p.Logger).WithField("container", p.IP)
+	exec, err := p.Client.ExecContainer(ctx, p.IP, ExecContainerArgs{Command: p.Command})
+	if err != nil {
+		return
+	}
+	args := StartContainerExecArgs{Mode: ExecModeNonTTY}
+	if p.TTY {
+		args.Mode = ExecModeTTY
+		if f, ok := p.Stdin.(*os.File); ok && isTerminal(f.Fd()) {
+			state, err := makeRaw(f.Fd())
+			if err != nil {
+				return err
+			}
+			defer restoreTerm(f.Fd(), state)
+		}
+	}
+
+	exitCh := make(chan struct{})
+	detached := make(chan struct{})
+	keys := p.DetachKeys
+	if keys == nil {
+		keys = DefaultDetachKeys
+	}
+	// the exec reads a pipe closed when the session ends, rather than Stdin
+	var stdin io.Reader
+	if p.Stdin != nil {
+		input := newSessionInput(p.Stdin)
+		defer input.close()
+		stdin = input.r
+	}
+	if stdin != nil && len(keys) > 0 {
+		stdin = &detachReader{r: stdin, keys: keys, detached: detached, exit: exitCh}
+	}
+	if p.Recorder != nil {
+		p.Recorder.setCommand(p.Command)
+	}
+	readyCh := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Client.StartContainerExec(ctx, p.IP, exec.ExecID, args, StartContainerExecOpts{
+			InStream:  stdin,
+			OutStream: p.Stdout,
+			ErrStream: p.Stderr,
+			ReadyCh:   readyCh,
+			ExitCh:    exitCh,
+			Recorder:  p.Recorder,
+		})
+	}()
+
+	select {
+	case <-readyCh:
+	case err = <-done:
+		return
+	}
+	winch := make(chan os.Signal, 1)
+	if p.TTY {
+		p.resize(ctx, exec.ExecID, log)
+		notifyResize(winch)
+		defer signal.Stop(winch)
+	}
+	readyCh <- struct{}{}
+
+	for {
+		select {
+		case err = <-done:
+			return
+		case <-winch:
+			p.resize(ctx, exec.ExecID, log)
+		case <-detached:
+			close(exitCh)
+			<-done
+			return ErrDetached
+		case <-ctx.Done():
+			close(exitCh)
+			<-done
+			return ctx.Err()
+		}
+	}
+}
+
+// resize sets the size of the exec tty to the size of the local terminal.
+func (p *ExecSession) resize(ctx context.Context, execID string, log *logrus.Entry) {
+	for _, s := range []interface{}{p.Stdout, p.Stdin} {
+		f, ok := s.(*os.File)
+		if !ok || !isTerminal(f.Fd()) {
+			continue
+		}
+		width, height, err := termSize(f.Fd())
+		if err != nil {
+			continue
+		}
+		if p.Recorder != nil {
+			p.Recorder.Resize(width, height)
+		}
+		err = p.Client.ResizeContainerExecTerm(ctx, p.IP, execID,
+			ResizeContainerExecTermArgs{Height: height, Width: width})
+		if err != nil {
+			log.Warnf("resize exec tty failed: %v", err)
+		}
+		return
+	}
+}
+
+// sessionInput pumps the input of a session into a pipe. The input of a
+// file, such as a terminal, is only read once it is readable, so that the
+// pump stops when the session ends and the keys typed after it are left to
+// the next reader.
+type sessionInput struct {
+	r       *io.PipeReader
+	w       *io.PipeWriter
+	done    chan struct{}
+	stopped chan struct{}
+	poll    bool
+}
+
+func newSessionInput(r io.Reader) *sessionInput {
+	pr, pw := io.Pipe()
+	p := &sessionInput{r: pr, w: pw, done: make(chan struct{}), stopped: make(chan struct{})}
+	if f, ok := r.(*os.File); ok {
+		_, err := waitReadable(f.Fd(), 0)
+		p.poll = err == nil
+	}
+	go p.pump(r)
+	return p
+}
+
+func (p *sessionInput) pump(r io.Reader) {
+	defer close(p.stopped)
+	buf := make([]byte, 1024)
+	for {
+		if p.poll {
+			ready, err := waitReadable(r.(*os.File).Fd(), 100*time.Millisecond)
+			if err != nil {
+				p.w.CloseWithError(err)
+				return
+			}
+			select {
+			case <-p.done:
+				return
+			default:
+			}
+			if !ready {
+				continue
+			}
+		}
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, werr := p.w.Write(buf[:n]); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			p.w.CloseWithError(err)
+			return
+		}
+	}
+}
+
+// close closes the pipe, and waits for the pump to stop unless it is blocked
+// reading the input.
+func (p *sessionInput) close() {
+	close(p.done)
+	p.r.Close()
+	if p.poll {
+		<-p.stopped
+	}
+}
+
+// detachReader forwards the input until the detach keys are read. Bytes
+// matching the beginning of the keys are held back until they do not match.
+// Once the input before the keys is read, detached is closed and Read blocks
+// until exit is closed, so that the input of the exec is not closed.
+type detachReader struct {
+	r        io.Reader
+	keys     []byte
+	detached chan struct{}
+	exit     chan struct{}
+
+	once    sync.Once
+	buf     [1024]byte
+	out     []byte
+	pending []byte
+	err     error
+}
+
+func (p *detachReader) Read(b []byte) (int, error) {
+	for len(p.out) == 0 {
+		if p.err == ErrDetached {
+			p.once.Do(func() { close(p.detached) })
+			<-p.exit
+		}
+		if p.err != nil {
+			return 0, p.err
+		}
+		n, err := p.r.Read(p.buf[:])
+		p.feed(p.buf[:n])
+		if p.err == nil && err != nil {
+			p.out = append(p.out, p.pending...)
+			p.pending = nil
+			p.err = err
+		}
+	}
+	n := copy(b, p.out)
+	p.out = p.out[n:]
+	return n, nil
+}
+
+func (p *detachReader) feed(b []byte) {
+	for _, c := range b {
+		if c == p.keys[len(p.pending)] {
+			p.pending = append(p.pending, c)
+			if len(p.pending) == len(p.keys) {
+				p.err = ErrDetached
+				return
+			}
+			continue
+		}
+		p.out = append(p.out, p.pending...)
+		p.pending = p.pending[:0]
+		if c == p.keys[0] {
+			p.pending = append(p.pending, c)
+		} else {
+			p.out = append(p.out, c)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package kirksdk
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetachReader(t *testing.T) {
+	exit := make(chan struct{})
+	r := &detachReader{
+		r:        strings.NewReader("a\x10b\x10\x10\x11c"),
+		keys:     DefaultDetachKeys,
+		detached: make(chan struct{}),
+		exit:     exit,
+	}
+	b := make([]byte, 100)
+	n, err := r.Read(b)
+	assert.NoError(t, err)
+	assert.Equal(t, "a\x10b\x10", string(b[:n]))
+
+	go func() {
+		<-r.detached
+		close(exit)
+	}()
+	_, err = r.Read(b)
+	assert.Equal(t, ErrDetached, err)
+
+	// held back bytes are flushed at EOF
+	r = &detachReader{r: strings.NewReader("x\x10"), keys: DefaultDetachKeys, detached: make(chan struct{})}
+	all, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "x\x10", string(all))
+}
+
+func newExecSessionTestClient() *mockQcosClient {
+	client := newRolloutTestClient()
+	client.execHandler = func(ip string, command []string, stdin io.Reader, stdout, stderr io.Writer) int {
+		io.Copy(stdout, stdin)
+		return 0
+	}
+	return client
+}
+
+func TestExecSession(t *testing.T) {
+	client := newExecSessionTestClient()
+	var out bytes.Buffer
+	session := &ExecSession{
+		Client:  client,
+		IP:      "10.0.0.1",
+		Command: []string{"cat"},
+		TTY:     true,
+		Stdin:   strings.NewReader("hello\n"),
+		Stdout:  &out,
+	}
+	assert.NoError(t, session.Run(context.TODO()))
+	assert.Equal(t, "hello\n", out.String())
+	assert.Equal(t, []string{"Exec 10.0.0.1 cat"}, client.calls)
+}
+
+func TestExecSessionDetach(t *testing.T) {
+	client := newExecSessionTestClient()
+	stdin, stdinW := io.Pipe()
+	defer stdinW.Close()
+	out := &cappedBuffer{max: -1}
+	session := &ExecSession{
+		Client:  client,
+		IP:      "10.0.0.1",
+		Command: []string{"sh"},
+		Stdin:   stdin,
+		Stdout:  out,
+	}
+	go stdinW.Write([]byte("ls\n\x10\x11"))
+	assert.Equal(t, ErrDetached, session.Run(context.TODO()))
+	assert.Equal(t, "ls\n", string(out.Bytes()))
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	session.Stdin, _ = io.Pipe()
+	assert.Equal(t, context.Canceled, session.Run(ctx))
+}
+
+func TestExecSessionStdinFile(t *testing.T) {
+	stdin, stdinW, err := os.Pipe()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer stdin.Close()
+	defer stdinW.Close()
+	if _, err = waitReadable(stdin.Fd(), 0); err != nil {
+		t.Skip("poll not supported")
+	}
+
+	client := newExecSessionTestClient()
+	client.execHandler = func(ip string, command []string, stdin io.Reader, stdout, stderr io.Writer) int {
+		b := make([]byte, 3)
+		io.ReadFull(stdin, b)
+		stdout.Write(b)
+		return 0
+	}
+	var out bytes.Buffer
+	session := &ExecSession{
+		Client:  client,
+		IP:      "10.0.0.1",
+		Command: []string{"head", "-c", "3"},
+		Stdin:   stdin,
+		Stdout:  &out,
+	}
+	io.WriteString(stdinW, "ls\n")
+	assert.NoError(t, session.Run(context.TODO()))
+	assert.Equal(t, "ls\n", out.String())
+
+	// the input typed after the session is left on stdin
+	io.WriteString(stdinW, "next\n")
+	read := make(chan string, 1)
+	go func() {
+		b := make([]byte, 5)
+		n, _ := stdin.Read(b)
+		read <- string(b[:n])
+	}()
+	select {
+	case s := <-read:
+		assert.Equal(t, "next\n", s)
+	case <-time.After(time.Second):
+		t.Error("input read by the ended session")
+	}
+}
@@ -0,0 +1,269 @@
+package kirksdk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeStdFrame(w io.Writer, stream byte, s string) {
+	header := make([]byte, stdWriterPrefixLen)
+	header[stdWriterFdIndex] = stream
+	binary.BigEndian.PutUint32(header[stdWriterSizeIndex:], uint32(len(s)))
+	w.Write(header)
+	io.WriteString(w, s)
+}
+
+func TestRunCommand(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "POST /v3/containers/10.0.0.1/exec":
+			fmt.Fprint(w, `{"execId": "e1"}`)
+		case "GET /v3/containers/10.0.0.1/exec/e1":
+			fmt.Fprint(w, `{"execId": "e1", "running": false, "exitCode": 3}`)
+		case "POST /v3/containers/10.0.0.1/exec/e1/start":
+			body, _ := ioutil.ReadAll(r.Body)
+			assert.Equal(t, `{"mode":""}`, string(body))
+			conn, rw, err := w.(http.Hijacker).Hijack()
+			assert.NoError(t, err)
+			defer conn.Close()
+			rw.WriteString("HTTP/1.1 101 UPGRADED\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+			rw.Flush()
+			// upper-case the input until the client closes it
+			in, err := ioutil.ReadAll(rw.Reader)
+			assert.NoError(t, err)
+			writeStdFrame(conn, 1, strings.ToUpper(string(in)))
+			writeStdFrame(conn, 2, "done\n")
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+
+	client := NewQcosClient(QcosConfig{Host: ts.URL})
+	stdout, stderr, code, err := client.RunCommand(context.TODO(), "10.0.0.1", []string{"tr", "a-z", "A-Z"},
+		RunCommandOpts{Stdin: strings.NewReader("hello\n")})
+	assert.NoError(t, err)
+	assert.Equal(t, "HELLO\n", string(stdout))
+	assert.Equal(t, "done\n", string(stderr))
+	assert.Equal(t, 3, code)
+}
+
+// blockingInput blocks its second Read until released.
+type blockingInput struct {
+	reads    int
+	blocked  chan struct{}
+	release  chan struct{}
+	readMore chan struct{}
+}
+
+func (p *blockingInput) Read(b []byte) (int, error) {
+	p.reads++
+	switch p.reads {
+	case 1:
+		return copy(b, "hello\n"), nil
+	case 2:
+		close(p.blocked)
+		<-p.release
+		return copy(b, "late\n"), nil
+	}
+	close(p.readMore)
+	return 0, io.EOF
+}
+
+func TestStartContainerExecInput(t *testing.T) {
+	received := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		assert.NoError(t, err)
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 UPGRADED\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+		rw.Flush()
+		in, _ := ioutil.ReadAll(rw.Reader)
+		received <- string(in)
+	}))
+	defer ts.Close()
+
+	in := &blockingInput{
+		blocked:  make(chan struct{}),
+		release:  make(chan struct{}),
+		readMore: make(chan struct{}),
+	}
+	exitCh := make(chan struct{})
+	errch := make(chan error, 1)
+	client := NewQcosClient(QcosConfig{Host: ts.URL})
+	go func() {
+		errch <- client.StartContainerExec(context.TODO(), "10.0.0.1", "e1", StartContainerExecArgs{},
+			StartContainerExecOpts{InStream: in, ExitCh: exitCh})
+	}()
+
+	<-in.blocked
+	close(exitCh)
+	assert.NoError(t, <-errch)
+	assert.Equal(t, "hello\n", <-received)
+
+	// the blocked Read returns, but the input is not read again
+	close(in.release)
+	select {
+	case <-in.readMore:
+		t.Error("input read after StartContainerExec returned")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRunCommandMock(t *testing.T) {
+	client := newHookTestClient()
+	client.execHandler = func(ip string, command []string, stdin io.Reader, stdout, stderr io.Writer) int {
+		io.WriteString(stdout, strings.Repeat("x", 100))
+		return 0
+	}
+	stdout, _, code, err := client.RunCommand(context.TODO(), "10.0.0.1", []string{"yes"},
+		RunCommandOpts{MaxOutputBytes: 10})
+	assert.Equal(t, ErrOutputTruncated, err)
+	assert.Equal(t, strings.Repeat("x", 10), string(stdout))
+	assert.Equal(t, 0, code)
+
+	stdout, _, _, err = client.RunCommand(context.TODO(), "10.0.0.1", []string{"yes"},
+		RunCommandOpts{MaxOutputBytes: -1})
+	assert.NoError(t, err)
+	assert.Len(t, stdout, 100)
+
+	_, _, code, err = client.RunCommand(context.TODO(), "10.0.0.9", []string{"true"}, RunCommandOpts{})
+	assert.Error(t, err)
+	assert.Equal(t, -1, code)
+}
+
+func TestRunCommandTimeout(t *testing.T) {
+	client := newHookTestClient()
+	release := make(chan struct{})
+	late := make(chan error, 1)
+	client.execHandler = func(ip string, command []string, stdin io.Reader, stdout, stderr io.Writer) int {
+		io.WriteString(stdout, "started\n")
+		<-release
+		_, err := io.WriteString(stdout, "late\n")
+		late <- err
+		return 0
+	}
+
+	_, _, code, err := client.RunCommand(context.TODO(), "10.0.0.1", []string{"sleep", "60"},
+		RunCommandOpts{Timeout: 20 * time.Millisecond})
+	assert.Error(t, err)
+	assert.Equal(t, -1, code)
+	close(release)
+	assert.Equal(t, io.ErrClosedPipe, <-late)
+
+	// nothing is written once execWait returns
+	release = make(chan struct{})
+	var stdout bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.TODO(), 20*time.Millisecond)
+	defer cancel()
+	_, err = execWait(ctx, client, "10.0.0.1", []string{"sleep", "60"}, nil, &stdout, nil)
+	assert.Error(t, err)
+	close(release)
+	assert.Equal(t, io.ErrClosedPipe, <-late)
+	assert.Equal(t, "started\n", stdout.String())
+	assert.Contains(t, client.calls, "Detach 10.0.0.1 sleep 60")
+}
+
+func TestExecOnService(t *testing.T) {
+	client := newHookTestClient()
+	client.execHandler = func(ip string, command []string, stdin io.Reader, stdout, stderr io.Writer) int {
+		fmt.Fprintf(stdout, "hello from %s\n", ip)
+		if ip == "10.0.0.2" {
+			io.WriteString(stderr, "oops")
+			return 1
+		}
+		return 0
+	}
+
+	results, err := ExecOnService(context.TODO(), client, "web", "nginx", []string{"hostname"},
+		ExecOnServiceOpts{Concurrency: 1})
+	assert.NoError(t, err)
+	if assert.Len(t, results, 2) {
+		assert.Equal(t, "10.0.0.1", results[0].Container)
+		assert.Equal(t, "hello from 10.0.0.1\n", results[0].Stdout)
+		assert.Equal(t, 0, results[0].ExitCode)
+		assert.Equal(t, "10.0.0.2", results[1].Container)
+		assert.Equal(t, "oops", results[1].Stderr)
+		assert.Equal(t, 1, results[1].ExitCode)
+	}
+	failed := results.Failed()
+	if assert.Len(t, failed, 1) {
+		assert.Equal(t, "10.0.0.2", failed[0].Container)
+	}
+
+	_, err = ExecOnService(context.TODO(), client, "web", "redis", []string{"hostname"}, ExecOnServiceOpts{})
+	assert.Error(t, err)
+}
+
+func TestExecOnServiceStream(t *testing.T) {
+	client := newHookTestClient()
+	client.execHandler = func(ip string, command []string, stdin io.Reader, stdout, stderr io.Writer) int {
+		io.WriteString(stdout, "a\nb")
+		io.WriteString(stdout, "c\n")
+		io.WriteString(stderr, "no newline")
+		return 0
+	}
+
+	var stdout, stderr bytes.Buffer
+	results, err := ExecOnService(context.TODO(), client, "web", "nginx", []string{"ls"},
+		ExecOnServiceOpts{Stream: true, Stdout: &stdout, Stderr: &stderr})
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+	assert.Empty(t, results.Failed())
+	assert.Empty(t, results[0].Stdout)
+
+	lines := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+	sort.Strings(lines)
+	assert.Equal(t, []string{"[10.0.0.1] a", "[10.0.0.1] bc", "[10.0.0.2] a", "[10.0.0.2] bc"}, lines)
+	lines = strings.Split(strings.TrimSuffix(stderr.String(), "\n"), "\n")
+	sort.Strings(lines)
+	assert.Equal(t, []string{"[10.0.0.1] no newline", "[10.0.0.2] no newline"}, lines)
+}
+
+func TestExecOnServiceStreamTimeout(t *testing.T) {
+	client := newHookTestClient()
+	release := make(chan struct{})
+	late := make(chan error, 2)
+	client.execHandler = func(ip string, command []string, stdin io.Reader, stdout, stderr io.Writer) int {
+		if ip == "10.0.0.1" {
+			io.WriteString(stdout, "done\n")
+			return 0
+		}
+		io.WriteString(stdout, "partial")
+		<-release
+		_, err := io.WriteString(stdout, " line\nlate\n")
+		late <- err
+		return 0
+	}
+
+	var stdout bytes.Buffer
+	results, err := ExecOnService(context.TODO(), client, "web", "nginx", []string{"sleep", "60"},
+		ExecOnServiceOpts{Stream: true, Stdout: &stdout, Timeout: 50 * time.Millisecond})
+	assert.NoError(t, err)
+	if assert.Len(t, results, 2) {
+		assert.NoError(t, results[0].Err)
+		assert.Error(t, results[1].Err)
+	}
+	out := stdout.String()
+	close(release)
+	assert.Equal(t, io.ErrClosedPipe, <-late)
+	assert.Equal(t, out, stdout.String())
+
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	sort.Strings(lines)
+	assert.Equal(t, []string{"[10.0.0.1] done", "[10.0.0.2] partial"}, lines)
+}
@@ -0,0 +1,185 @@
+package kirksdk
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// ToServiceSpec converts an exported service spec back into a spec that can
+// be submitted with CreateService or UpdateService.
+func (p ServiceSpecExport) ToServiceSpec() ServiceSpec {
+	return ServiceSpec{
+		AutoRestart:   p.AutoRestart,
+		Command:       p.Command,
+		EntryPoint:    p.EntryPoint,
+		Envs:          p.Envs,
+		Hosts:         p.Hosts,
+		Image:         p.Image,
+		LogCollectors: p.LogCollectors,
+		Confs:         p.Confs,
+		StopGraceSec:  p.StopGraceSec,
+		WorkDir:       p.WorkDir,
+		UnitType:      p.UnitType,
+	}
+}
+
+func serviceSpecToExport(p ServiceSpec) ServiceSpecExport {
+	return ServiceSpecExport{
+		AutoRestart:   p.AutoRestart,
+		Command:       p.Command,
+		EntryPoint:    p.EntryPoint,
+		Envs:          p.Envs,
+		Hosts:         p.Hosts,
+		Image:         p.Image,
+		LogCollectors: p.LogCollectors,
+		Confs:         p.Confs,
+		StopGraceSec:  p.StopGraceSec,
+		WorkDir:       p.WorkDir,
+		UnitType:      p.UnitType,
+	}
+}
+
+// ToCreateServiceArgs converts a service export into the arguments needed to
+// recreate the same service.
+func (p ServiceExportInfo) ToCreateServiceArgs() CreateServiceArgs {
+	return CreateServiceArgs{
+		InstanceNum:       p.InstanceNum,
+		UpdateParallelism: p.UpdateParallelism,
+		Metadata:          p.Metadata,
+		Name:              p.Name,
+		Spec:              p.Spec.ToServiceSpec(),
+		Stateful:          p.Stateful,
+		Volumes:           p.Volumes,
+	}
+}
+
+// ToUpdateServiceArgs converts a service export into the arguments needed to
+// update an existing service to the exported spec.
+func (p ServiceExportInfo) ToUpdateServiceArgs() UpdateServiceArgs {
+	return UpdateServiceArgs{
+		Metadata:          p.Metadata,
+		Spec:              p.Spec.ToServiceSpec(),
+		UpdateParallelism: p.UpdateParallelism,
+	}
+}
+
+// restoreService updates a service to the spec, metadata and update
+// parallelism of an export. Empty fields are omitted from an update, so a
+// field of the spec cleared since the export keeps its current value; the
+// spec is checked after the update and an error names the fields that could
+// not be restored.
+func restoreService(ctx context.Context, client QcosClient,
+	stackName, serviceName string, export ServiceExportInfo, sync bool) error {
+
+	if err := updateService(ctx, client, stackName, serviceName, export.ToUpdateServiceArgs(), sync); err != nil {
+		return err
+	}
+	cur, err := client.GetServiceExport(ctx, stackName, serviceName)
+	if err != nil {
+		return err
+	}
+	diffs := DiffServiceExports(ServiceExportInfo{Spec: export.Spec}, ServiceExportInfo{Spec: cur.Spec})
+	if len(diffs) > 0 {
+		var fields []string
+		for _, diff := range diffs {
+			fields = append(fields, diff.Field)
+		}
+		return fmt.Errorf("%s/%s: %s could not be restored", stackName, serviceName, strings.Join(fields, ", "))
+	}
+	return nil
+}
+
+// ToCreateJobArgs converts a job into the arguments needed to recreate it.
+func (p JobInfo) ToCreateJobArgs() CreateJobArgs {
+	return CreateJobArgs{
+		Name:     p.Name,
+		Spec:     p.Spec,
+		Mode:     p.Mode,
+		Metadata: p.Metadata,
+		RunAt:    p.RunAt,
+		Timeout:  p.Timeout,
+	}
+}
+
+// ToUpdateJobArgs converts a job into the arguments needed to update an
+// existing job to the same spec.
+func (p JobInfo) ToUpdateJobArgs() UpdateJobArgs {
+	return UpdateJobArgs{
+		Spec:     p.Spec,
+		Metadata: p.Metadata,
+		RunAt:    p.RunAt,
+		Timeout:  p.Timeout,
+		Mode:     p.Mode,
+	}
+}
+
+// ToCreateApArgs converts an AP into the arguments needed to recreate it.
+// Ports, user domains and container options must be restored separately.
+func (p FullApInfo) ToCreateApArgs() CreateApArgs {
+	return CreateApArgs{
+		Type:         p.Type,
+		Provider:     p.Provider,
+		Bandwidth:    p.Bandwidth,
+		UnitType:     p.UnitType,
+		Host:         p.Host,
+		Title:        p.Title,
+		RequireAuth:  p.RequireAuth,
+		UIDWhiteList: p.UIDWhiteList,
+		UIDBlackList: p.UIDBlackList,
+	}
+}
+
+// ToSetApDescArgs converts an AP into the arguments needed to update the
+// description of an existing AP.
+func (p FullApInfo) ToSetApDescArgs() SetApDescArgs {
+	return SetApDescArgs{
+		UnitType:     p.UnitType,
+		Host:         p.Host,
+		Title:        p.Title,
+		Bandwidth:    p.Bandwidth,
+		RequireAuth:  p.RequireAuth,
+		UIDWhiteList: p.UIDWhiteList,
+		UIDBlackList: p.UIDBlackList,
+	}
+}
+
+// ToSetApPortArgs returns the args to set the port as it is. Proxy and
+// health check options are left nil when empty, as in exports without them.
+// It fails for port ranges, which are set by SetApPortRange.
+func (p ApPortInfo) ToSetApPortArgs() (ret SetApPortArgs, err error) {
+	backendPort, err := strconv.Atoi(p.BPort)
+	if err != nil {
+		return ret, fmt.Errorf("port %s: invalid backend port %q", p.FPort, p.BPort)
+	}
+	ret = SetApPortArgs{
+		Proto:         p.Proto,
+		BackendPort:   backendPort,
+		SessionTmoSec: p.SessionTmoSec,
+		Backends:      p.BackendArgs(),
+	}
+	if proxyOpts := p.ProxyOpts; !reflect.DeepEqual(proxyOpts, ApProxyOpts{}) {
+		ret.ProxyOpt = &proxyOpts
+	}
+	if healthCheck := p.HealthCheckOpts; !reflect.DeepEqual(healthCheck, ApHealthCheckOpts{}) {
+		ret.HealthCheck = &healthCheck
+	}
+	return
+}
+
+// BackendArgs returns the backends of the port in the form accepted by
+// SetApPort and SetApPortRange.
+func (p ApPortInfo) BackendArgs() []ApBackendArgs {
+	ret := make([]ApBackendArgs, 0, len(p.Backends))
+	for _, b := range p.Backends {
+		ret = append(ret, ApBackendArgs{
+			Stack:   b.Stack,
+			Service: b.Service,
+			Weight:  b.DefaultWeight,
+		})
+	}
+	return ret
+}
@@ -0,0 +1,213 @@
+package kirksdk
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+type HookPhase string
+
+const (
+	HookPreDeploy  = HookPhase("pre-deploy")
+	HookPostDeploy = HookPhase("post-deploy")
+)
+
+// DeployHook is a command run inside containers of the service around an
+// update. Output beyond 1 MiB is discarded.
+type DeployHook struct {
+	Name    string
+	Command []string
+
+	// Run in this container. Otherwise the hook runs in the first running
+	// container of the service, or in every running container if All is set.
+	Container string
+	All       bool
+
+	// Timeout of the hook in each container. Default 60s. On timeout, the
+	// connection to the exec is closed and its output no longer read.
+	Timeout time.Duration
+}
+
+type HookResult struct {
+	Hook      string        `json:"hook"`
+	Phase     HookPhase     `json:"phase"`
+	Container string        `json:"container"`
+	ExitCode  int           `json:"exitCode"`
+	Stdout    string        `json:"stdout"`
+	Stderr    string        `json:"stderr"`
+	Duration  time.Duration `json:"duration"`
+	Err       error         `json:"-"`
+}
+
+// HookError is returned when a hook fails, either with an error or with a
+// non-zero exit code. RolledBack reports whether the service is back to its
+// previous spec, RollbackErr why not if a rollback was attempted.
+type HookError struct {
+	Hook        string
+	Phase       HookPhase
+	Container   string
+	ExitCode    int
+	Err         error
+	RolledBack  bool
+	RollbackErr error
+}
+
+func (p *HookError) Error() string {
+	msg := fmt.Sprintf("%s hook %s in %s", p.Phase, p.Hook, p.Container)
+	if p.Err != nil {
+		msg += ": " + p.Err.Error()
+	} else {
+		msg += fmt.Sprintf(": exit code %d", p.ExitCode)
+	}
+	if p.RolledBack {
+		msg += ", rolled back"
+	}
+	if p.RollbackErr != nil {
+		msg += fmt.Sprintf(" (rollback failed: %v)", p.RollbackErr)
+	}
+	return msg
+}
+
+type DeployHooksOpts struct {
+	PreDeploy  []DeployHook
+	PostDeploy []DeployHook
+
+	// Wait for the update with SyncUpdateService. The update is always
+	// waited for if there are post-deploy hooks.
+	Sync bool
+
+	// Roll the service back to its previous spec when a post-deploy hook
+	// fails. A failed pre-deploy hook always aborts the update.
+	RollbackOnFailure bool
+
+	OnResult func(HookResult)
+	Logger   *logrus.Logger
+}
+
+// UpdateServiceWithHooks runs the pre-deploy hooks, updates the service,
+// then runs the post-deploy hooks. It returns the results of the hooks run.
+func UpdateServiceWithHooks(ctx context.Context, client QcosClient,
+	stackName, serviceName string, args UpdateServiceArgs, opts DeployHooksOpts) (results []HookResult, err error) {
+
+	if stackName == "" {
+		stackName = DefaultStack
+	}
+	log := loggerOf(client, opts.Logger).WithField("service", stackName+"/"+serviceName)
+	run := func(phase HookPhase, hooks []DeployHook) error {
+		for _, hook := range hooks {
+			rets, err := RunDeployHook(ctx, client, stackName, serviceName, hook)
+			for _, ret := range rets {
+				ret.Phase = phase
+				results = append(results, ret)
+				if opts.OnResult != nil {
+					opts.OnResult(ret)
+				}
+				if ret.Err != nil || ret.ExitCode != 0 {
+					log.Warnf("%s hook %s in %s failed, exit code %d: %v", phase, ret.Hook, ret.Container, ret.ExitCode, ret.Err)
+					return &HookError{Hook: ret.Hook, Phase: phase, Container: ret.Container, ExitCode: ret.ExitCode, Err: ret.Err}
+				}
+				log.Infof("%s hook %s in %s done in %v", phase, ret.Hook, ret.Container, ret.Duration)
+			}
+			if err != nil {
+				return &HookError{Hook: hook.Name, Phase: phase, Err: err}
+			}
+		}
+		return nil
+	}
+
+	if err = run(HookPreDeploy, opts.PreDeploy); err != nil {
+		return
+	}
+	prev, err := client.GetServiceExport(ctx, stackName, serviceName)
+	if err != nil {
+		return
+	}
+	sync := opts.Sync || len(opts.PostDeploy) > 0
+	if err = updateService(ctx, client, stackName, serviceName, args, sync); err != nil {
+		return
+	}
+	if err = run(HookPostDeploy, opts.PostDeploy); err != nil && opts.RollbackOnFailure {
+		log.Warnf("rolling back to the previous spec")
+		if rerr := restoreService(ctx, client, stackName, serviceName, prev, true); rerr != nil {
+			log.Errorf("roll back failed: %v", rerr)
+			err.(*HookError).RollbackErr = rerr
+		} else {
+			err.(*HookError).RolledBack = true
+		}
+	}
+	return
+}
+
+func updateService(ctx context.Context, client QcosClient,
+	stackName, serviceName string, args UpdateServiceArgs, sync bool) error {
+
+	if sync {
+		return client.SyncUpdateService(ctx, stackName, serviceName, args)
+	}
+	return client.UpdateService(ctx, stackName, serviceName, args)
+}
+
+// RunDeployHook runs a hook in the containers of the service it targets. It
+// stops at the first container where the hook fails.
+func RunDeployHook(ctx context.Context, client QcosClient,
+	stackName, serviceName string, hook DeployHook) (results []HookResult, err error) {
+
+	if stackName == "" {
+		stackName = DefaultStack
+	}
+	if hook.Timeout == 0 {
+		hook.Timeout = 60 * time.Second
+	}
+	ips := []string{hook.Container}
+	if hook.Container == "" {
+		if ips, err = runningContainers(ctx, client, stackName, serviceName); err != nil {
+			return
+		}
+		if len(ips) == 0 {
+			return nil, fmt.Errorf("%s/%s has no running container", stackName, serviceName)
+		}
+		if !hook.All {
+			ips = ips[:1]
+		}
+	}
+	for _, ip := range ips {
+		ret := HookResult{Hook: hook.Name, Container: ip}
+		start := time.Now()
+		stdout, stderr, code, e := client.RunCommand(ctx, ip, hook.Command, RunCommandOpts{Timeout: hook.Timeout})
+		if e == ErrOutputTruncated {
+			e = nil
+		}
+		ret.ExitCode, ret.Err = code, e
+		ret.Duration = time.Since(start)
+		ret.Stdout, ret.Stderr = string(stdout), string(stderr)
+		results = append(results, ret)
+		if ret.Err != nil || ret.ExitCode != 0 {
+			return
+		}
+	}
+	return
+}
+
+func runningContainers(ctx context.Context, client QcosClient, stackName, serviceName string) (ips []string, err error) {
+	svc, err := client.GetServiceInspect(ctx, stackName, serviceName)
+	if err != nil {
+		return
+	}
+	for _, ip := range svc.ContainerIPs {
+		var info ContainerInfo
+		if info, err = client.GetContainerInspect(ctx, ip); err != nil {
+			if isNotFound(err) {
+				err = nil
+				continue
+			}
+			return
+		}
+		if info.Status == StatusRunning {
+			ips = append(ips, ip)
+		}
+	}
+	return
+}
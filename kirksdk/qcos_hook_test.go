@@ -0,0 +1,112 @@
+package kirksdk
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newHookTestClient() *mockQcosClient {
+	client := newRolloutTestClient()
+	client.execHandler = func(ip string, command []string, stdin io.Reader, stdout, stderr io.Writer) int {
+		switch command[0] {
+		case "migrate":
+			fmt.Fprintln(stdout, "migrated")
+			return 0
+		case "check":
+			fmt.Fprintln(stderr, "unhealthy")
+			return 2
+		}
+		return 127
+	}
+	return client
+}
+
+func TestUpdateServiceWithHooks(t *testing.T) {
+	client := newHookTestClient()
+
+	results, err := UpdateServiceWithHooks(context.TODO(), client, "web", "nginx",
+		UpdateServiceArgs{Spec: ServiceSpec{Image: "nginx:1.11"}},
+		DeployHooksOpts{
+			PreDeploy:  []DeployHook{{Name: "migrate", Command: []string{"migrate", "up"}}},
+			PostDeploy: []DeployHook{{Name: "warmup", Command: []string{"migrate", "warmup"}, All: true}},
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"Exec 10.0.0.1 migrate up",
+		"UpdateService web/nginx",
+		"Exec 10.0.0.1 migrate warmup",
+		"Exec 10.0.0.2 migrate warmup",
+	}, client.calls)
+	assert.Len(t, results, 3)
+	assert.Equal(t, HookPreDeploy, results[0].Phase)
+	assert.Equal(t, "migrated\n", results[0].Stdout)
+	assert.Equal(t, "10.0.0.2", results[2].Container)
+}
+
+func TestUpdateServiceWithHooksFailed(t *testing.T) {
+	client := newHookTestClient()
+	_, err := UpdateServiceWithHooks(context.TODO(), client, "web", "nginx",
+		UpdateServiceArgs{Spec: ServiceSpec{Image: "nginx:1.11"}},
+		DeployHooksOpts{PreDeploy: []DeployHook{{Name: "check", Command: []string{"check"}}}})
+	assert.EqualError(t, err, "pre-deploy hook check in 10.0.0.1: exit code 2")
+	assert.Equal(t, []string{"Exec 10.0.0.1 check"}, client.calls)
+
+	client = newHookTestClient()
+	results, err := UpdateServiceWithHooks(context.TODO(), client, "web", "nginx",
+		UpdateServiceArgs{Spec: ServiceSpec{Image: "nginx:1.11"}},
+		DeployHooksOpts{
+			PostDeploy:        []DeployHook{{Name: "check", Command: []string{"check"}, All: true}},
+			RollbackOnFailure: true,
+		})
+	assert.EqualError(t, err, "post-deploy hook check in 10.0.0.1: exit code 2, rolled back")
+	assert.Equal(t, "unhealthy\n", results[0].Stderr)
+	assert.Equal(t, []string{
+		"UpdateService web/nginx",
+		"Exec 10.0.0.1 check",
+		"UpdateService web/nginx",
+	}, client.calls)
+	assert.Equal(t, "nginx:1.10", client.services["web/nginx"].Spec.Image)
+}
+
+func TestUpdateServiceWithHooksRollbackFailed(t *testing.T) {
+	client := newHookTestClient()
+	_, err := UpdateServiceWithHooks(context.TODO(), client, "web", "nginx",
+		UpdateServiceArgs{Spec: ServiceSpec{Image: "nginx:1.11", WorkDir: "/srv"}},
+		DeployHooksOpts{
+			PostDeploy:        []DeployHook{{Name: "check", Command: []string{"check"}}},
+			RollbackOnFailure: true,
+		})
+	assert.EqualError(t, err, "post-deploy hook check in 10.0.0.1: exit code 2 (rollback failed: web/nginx: spec.workDir could not be restored)")
+	assert.False(t, err.(*HookError).RolledBack)
+	assert.Equal(t, "nginx:1.10", client.services["web/nginx"].Spec.Image)
+}
+
+func TestRunDeployHookTimeout(t *testing.T) {
+	client := newHookTestClient()
+	block := make(chan struct{})
+	late := make(chan error, 1)
+	client.execHandler = func(ip string, command []string, stdin io.Reader, stdout, stderr io.Writer) int {
+		<-block
+		_, err := io.WriteString(stdout, "late")
+		late <- err
+		return 0
+	}
+	results, err := RunDeployHook(context.TODO(), client, "web", "nginx",
+		DeployHook{Name: "slow", Command: []string{"sleep", "60"}, Container: "10.0.0.2", Timeout: 10 * time.Millisecond})
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.True(t, strings.HasSuffix(results[0].Err.Error(), "context deadline exceeded"))
+
+	// the exec was torn down when the hook returned
+	assert.Contains(t, client.calls, "Detach 10.0.0.2 sleep 60")
+	close(block)
+	assert.Equal(t, io.ErrClosedPipe, <-late)
+	assert.Empty(t, results[0].Stdout)
+}
@@ -0,0 +1,354 @@
+package kirksdk
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrDuplicateKey       = errors.New("duplicate key")
+	ErrInvalidEnvName     = errors.New("invalid env name")
+	ErrInvalidHostname    = errors.New("invalid hostname")
+	ErrInvalidHostIP      = errors.New("invalid host ip")
+	ErrInvalidMetadataKey = errors.New("invalid metadata key")
+)
+
+var (
+	envNameRegexp     = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+	hostnameRegexp    = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*$`)
+	metadataKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+)
+
+// KeyValueError reports an invalid entry of an env, hosts or metadata list.
+type KeyValueError struct {
+	Index int
+	Entry string
+	Err   error
+}
+
+func (p *KeyValueError) Error() string {
+	return fmt.Sprintf("entry %d %q: %v", p.Index, p.Entry, p.Err)
+}
+
+type kvKind int
+
+const (
+	kvEnv kvKind = iota
+	kvHost
+	kvMetadata
+)
+
+// KeyValues is an ordered map backing the raw string lists of the API:
+// env vars (KEY=VALUE), hosts (hostname:ip) and metadata (key=value).
+// Keys keep the order in which they were first set.
+type KeyValues struct {
+	kind   kvKind
+	keys   []string
+	values map[string]string
+}
+
+func newKeyValues(kind kvKind) *KeyValues {
+	return &KeyValues{kind: kind, values: make(map[string]string)}
+}
+
+// NewEnvs returns an empty env var list, as used by ServiceSpec.Envs and
+// JobTaskSpec.Envs.
+func NewEnvs() *KeyValues {
+	return newKeyValues(kvEnv)
+}
+
+// NewHosts returns an empty hosts list, as used by ServiceSpec.Hosts and
+// JobTaskSpec.Hosts.
+func NewHosts() *KeyValues {
+	return newKeyValues(kvHost)
+}
+
+// NewMetadata returns an empty metadata list, as used by stacks, services
+// and jobs.
+func NewMetadata() *KeyValues {
+	return newKeyValues(kvMetadata)
+}
+
+// ParseEnvs parses KEY=VALUE entries, rejecting invalid names and
+// duplicate keys.
+func ParseEnvs(envs []string) (*KeyValues, error) {
+	return parseKeyValues(kvEnv, envs)
+}
+
+// ParseHosts parses hostname:ip entries, rejecting invalid hostnames, IPs
+// and duplicate hostnames.
+func ParseHosts(hosts []string) (*KeyValues, error) {
+	return parseKeyValues(kvHost, hosts)
+}
+
+// ParseMetadata parses key=value entries. An entry without '=' is a key with
+// an empty value.
+func ParseMetadata(metadata []string) (*KeyValues, error) {
+	return parseKeyValues(kvMetadata, metadata)
+}
+
+func parseKeyValues(kind kvKind, entries []string) (*KeyValues, error) {
+	ret := newKeyValues(kind)
+	for i, entry := range entries {
+		key, value, ok := ret.split(entry)
+		if !ok {
+			return nil, &KeyValueError{i, entry, ret.invalidKeyErr()}
+		}
+		if err := ret.validate(key, value); err != nil {
+			return nil, &KeyValueError{i, entry, err}
+		}
+		if _, dup := ret.values[key]; dup {
+			return nil, &KeyValueError{i, entry, ErrDuplicateKey}
+		}
+		ret.set(key, value)
+	}
+	return ret, nil
+}
+
+func (p *KeyValues) sep() string {
+	if p.kind == kvHost {
+		return ":"
+	}
+	return "="
+}
+
+func (p *KeyValues) split(entry string) (key, value string, ok bool) {
+	i := strings.Index(entry, p.sep())
+	if i < 0 {
+		return entry, "", p.kind == kvMetadata
+	}
+	return entry[:i], entry[i+1:], true
+}
+
+func (p *KeyValues) invalidKeyErr() error {
+	switch p.kind {
+	case kvHost:
+		return ErrInvalidHostname
+	case kvMetadata:
+		return ErrInvalidMetadataKey
+	}
+	return ErrInvalidEnvName
+}
+
+func (p *KeyValues) validate(key, value string) error {
+	switch p.kind {
+	case kvHost:
+		if !hostnameRegexp.MatchString(key) {
+			return ErrInvalidHostname
+		}
+		if net.ParseIP(value) == nil {
+			return ErrInvalidHostIP
+		}
+	case kvMetadata:
+		if !metadataKeyRegexp.MatchString(key) {
+			return ErrInvalidMetadataKey
+		}
+	default:
+		if !envNameRegexp.MatchString(key) {
+			return ErrInvalidEnvName
+		}
+	}
+	return nil
+}
+
+func (p *KeyValues) set(key, value string) {
+	if _, ok := p.values[key]; !ok {
+		p.keys = append(p.keys, key)
+	}
+	p.values[key] = value
+}
+
+// Set sets key to value, keeping the position of an existing key.
+func (p *KeyValues) Set(key, value string) error {
+	if err := p.validate(key, value); err != nil {
+		return err
+	}
+	p.set(key, value)
+	return nil
+}
+
+func (p *KeyValues) Get(key string) (value string, ok bool) {
+	value, ok = p.values[key]
+	return
+}
+
+func (p *KeyValues) Has(key string) bool {
+	_, ok := p.values[key]
+	return ok
+}
+
+func (p *KeyValues) Delete(key string) {
+	if _, ok := p.values[key]; !ok {
+		return
+	}
+	delete(p.values, key)
+	for i, k := range p.keys {
+		if k == key {
+			p.keys = append(p.keys[:i:i], p.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+func (p *KeyValues) Len() int {
+	return len(p.keys)
+}
+
+func (p *KeyValues) Keys() []string {
+	return append([]string(nil), p.keys...)
+}
+
+// Map returns the entries as an unordered map.
+func (p *KeyValues) Map() map[string]string {
+	ret := make(map[string]string, len(p.values))
+	for k, v := range p.values {
+		ret[k] = v
+	}
+	return ret
+}
+
+// Strings formats the entries back to the raw form used by the API.
+func (p *KeyValues) Strings() []string {
+	ret := make([]string, 0, len(p.keys))
+	for _, k := range p.keys {
+		v := p.values[k]
+		if p.kind == kvMetadata && v == "" {
+			ret = append(ret, k)
+			continue
+		}
+		ret = append(ret, k+p.sep()+v)
+	}
+	return ret
+}
+
+// Merge sets every entry of other into p, so that other wins on conflicting
+// keys. New keys are appended in the order of other.
+func (p *KeyValues) Merge(other *KeyValues) *KeyValues {
+	for _, k := range other.keys {
+		p.set(k, other.values[k])
+	}
+	return p
+}
+
+// MergeEnvs merges env lists, later lists overriding earlier ones.
+func MergeEnvs(base []string, overlays ...[]string) ([]string, error) {
+	return mergeKeyValues(kvEnv, base, overlays)
+}
+
+// MergeHosts merges hosts lists, later lists overriding earlier ones.
+func MergeHosts(base []string, overlays ...[]string) ([]string, error) {
+	return mergeKeyValues(kvHost, base, overlays)
+}
+
+// MergeMetadata merges metadata lists, later lists overriding earlier ones.
+func MergeMetadata(base []string, overlays ...[]string) ([]string, error) {
+	return mergeKeyValues(kvMetadata, base, overlays)
+}
+
+func mergeKeyValues(kind kvKind, base []string, overlays [][]string) ([]string, error) {
+	ret, err := parseKeyValues(kind, base)
+	if err != nil {
+		return nil, err
+	}
+	for _, overlay := range overlays {
+		kv, err := parseKeyValues(kind, overlay)
+		if err != nil {
+			return nil, err
+		}
+		ret.Merge(kv)
+	}
+	return ret.Strings(), nil
+}
+
+// ParseEnvFile reads env vars from r in the dotenv format: one KEY=VALUE
+// per line, blank lines and lines starting with '#' are ignored, an optional
+// "export " prefix is stripped, and values may be single or double quoted.
+func ParseEnvFile(r io.Reader) (*KeyValues, error) {
+	ret := NewEnvs()
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, &KeyValueError{lineno, line, ErrInvalidEnvName}
+		}
+		key := strings.TrimSpace(line[:i])
+		value, err := unquoteEnvValue(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return nil, &KeyValueError{lineno, line, err}
+		}
+		if ret.Has(key) {
+			return nil, &KeyValueError{lineno, line, ErrDuplicateKey}
+		}
+		if err = ret.Set(key, value); err != nil {
+			return nil, &KeyValueError{lineno, line, err}
+		}
+	}
+	return ret, scanner.Err()
+}
+
+// WriteEnvFile writes the entries in the format read by ParseEnvFile,
+// quoting values where needed.
+func (p *KeyValues) WriteEnvFile(w io.Writer) error {
+	for _, k := range p.keys {
+		if _, err := fmt.Fprintf(w, "%s=%s\n", k, QuoteEnvValue(p.values[k])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QuoteEnvValue double quotes v if it contains whitespace, quotes, '#' or
+// non printable characters.
+func QuoteEnvValue(v string) string {
+	if v == "" {
+		return v
+	}
+	for _, c := range v {
+		if c <= ' ' || c == '"' || c == '\'' || c == '#' || c == '\\' || c == '$' || c > '~' {
+			return strconv.Quote(v)
+		}
+	}
+	return v
+}
+
+func unquoteEnvValue(v string) (string, error) {
+	if len(v) >= 2 {
+		switch {
+		case v[0] == '"' && v[len(v)-1] == '"':
+			return strconv.Unquote(v)
+		case v[0] == '\'' && v[len(v)-1] == '\'':
+			return v[1 : len(v)-1], nil
+		}
+	}
+	if strings.HasPrefix(v, "\"") || strings.HasPrefix(v, "'") {
+		return "", errors.New("unterminated quote")
+	}
+	return v, nil
+}
+
+func envKey(s string) string {
+	if i := strings.Index(s, "="); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+func hostKey(s string) string {
+	if i := strings.Index(s, ":"); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
@@ -0,0 +1,76 @@
+package kirksdk
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEnvs(t *testing.T) {
+	envs, err := ParseEnvs([]string{"B=2", "A=x=y", "EMPTY="})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"B", "A", "EMPTY"}, envs.Keys())
+	v, ok := envs.Get("A")
+	assert.True(t, ok)
+	assert.Equal(t, "x=y", v)
+
+	envs.Set("B", "3")
+	envs.Delete("EMPTY")
+	assert.Equal(t, []string{"B=3", "A=x=y"}, envs.Strings())
+
+	_, err = ParseEnvs([]string{"A=1", "A=2"})
+	assert.Equal(t, ErrDuplicateKey, err.(*KeyValueError).Err)
+	assert.Equal(t, 1, err.(*KeyValueError).Index)
+
+	_, err = ParseEnvs([]string{"1A=1"})
+	assert.Equal(t, ErrInvalidEnvName, err.(*KeyValueError).Err)
+	_, err = ParseEnvs([]string{"NOVALUE"})
+	assert.Equal(t, ErrInvalidEnvName, err.(*KeyValueError).Err)
+}
+
+func TestParseHosts(t *testing.T) {
+	hosts, err := ParseHosts([]string{"earth:1.1.1.1", "mars.local:::1"})
+	assert.NoError(t, err)
+	ip, _ := hosts.Get("mars.local")
+	assert.Equal(t, "::1", ip)
+
+	_, err = ParseHosts([]string{"earth:1.1.1"})
+	assert.Equal(t, ErrInvalidHostIP, err.(*KeyValueError).Err)
+	_, err = ParseHosts([]string{"-earth:1.1.1.1"})
+	assert.Equal(t, ErrInvalidHostname, err.(*KeyValueError).Err)
+	assert.Equal(t, ErrInvalidHostIP, hosts.Set("venus", "nowhere"))
+}
+
+func TestMergeEnvs(t *testing.T) {
+	envs, err := MergeEnvs([]string{"A=1", "B=2"}, []string{"B=3", "C=4"}, []string{"A=5"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A=5", "B=3", "C=4"}, envs)
+
+	md, err := MergeMetadata([]string{"canary", "team=a"}, []string{"team=b"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"canary", "team=b"}, md)
+}
+
+func TestEnvFile(t *testing.T) {
+	envs, err := ParseEnvFile(strings.NewReader(`
+# comment
+export A=1
+B="hello world"
+C='single "quoted"'
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A=1", "B=hello world", `C=single "quoted"`}, envs.Strings())
+
+	var buf bytes.Buffer
+	assert.NoError(t, envs.WriteEnvFile(&buf))
+	assert.Equal(t, "A=1\nB=\"hello world\"\nC=\"single \\\"quoted\\\"\"\n", buf.String())
+
+	again, err := ParseEnvFile(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, envs.Strings(), again.Strings())
+
+	_, err = ParseEnvFile(strings.NewReader("A=\"oops\n"))
+	assert.Error(t, err)
+}
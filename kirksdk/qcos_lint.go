@@ -0,0 +1,343 @@
+package kirksdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type LintSeverity string
+
+const (
+	LintOff     = LintSeverity("off")
+	LintInfo    = LintSeverity("info")
+	LintWarning = LintSeverity("warning")
+	LintError   = LintSeverity("error")
+)
+
+// Names of the built-in lint rules.
+const (
+	LintRuleValidate          = "validate"
+	LintRuleNoLatestTag       = "no-latest-tag"
+	LintRuleStopGraceSec      = "stop-grace-sec"
+	LintRuleUpdateParallelism = "update-parallelism"
+	LintRuleLogCollectors     = "log-collectors"
+	LintRuleRequiredMetadata  = "required-metadata"
+	LintRuleStatefulVolumes   = "stateful-volumes"
+	LintRuleAutoRestart       = "auto-restart"
+)
+
+// Policies of ServiceSpec.AutoRestart known to the auto-restart rule. The
+// API does not list the accepted values: "always" is the one used in its
+// examples, the others are docker restart policies. An unknown policy is
+// thus only a warning. An empty value uses the default policy.
+var KnownAutoRestartPolicies = []string{"always", "on-failure", "never"}
+
+// LintManifest is the input of the lint rules. Exactly one of Stack and Job
+// is set.
+type LintManifest struct {
+	Stack *CreateStackArgs
+	Job   *CreateJobArgs
+}
+
+// LintPolicy configures a Linter.
+type LintPolicy struct {
+	// Metadata keys every stack, service and job must carry. Keys set on a
+	// stack also count for its services.
+	RequiredMetadata []string `json:"requiredMetadata"`
+
+	// Overrides the severity of rules by name, LintOff disables a rule.
+	Severities map[string]LintSeverity `json:"severities"`
+}
+
+// LintReportFunc reports a finding at the JSON path field of the manifest.
+type LintReportFunc func(field string, format string, args ...interface{})
+
+// LintRule is a named check. Check calls report once per finding.
+type LintRule struct {
+	Name     string
+	Severity LintSeverity
+	Check    func(m LintManifest, policy LintPolicy, report LintReportFunc)
+}
+
+type LintFinding struct {
+	Rule     string       `json:"rule"`
+	Severity LintSeverity `json:"severity"`
+	Field    string       `json:"field"`
+	Msg      string       `json:"msg"`
+}
+
+func (p LintFinding) String() string {
+	return fmt.Sprintf("%s: %s [%s] %s", p.Severity, p.Field, p.Rule, p.Msg)
+}
+
+type LintReport struct {
+	Name     string        `json:"name"`
+	Findings []LintFinding `json:"findings"`
+}
+
+// Count returns the number of findings of severity sev.
+func (p LintReport) Count(sev LintSeverity) (n int) {
+	for _, f := range p.Findings {
+		if f.Severity == sev {
+			n++
+		}
+	}
+	return
+}
+
+// HasErrors returns true if any finding has severity LintError, in which case
+// CI should fail.
+func (p LintReport) HasErrors() bool {
+	return p.Count(LintError) > 0
+}
+
+// WriteJSON writes the report as JSON, for consumption by CI.
+func (p LintReport) WriteJSON(w io.Writer) error {
+	if p.Findings == nil {
+		p.Findings = []LintFinding{}
+	}
+	return json.NewEncoder(w).Encode(p)
+}
+
+// WriteText writes one finding per line.
+func (p LintReport) WriteText(w io.Writer) error {
+	for _, f := range p.Findings {
+		if _, err := fmt.Fprintf(w, "%s: %s\n", p.Name, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Linter checks stack and job manifests against a set of rules.
+type Linter struct {
+	Policy LintPolicy
+	rules  []LintRule
+}
+
+// NewLinter returns a linter with the built-in rules.
+func NewLinter(policy LintPolicy) *Linter {
+	return &Linter{Policy: policy, rules: builtinLintRules()}
+}
+
+// Register adds a custom rule, replacing any rule of the same name.
+func (p *Linter) Register(rule LintRule) {
+	for i, r := range p.rules {
+		if r.Name == rule.Name {
+			p.rules[i] = rule
+			return
+		}
+	}
+	p.rules = append(p.rules, rule)
+}
+
+// Rules returns the names of the registered rules.
+func (p *Linter) Rules() (names []string) {
+	for _, r := range p.rules {
+		names = append(names, r.Name)
+	}
+	return
+}
+
+func (p *Linter) LintStack(args CreateStackArgs) LintReport {
+	return p.lint(args.Name, LintManifest{Stack: &args})
+}
+
+func (p *Linter) LintJob(args CreateJobArgs) LintReport {
+	return p.lint(args.Name, LintManifest{Job: &args})
+}
+
+func (p *Linter) lint(name string, m LintManifest) LintReport {
+	report := LintReport{Name: name}
+	for _, rule := range p.rules {
+		sev := rule.Severity
+		if s, ok := p.Policy.Severities[rule.Name]; ok {
+			sev = s
+		}
+		if sev == LintOff || rule.Check == nil {
+			continue
+		}
+		rule.Check(m, p.Policy, func(field string, format string, args ...interface{}) {
+			report.Findings = append(report.Findings, LintFinding{
+				Rule:     rule.Name,
+				Severity: sev,
+				Field:    field,
+				Msg:      fmt.Sprintf(format, args...),
+			})
+		})
+	}
+	return report
+}
+
+// ForEachService calls fn with every service of a stack manifest, and the
+// JSON path of the service to report findings at.
+func (p LintManifest) ForEachService(fn func(field string, svc CreateServiceArgs)) {
+	if p.Stack == nil {
+		return
+	}
+	for i, svc := range p.Stack.Services {
+		fn(indexPath("services", i), svc)
+	}
+}
+
+// ForEachTask calls fn with every task of a job manifest, in name order, and
+// the JSON path of the task to report findings at.
+func (p LintManifest) ForEachTask(fn func(field string, task JobTaskSpec)) {
+	if p.Job == nil {
+		return
+	}
+	for _, name := range sortedTaskNames(p.Job.Spec) {
+		fn("spec."+name, p.Job.Spec[name])
+	}
+}
+
+func builtinLintRules() []LintRule {
+	return []LintRule{
+		{LintRuleValidate, LintError, lintValidate},
+		{LintRuleNoLatestTag, LintError, lintNoLatestTag},
+		{LintRuleStopGraceSec, LintWarning, lintStopGraceSec},
+		{LintRuleUpdateParallelism, LintWarning, lintUpdateParallelism},
+		{LintRuleLogCollectors, LintWarning, lintLogCollectors},
+		{LintRuleRequiredMetadata, LintError, lintRequiredMetadata},
+		{LintRuleStatefulVolumes, LintError, lintStatefulVolumes},
+		{LintRuleAutoRestart, LintWarning, lintAutoRestart},
+	}
+}
+
+func lintValidate(m LintManifest, policy LintPolicy, report LintReportFunc) {
+	var err error
+	if m.Stack != nil {
+		err = m.Stack.Validate()
+	} else if m.Job != nil {
+		err = m.Job.Validate()
+	}
+	if errs, ok := err.(ValidationErrors); ok {
+		for _, e := range errs {
+			report(e.Field, "%s", e.Msg)
+		}
+	}
+}
+
+func lintNoLatestTag(m LintManifest, policy LintPolicy, report LintReportFunc) {
+	check := func(field, image string) {
+		if image == "" {
+			return
+		}
+		_, tag, digest := splitImageRef(image)
+		if digest == "" && (tag == "" || tag == "latest") {
+			report(field, "image %q should be pinned to a tag other than latest", image)
+		}
+	}
+	m.ForEachService(func(field string, svc CreateServiceArgs) {
+		check(fieldPath(field, "spec.image"), svc.Spec.Image)
+	})
+	m.ForEachTask(func(field string, task JobTaskSpec) {
+		check(fieldPath(field, "image"), task.Image)
+	})
+}
+
+func lintStopGraceSec(m LintManifest, policy LintPolicy, report LintReportFunc) {
+	m.ForEachService(func(field string, svc CreateServiceArgs) {
+		if svc.Spec.StopGraceSec == 0 {
+			report(fieldPath(field, "spec.stopGraceSec"), "stopGraceSec is not set")
+		}
+	})
+}
+
+func lintUpdateParallelism(m LintManifest, policy LintPolicy, report LintReportFunc) {
+	m.ForEachService(func(field string, svc CreateServiceArgs) {
+		if svc.InstanceNum > 1 && svc.UpdateParallelism >= svc.InstanceNum {
+			report(fieldPath(field, "updateParallelism"),
+				"updateParallelism %d updates all %d instances at once", svc.UpdateParallelism, svc.InstanceNum)
+		}
+	})
+}
+
+func lintLogCollectors(m LintManifest, policy LintPolicy, report LintReportFunc) {
+	m.ForEachService(func(field string, svc CreateServiceArgs) {
+		if len(svc.Spec.LogCollectors) == 0 {
+			report(fieldPath(field, "spec.logCollectors"), "no log collector configured")
+		}
+	})
+	m.ForEachTask(func(field string, task JobTaskSpec) {
+		if len(task.LogCollectors) == 0 {
+			report(fieldPath(field, "logCollectors"), "no log collector configured")
+		}
+	})
+}
+
+func lintRequiredMetadata(m LintManifest, policy LintPolicy, report LintReportFunc) {
+	if len(policy.RequiredMetadata) == 0 {
+		return
+	}
+	missing := func(metadata ...[]string) (keys []string) {
+		for _, key := range policy.RequiredMetadata {
+			found := false
+			for _, md := range metadata {
+				for _, entry := range md {
+					if envKey(entry) == key {
+						found = true
+					}
+				}
+			}
+			if !found {
+				keys = append(keys, key)
+			}
+		}
+		return
+	}
+	if m.Job != nil {
+		if keys := missing(m.Job.Metadata); len(keys) > 0 {
+			report("metadata", "missing required metadata %s", strings.Join(keys, ", "))
+		}
+		return
+	}
+	if m.Stack == nil {
+		return
+	}
+	// services inherit the metadata of the stack, which is checked alone
+	// when there is no service
+	if len(m.Stack.Services) == 0 {
+		if keys := missing(m.Stack.Metadata); len(keys) > 0 {
+			report("metadata", "missing required metadata %s", strings.Join(keys, ", "))
+		}
+		return
+	}
+	m.ForEachService(func(field string, svc CreateServiceArgs) {
+		if keys := missing(m.Stack.Metadata, svc.Metadata); len(keys) > 0 {
+			report(fieldPath(field, "metadata"), "missing required metadata %s", strings.Join(keys, ", "))
+		}
+	})
+}
+
+func lintStatefulVolumes(m LintManifest, policy LintPolicy, report LintReportFunc) {
+	m.ForEachService(func(field string, svc CreateServiceArgs) {
+		if svc.Stateful && len(svc.Volumes) == 0 {
+			report(fieldPath(field, "volumes"), "stateful service has no volume")
+		}
+	})
+}
+
+func lintAutoRestart(m LintManifest, policy LintPolicy, report LintReportFunc) {
+	m.ForEachService(func(field string, svc CreateServiceArgs) {
+		if svc.Spec.AutoRestart != "" && !containsString(KnownAutoRestartPolicies, svc.Spec.AutoRestart) {
+			report(fieldPath(field, "spec.autoRestart"), "unknown policy %q, known policies are %s",
+				svc.Spec.AutoRestart, strings.Join(KnownAutoRestartPolicies, ", "))
+		}
+	})
+}
+
+// splitImageRef splits an image reference like "host:5000/repo:tag@digest"
+// into its repository, tag and digest.
+func splitImageRef(image string) (repo, tag, digest string) {
+	repo = image
+	if i := strings.Index(repo, "@"); i >= 0 {
+		repo, digest = repo[:i], repo[i+1:]
+	}
+	if i := strings.LastIndex(repo, ":"); i >= 0 && !strings.Contains(repo[i+1:], "/") {
+		repo, tag = repo[:i], repo[i+1:]
+	}
+	return
+}
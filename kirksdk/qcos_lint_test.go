@@ -0,0 +1,120 @@
+package kirksdk
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitImageRef(t *testing.T) {
+	cases := []struct {
+		image, repo, tag, digest string
+	}{
+		{"nginx", "nginx", "", ""},
+		{"nginx:1.10", "nginx", "1.10", ""},
+		{"reg.qiniu.com:5000/team/app", "reg.qiniu.com:5000/team/app", "", ""},
+		{"reg.qiniu.com:5000/team/app:v1@sha256:abc", "reg.qiniu.com:5000/team/app", "v1", "sha256:abc"},
+	}
+	for _, c := range cases {
+		repo, tag, digest := splitImageRef(c.image)
+		assert.Equal(t, c.repo, repo, c.image)
+		assert.Equal(t, c.tag, tag, c.image)
+		assert.Equal(t, c.digest, digest, c.image)
+	}
+}
+
+func TestLintStack(t *testing.T) {
+	linter := NewLinter(LintPolicy{
+		RequiredMetadata: []string{"team", "owner"},
+		Severities:       map[string]LintSeverity{LintRuleLogCollectors: LintOff},
+	})
+	linter.Register(LintRule{
+		Name:     "no-root-workdir",
+		Severity: LintInfo,
+		Check: func(m LintManifest, policy LintPolicy, report LintReportFunc) {
+			m.ForEachService(func(field string, svc CreateServiceArgs) {
+				if svc.Spec.WorkDir == "/" {
+					report(fieldPath(field, "spec.workDir"), "workDir is /")
+				}
+			})
+		},
+	})
+
+	report := linter.LintStack(CreateStackArgs{
+		Name:     "web",
+		Metadata: []string{"team=payments"},
+		Services: []CreateServiceArgs{
+			{
+				Name:              "nginx",
+				InstanceNum:       2,
+				UpdateParallelism: 2,
+				Metadata:          []string{"owner=alice"},
+				Spec:              ServiceSpec{Image: "nginx:latest", StopGraceSec: 10, WorkDir: "/", AutoRestart: "allways"},
+			},
+			{
+				Name:     "db",
+				Stateful: true,
+				Spec:     ServiceSpec{Image: "mysql:5.7", StopGraceSec: 30},
+			},
+		},
+	})
+
+	var got []string
+	for _, f := range report.Findings {
+		got = append(got, string(f.Severity)+" "+f.Rule+" "+f.Field)
+	}
+	assert.Equal(t, []string{
+		"error no-latest-tag services[0].spec.image",
+		"warning update-parallelism services[0].updateParallelism",
+		"error required-metadata services[1].metadata",
+		"error stateful-volumes services[1].volumes",
+		"warning auto-restart services[0].spec.autoRestart",
+		"info no-root-workdir services[0].spec.workDir",
+	}, got)
+	assert.True(t, report.HasErrors())
+	assert.Equal(t, 2, report.Count(LintWarning))
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, report.WriteJSON(buf))
+	assert.True(t, strings.HasPrefix(buf.String(),
+		`{"name":"web","findings":[{"rule":"no-latest-tag","severity":"error","field":"services[0].spec.image",`))
+}
+
+func TestLintStackMetadata(t *testing.T) {
+	linter := NewLinter(LintPolicy{RequiredMetadata: []string{"team", "owner"}})
+	report := linter.LintStack(CreateStackArgs{Name: "web", Metadata: []string{"team=payments"}})
+	var got []LintFinding
+	for _, f := range report.Findings {
+		if f.Rule == LintRuleRequiredMetadata {
+			got = append(got, f)
+		}
+	}
+	assert.Equal(t, []LintFinding{
+		{LintRuleRequiredMetadata, LintError, "metadata", "missing required metadata owner"},
+	}, got)
+
+	report = linter.LintStack(CreateStackArgs{Name: "web", Metadata: []string{"team=payments", "owner=alice"}})
+	for _, f := range report.Findings {
+		assert.NotEqual(t, LintRuleRequiredMetadata, f.Rule)
+	}
+}
+
+func TestLintJob(t *testing.T) {
+	report := NewLinter(LintPolicy{}).LintJob(CreateJobArgs{
+		Name: "backup",
+		Spec: map[string]JobTaskSpec{
+			"dump": {Image: "mysql", Deps: []string{"upload"}},
+		},
+	})
+	assert.Equal(t, []LintFinding{
+		{LintRuleValidate, LintError, "spec.dump.deps[0]", `unknown task "upload"`},
+		{LintRuleNoLatestTag, LintError, "spec.dump.image", `image "mysql" should be pinned to a tag other than latest`},
+		{LintRuleLogCollectors, LintWarning, "spec.dump.logCollectors", "no log collector configured"},
+	}, report.Findings)
+
+	buf := new(bytes.Buffer)
+	NewLinter(LintPolicy{}).LintJob(CreateJobArgs{}).WriteText(buf)
+	assert.Equal(t, ": error: name [validate] is required\n: error: spec [validate] at least one task is required\n", buf.String())
+}
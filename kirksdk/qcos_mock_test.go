@@ -0,0 +1,763 @@
+package kirksdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"qiniupkg.com/x/rpc.v7"
+)
+
+var errMockNotFound = &rpc.ErrorInfo{Code: 404, Err: "not found"}
+
+// mockQcosClient is an in-memory QcosClient for testing helpers built on top
+// of the client interface. Methods that are not overridden panic.
+type mockQcosClient struct {
+	QcosClient
+
+	mu             sync.Mutex
+	calls          []string
+	stacks         map[string]CreateStackArgs
+	services       map[string]ServiceExportInfo // key: stack/service
+	serviceInfos   map[string]ServiceInfo       // key: stack/service
+	serviceAlerts  map[string][]ContainerAlertInfo
+	jobs           map[string]JobInfo
+	aps            map[string]FullApInfo
+	apAlerts       map[string][]ApAlertInfo
+	configServices map[string]ConfigServiceSpecInfo
+	containers     map[string]ContainerInfo
+	healthchecks   map[string]map[string]string // key: apid/port
+	accessLogs     []Hit
+	execs          map[string][]string // key: execID
+	execExits      map[string]int      // key: execID
+	errs           map[string]error    // key: recorded call, returned by the call
+
+	// execHandler runs the commands of execs, returning the exit code.
+	execHandler func(ip string, command []string, stdin io.Reader, stdout, stderr io.Writer) int
+}
+
+// mockAccountClient serves the quota and apps of an account, and a
+// mockQcosClient per app.
+type mockAccountClient struct {
+	AccountClient
+	quota   []QuotaItem
+	apps    []AppInfo
+	clients map[string]*mockQcosClient
+}
+
+func (p *mockAccountClient) GetConfig() (ret AccountConfig) {
+	return AccountConfig{Host: "mock"}
+}
+
+func (p *mockAccountClient) GetAppQuota(ctx context.Context, appURI string) (ret []QuotaItem, err error) {
+	return p.quota, nil
+}
+
+func (p *mockAccountClient) ListApps(ctx context.Context) (ret []AppInfo, err error) {
+	return p.apps, nil
+}
+
+func (p *mockAccountClient) GetQcosClient(ctx context.Context, appURI string) (client QcosClient, err error) {
+	c, ok := p.clients[appURI]
+	if !ok {
+		return nil, errMockNotFound
+	}
+	return c, nil
+}
+
+func newMockQcosClient() *mockQcosClient {
+	return &mockQcosClient{
+		stacks:         make(map[string]CreateStackArgs),
+		services:       make(map[string]ServiceExportInfo),
+		serviceInfos:   make(map[string]ServiceInfo),
+		serviceAlerts:  make(map[string][]ContainerAlertInfo),
+		jobs:           make(map[string]JobInfo),
+		aps:            make(map[string]FullApInfo),
+		apAlerts:       make(map[string][]ApAlertInfo),
+		configServices: make(map[string]ConfigServiceSpecInfo),
+		containers:     make(map[string]ContainerInfo),
+		healthchecks:   make(map[string]map[string]string),
+		execs:          make(map[string][]string),
+		execExits:      make(map[string]int),
+		errs:           make(map[string]error),
+	}
+}
+
+func (p *mockQcosClient) record(call string) {
+	p.calls = append(p.calls, call)
+}
+
+func (p *mockQcosClient) GetConfig() (ret QcosConfig) {
+	return QcosConfig{Host: "mock"}
+}
+
+func (p *mockQcosClient) ListStacks(ctx context.Context) (ret []StackInfo, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for name, stack := range p.stacks {
+		info := StackInfo{Name: name, Metadata: stack.Metadata}
+		for _, svc := range stack.Services {
+			info.Services = append(info.Services, svc.Name)
+		}
+		ret = append(ret, info)
+	}
+	return
+}
+
+func (p *mockQcosClient) GetStack(ctx context.Context, stackName string) (ret StackInfo, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stack, ok := p.stacks[stackName]
+	if !ok {
+		return ret, errMockNotFound
+	}
+	return StackInfo{Name: stackName, Metadata: stack.Metadata, Status: StatusRunning, IsDeployed: true}, nil
+}
+
+func (p *mockQcosClient) GetStackExport(ctx context.Context, stackName string) (ret CreateStackArgs, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stack, ok := p.stacks[stackName]
+	if !ok {
+		return ret, errMockNotFound
+	}
+	return stack, nil
+}
+
+func (p *mockQcosClient) CreateStack(ctx context.Context, args CreateStackArgs) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.record("CreateStack " + args.Name)
+	p.stacks[args.Name] = args
+	for _, svc := range args.Services {
+		p.services[args.Name+"/"+svc.Name] = ServiceExportInfo{
+			InstanceNum:       svc.InstanceNum,
+			UpdateParallelism: svc.UpdateParallelism,
+			Metadata:          svc.Metadata,
+			Name:              svc.Name,
+			Spec:              serviceSpecToExport(svc.Spec),
+			Stateful:          svc.Stateful,
+			Volumes:           svc.Volumes,
+		}
+	}
+	return
+}
+
+func (p *mockQcosClient) SyncCreateStack(ctx context.Context, args CreateStackArgs) (err error) {
+	return p.CreateStack(ctx, args)
+}
+
+func (p *mockQcosClient) UpdateStack(ctx context.Context, stackName string, args UpdateStackArgs) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.record("UpdateStack " + stackName)
+	stack := p.stacks[stackName]
+	stack.Metadata = args.Metadata
+	p.stacks[stackName] = stack
+	return
+}
+
+func (p *mockQcosClient) ListServices(ctx context.Context, stackName string) (ret []ServiceInfo, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, svc := range p.stacks[stackName].Services {
+		info := p.serviceInfos[stackName+"/"+svc.Name]
+		info.Name = svc.Name
+		info.Stack = stackName
+		info.Metadata = p.services[stackName+"/"+svc.Name].Metadata
+		ret = append(ret, info)
+	}
+	return
+}
+
+func (p *mockQcosClient) GetServiceInspect(ctx context.Context, stackName string, serviceName string) (ret ServiceInfo, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	svc, ok := p.services[stackName+"/"+serviceName]
+	if !ok {
+		return ret, errMockNotFound
+	}
+	ret = p.serviceInfos[stackName+"/"+serviceName]
+	ret.Name = serviceName
+	ret.Stack = stackName
+	ret.InstanceNum = svc.InstanceNum
+	ret.Metadata = svc.Metadata
+	ret.Spec = svc.Spec
+	return
+}
+
+func (p *mockQcosClient) GetServiceExport(ctx context.Context, stackName string, serviceName string) (ret ServiceExportInfo, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	svc, ok := p.services[stackName+"/"+serviceName]
+	if !ok {
+		return ret, errMockNotFound
+	}
+	return svc, nil
+}
+
+func (p *mockQcosClient) CreateService(ctx context.Context, stackName string, args CreateServiceArgs) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.record("CreateService " + stackName + "/" + args.Name)
+	stack := p.stacks[stackName]
+	stack.Services = append(stack.Services, args)
+	p.stacks[stackName] = stack
+	p.services[stackName+"/"+args.Name] = ServiceExportInfo{
+		InstanceNum:       args.InstanceNum,
+		UpdateParallelism: args.UpdateParallelism,
+		Metadata:          args.Metadata,
+		Name:              args.Name,
+		Spec:              serviceSpecToExport(args.Spec),
+		Stateful:          args.Stateful,
+		Volumes:           args.Volumes,
+	}
+	if _, ok := p.serviceInfos[stackName+"/"+args.Name]; !ok {
+		p.serviceInfos[stackName+"/"+args.Name] = ServiceInfo{
+			Stack:  stackName,
+			Name:   args.Name,
+			State:  StateDeployed,
+			Status: StatusRunning,
+		}
+	}
+	return
+}
+
+func (p *mockQcosClient) SyncCreateService(ctx context.Context, stackName string, args CreateServiceArgs) (err error) {
+	return p.CreateService(ctx, stackName, args)
+}
+
+func (p *mockQcosClient) DeleteService(ctx context.Context, stackName string, serviceName string) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	call := "DeleteService " + stackName + "/" + serviceName
+	p.record(call)
+	if err = p.errs[call]; err != nil {
+		return
+	}
+	if _, ok := p.services[stackName+"/"+serviceName]; !ok {
+		return errMockNotFound
+	}
+	delete(p.services, stackName+"/"+serviceName)
+	delete(p.serviceInfos, stackName+"/"+serviceName)
+	stack := p.stacks[stackName]
+	services := stack.Services[:0:0]
+	for _, svc := range stack.Services {
+		if svc.Name != serviceName {
+			services = append(services, svc)
+		}
+	}
+	stack.Services = services
+	p.stacks[stackName] = stack
+	return
+}
+
+func (p *mockQcosClient) UpdateService(ctx context.Context, stackName string, serviceName string, args UpdateServiceArgs) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.record("UpdateService " + stackName + "/" + serviceName)
+	svc := p.services[stackName+"/"+serviceName]
+	svc.Metadata = args.Metadata
+	svc.UpdateParallelism = args.UpdateParallelism
+	// like the API, the fields omitted from the spec are left unchanged
+	spec := svc.Spec.ToServiceSpec()
+	b, _ := json.Marshal(args.Spec)
+	json.Unmarshal(b, &spec)
+	svc.Spec = serviceSpecToExport(spec)
+	p.services[stackName+"/"+serviceName] = svc
+	info := p.serviceInfos[stackName+"/"+serviceName]
+	if args.ManualUpdate {
+		info.State = StateManualUpdating
+	} else {
+		info.State = StateDeployed
+	}
+	info.UpdateProgress, info.UpdatingProgress = 0, 0
+	info.Revision++
+	p.serviceInfos[stackName+"/"+serviceName] = info
+	return
+}
+
+// SyncUpdateService is UpdateService, the update being immediate.
+func (p *mockQcosClient) SyncUpdateService(ctx context.Context, stackName string, serviceName string, args UpdateServiceArgs) (err error) {
+	return p.UpdateService(ctx, stackName, serviceName, args)
+}
+
+// DeployService moves the manual update forward immediately: "CONTINUE n"
+// updates n more instances, COMPLETE and ROLLBACK end the update.
+func (p *mockQcosClient) DeployService(ctx context.Context, stackName string, serviceName string, args DeployServiceArgs) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	call := "DeployService " + stackName + "/" + serviceName + " " + args.Operation
+	p.record(call)
+	if err = p.errs[call]; err != nil {
+		return
+	}
+	info := p.serviceInfos[stackName+"/"+serviceName]
+	var n int
+	if _, err := fmt.Sscanf(args.Operation, "CONTINUE %d", &n); err == nil {
+		info.UpdateProgress += n
+		if max := p.services[stackName+"/"+serviceName].InstanceNum; info.UpdateProgress > max {
+			info.UpdateProgress = max
+		}
+	} else {
+		info.State = StateDeployed
+		info.UpdateProgress, info.UpdatingProgress = 0, 0
+	}
+	p.serviceInfos[stackName+"/"+serviceName] = info
+	return
+}
+
+func (p *mockQcosClient) ScaleService(ctx context.Context, stackName string, serviceName string, args ScaleServiceArgs) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.record("ScaleService " + stackName + "/" + serviceName)
+	svc := p.services[stackName+"/"+serviceName]
+	svc.InstanceNum = args.InstanceNum
+	p.services[stackName+"/"+serviceName] = svc
+	return
+}
+
+func (p *mockQcosClient) SyncScaleService(ctx context.Context, stackName string, serviceName string, args ScaleServiceArgs) (err error) {
+	return p.ScaleService(ctx, stackName, serviceName, args)
+}
+
+func (p *mockQcosClient) SyncStartService(ctx context.Context, stackName string, serviceName string) (err error) {
+	return p.setServiceStatus("SyncStartService", stackName, serviceName, StatusRunning)
+}
+
+func (p *mockQcosClient) SyncStopService(ctx context.Context, stackName string, serviceName string) (err error) {
+	return p.setServiceStatus("SyncStopService", stackName, serviceName, StatusNotRunning)
+}
+
+func (p *mockQcosClient) setServiceStatus(method, stackName, serviceName string, status Status) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	call := method + " " + stackName + "/" + serviceName
+	p.record(call)
+	if err = p.errs[call]; err != nil {
+		return
+	}
+	info := p.serviceInfos[stackName+"/"+serviceName]
+	info.Status = status
+	p.serviceInfos[stackName+"/"+serviceName] = info
+	return
+}
+
+func (p *mockQcosClient) GetServiceAlert(ctx context.Context, stack, service string, level string) (ret []ContainerAlertInfo, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	alerts, ok := p.serviceAlerts[stack+"/"+service]
+	if !ok {
+		return nil, errMockNotFound
+	}
+	return alerts, nil
+}
+
+func (p *mockQcosClient) UpdateServiceAlert(ctx context.Context, stack, service string, args UpdateContainerAlertArgs) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.record("UpdateServiceAlert " + stack + "/" + service)
+	p.serviceAlerts[stack+"/"+service] = append(p.serviceAlerts[stack+"/"+service], ContainerAlertInfo(args))
+	return
+}
+
+func (p *mockQcosClient) GetContainerAlert(ctx context.Context, ip string, level string) (ret []ContainerAlertInfo, err error) {
+	return nil, errMockNotFound
+}
+
+func (p *mockQcosClient) ListContainers(ctx context.Context, args ListContainersArgs) (ret []string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, info := range p.serviceInfos {
+		if args.StackName != "" && info.Stack != args.StackName {
+			continue
+		}
+		if args.ServiceName != "" && info.Name != args.ServiceName {
+			continue
+		}
+		ret = append(ret, info.ContainerIPs...)
+	}
+	return
+}
+
+func (p *mockQcosClient) GetContainerInspect(ctx context.Context, ip string) (ret ContainerInfo, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	info, ok := p.containers[ip]
+	if !ok {
+		return ret, errMockNotFound
+	}
+	return info, nil
+}
+
+// RestartContainer restarts the container immediately.
+func (p *mockQcosClient) RestartContainer(ctx context.Context, ip string) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.record("RestartContainer " + ip)
+	info, ok := p.containers[ip]
+	if !ok {
+		return errMockNotFound
+	}
+	info.StartedAt = info.StartedAt.Add(time.Second)
+	p.containers[ip] = info
+	return
+}
+
+func (p *mockQcosClient) ExecContainer(ctx context.Context, ip string, args ExecContainerArgs) (ret ExecContainerRet, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.containers[ip]; !ok {
+		return ret, errMockNotFound
+	}
+	ret.ExecID = fmt.Sprintf("exec%d", len(p.execs)+1)
+	p.execs[ret.ExecID] = args.Command
+	return
+}
+
+// StartContainerExec runs the exec with execHandler, until it returns or
+// ExitCh is closed.
+func (p *mockQcosClient) StartContainerExec(ctx context.Context, ip string, execID string, args StartContainerExecArgs, opts StartContainerExecOpts) (err error) {
+	p.mu.Lock()
+	command, ok := p.execs[execID]
+	handler := p.execHandler
+	p.record("Exec " + ip + " " + strings.Join(command, " "))
+	p.mu.Unlock()
+	if !ok {
+		return ErrNoSuchExec
+	}
+	if opts.ReadyCh != nil {
+		opts.ReadyCh <- struct{}{}
+		<-opts.ReadyCh
+	}
+	if opts.InStream != nil {
+		in := &execInput{r: opts.InStream}
+		defer in.stop()
+		opts.InStream = in
+	}
+	if opts.Recorder != nil {
+		defer opts.Recorder.stop()
+		opts = opts.Recorder.record(ip, opts)
+	}
+	// like the connection of a real exec, the outputs are closed when
+	// detaching
+	stdout := &mockExecOutput{w: opts.OutStream}
+	stderr := &mockExecOutput{w: opts.ErrStream}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		code := handler(ip, command, opts.InStream, stdout, stderr)
+		p.mu.Lock()
+		p.execExits[execID] = code
+		p.mu.Unlock()
+	}()
+	select {
+	case <-done:
+		return
+	case <-opts.ExitCh:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	stdout.close()
+	stderr.close()
+	p.mu.Lock()
+	p.record("Detach " + ip + " " + strings.Join(command, " "))
+	p.mu.Unlock()
+	return
+}
+
+type mockExecOutput struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closed bool
+}
+
+func (p *mockExecOutput) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if p.w == nil {
+		return len(b), nil
+	}
+	return p.w.Write(b)
+}
+
+func (p *mockExecOutput) close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+}
+
+func (p *mockQcosClient) ResizeContainerExecTerm(ctx context.Context, ip string, execID string, args ResizeContainerExecTermArgs) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.record(fmt.Sprintf("ResizeContainerExecTerm %s %s %dx%d", ip, execID, args.Width, args.Height))
+	return
+}
+
+func (p *mockQcosClient) InspectContainerExec(ctx context.Context, ip string, execID string) (ret ExecInspectInfo, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.execs[execID]; !ok {
+		return ret, ErrNoSuchExec
+	}
+	code, ok := p.execExits[execID]
+	return ExecInspectInfo{ExecID: execID, Running: !ok, ExitCode: code}, nil
+}
+
+func (p *mockQcosClient) RunCommand(ctx context.Context, ip string, cmd []string, opts RunCommandOpts) (stdout, stderr []byte, exitCode int, err error) {
+	return runCommand(ctx, p, ip, cmd, opts)
+}
+
+func (p *mockQcosClient) ListJobs(ctx context.Context) (ret []JobInfo, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, job := range p.jobs {
+		ret = append(ret, job)
+	}
+	return
+}
+
+func (p *mockQcosClient) GetJob(ctx context.Context, name string) (ret JobInfo, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	job, ok := p.jobs[name]
+	if !ok {
+		return ret, errMockNotFound
+	}
+	return job, nil
+}
+
+func (p *mockQcosClient) CreateJob(ctx context.Context, args CreateJobArgs) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.record("CreateJob " + args.Name)
+	p.jobs[args.Name] = JobInfo{
+		Name:     args.Name,
+		Spec:     args.Spec,
+		Mode:     args.Mode,
+		Metadata: args.Metadata,
+		RunAt:    args.RunAt,
+		Timeout:  args.Timeout,
+	}
+	return
+}
+
+func (p *mockQcosClient) UpdateJob(ctx context.Context, name string, args UpdateJobArgs) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.record("UpdateJob " + name)
+	job := p.jobs[name]
+	job.Spec = args.Spec
+	job.Metadata = args.Metadata
+	p.jobs[name] = job
+	return
+}
+
+func (p *mockQcosClient) ListAps(ctx context.Context, args ListApsArgs) (ret []ListApInfo, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for apid, ap := range p.aps {
+		if args.Title != "" && ap.Title != args.Title {
+			continue
+		}
+		if args.Service != "" && !mockApHasBackend(ap, args.Stack, args.Service) {
+			continue
+		}
+		ret = append(ret, ListApInfo{ApID: apid, Type: ap.Type, Title: ap.Title})
+	}
+	return
+}
+
+func (p *mockQcosClient) GetAp(ctx context.Context, apid string) (ret FullApInfo, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ap, ok := p.aps[apid]
+	if !ok {
+		return ret, errMockNotFound
+	}
+	return ap, nil
+}
+
+func (p *mockQcosClient) CreateAp(ctx context.Context, args CreateApArgs) (ret ListApInfo, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.record("CreateAp " + args.Title)
+	apid := "new-" + args.Title
+	p.aps[apid] = FullApInfo{Type: args.Type, Title: args.Title, Provider: args.Provider}
+	return ListApInfo{ApID: apid, Type: args.Type, Title: args.Title}, nil
+}
+
+func (p *mockQcosClient) UpdateAp(ctx context.Context, apid string, args SetApDescArgs) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.record("UpdateAp " + apid)
+	ap := p.aps[apid]
+	ap.Title = args.Title
+	p.aps[apid] = ap
+	return
+}
+
+// PublishUserDomain fails for a domain already bound to the AP, as the API
+// does.
+func (p *mockQcosClient) PublishUserDomain(ctx context.Context, apid string, args SetUserDomainArgs) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.record("PublishUserDomain " + apid + " " + args.UserDomain)
+	ap := p.aps[apid]
+	if containsString(ap.UserDomains, args.UserDomain) {
+		return fmt.Errorf("domain %s already published", args.UserDomain)
+	}
+	ap.UserDomains = append(ap.UserDomains, args.UserDomain)
+	p.aps[apid] = ap
+	return
+}
+
+// SetApPort replaces the port, dropping its container options, as the API
+// does.
+func (p *mockQcosClient) SetApPort(ctx context.Context, apid string, port string, args SetApPortArgs) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.record("SetApPort " + apid + " " + port)
+	ap := p.aps[apid]
+	info := ApPortInfo{FPort: port, BPort: fmt.Sprint(args.BackendPort), Proto: args.Proto, Enabled: true}
+	for _, b := range args.Backends {
+		info.Backends = append(info.Backends, apBackend(b.Stack, b.Service, b.Weight))
+	}
+	replaced := false
+	for i := range ap.Ports {
+		if ap.Ports[i].FPort == port {
+			ap.Ports[i] = info
+			replaced = true
+		}
+	}
+	if !replaced {
+		ap.Ports = append(ap.Ports, info)
+	}
+	p.aps[apid] = ap
+	return
+}
+
+func (p *mockQcosClient) ApSetContainer(ctx context.Context, apid string, port string, args []SetApContainerOptionsArgs) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.record("ApSetContainer " + apid + " " + port)
+	ap := p.aps[apid]
+	for i := range ap.Ports {
+		if ap.Ports[i].FPort != port {
+			continue
+		}
+		ap.Ports[i].ContainerOptions = nil
+		for _, opt := range args {
+			ap.Ports[i].ContainerOptions = append(ap.Ports[i].ContainerOptions, apContainerOption(opt.IP, opt.Ratio))
+		}
+	}
+	p.aps[apid] = ap
+	return
+}
+
+func (p *mockQcosClient) GetHealthcheck(ctx context.Context, apid string, port string) (ret map[string]string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ret, ok := p.healthchecks[apid+"/"+port]
+	if !ok {
+		return nil, errMockNotFound
+	}
+	return ret, nil
+}
+
+func (p *mockQcosClient) SearchContainerLogs(ctx context.Context, args SearchContainerLogsArgs) (res LogsSearchResult, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.record("SearchContainerLogs " + args.RepoType + " " + args.Query)
+	res.Data = p.accessLogs
+	if args.Size > 0 && len(res.Data) > args.Size {
+		res.Data = res.Data[:args.Size]
+	}
+	res.Total = len(res.Data)
+	return
+}
+
+func (p *mockQcosClient) GetApAlert(ctx context.Context, apid string, level string) (ret []ApAlertInfo, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	alerts, ok := p.apAlerts[apid]
+	if !ok {
+		return nil, errMockNotFound
+	}
+	return alerts, nil
+}
+
+func (p *mockQcosClient) UpdateApAlert(ctx context.Context, apid string, args UpdateApAlertArgs) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.record("UpdateApAlert " + apid)
+	p.apAlerts[apid] = append(p.apAlerts[apid], ApAlertInfo(args))
+	return
+}
+
+func (p *mockQcosClient) ListConfigServiceSpecs(ctx context.Context) (ret []ConfigServiceSpecInfo, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, spec := range p.configServices {
+		ret = append(ret, spec)
+	}
+	return
+}
+
+func (p *mockQcosClient) GetConfigServiceSpec(ctx context.Context, namespace string) (ret ConfigServiceSpecInfo, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	spec, ok := p.configServices[namespace]
+	if !ok {
+		return ret, errMockNotFound
+	}
+	return spec, nil
+}
+
+func (p *mockQcosClient) CreateConfigServiceSpec(ctx context.Context, args CreateConfigServiceSpecArgs) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.record("CreateConfigServiceSpec " + args.Namespace)
+	p.configServices[args.Namespace] = ConfigServiceSpecInfo(args)
+	return
+}
+
+func apBackend(stack, service string, weight int) (ret struct {
+	Stack         string `json:"stack"`
+	Service       string `json:"service"`
+	DefaultWeight int    `json:"weight"`
+	ActualWeight  int    `json:"actualWeight"`
+}) {
+	ret.Stack = stack
+	ret.Service = service
+	ret.DefaultWeight = weight
+	ret.ActualWeight = weight
+	return
+}
+
+func apContainerOption(ip string, ratio float64) (ret struct {
+	CotainerIP string  `json:"ip"`
+	Ratio      float64 `json:"ratio"`
+}) {
+	ret.CotainerIP = ip
+	ret.Ratio = ratio
+	return
+}
+
+func mockApHasBackend(ap FullApInfo, stack, service string) bool {
+	for _, port := range ap.Ports {
+		for _, b := range port.Backends {
+			if b.Stack == stack && b.Service == service {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,234 @@
+package kirksdk
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// ApplyStack creates the stack of the manifest if it does not exist.
+// Otherwise it updates the stack metadata, updates and scales the existing
+// services, and creates the missing ones. Services missing from the
+// manifest are left alone.
+func ApplyStack(ctx context.Context, client QcosClient, args CreateStackArgs, sync bool) (err error) {
+	if args.Name == "" {
+		args.Name = DefaultStack
+	}
+	_, err = client.GetStack(ctx, args.Name)
+	if isNotFound(err) {
+		if sync {
+			return client.SyncCreateStack(ctx, args)
+		}
+		return client.CreateStack(ctx, args)
+	}
+	if err != nil {
+		return
+	}
+	if err = client.UpdateStack(ctx, args.Name, UpdateStackArgs{Metadata: args.Metadata}); err != nil {
+		return
+	}
+	for _, svc := range args.Services {
+		var info ServiceInfo
+		info, err = client.GetServiceInspect(ctx, args.Name, svc.Name)
+		if isNotFound(err) {
+			if sync {
+				err = client.SyncCreateService(ctx, args.Name, svc)
+			} else {
+				err = client.CreateService(ctx, args.Name, svc)
+			}
+			if err != nil {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			return
+		}
+		update := UpdateServiceArgs{
+			Metadata:          svc.Metadata,
+			Spec:              svc.Spec,
+			UpdateParallelism: svc.UpdateParallelism,
+		}
+		if err = updateService(ctx, client, args.Name, svc.Name, update, sync); err != nil {
+			return
+		}
+		if svc.InstanceNum > 0 && svc.InstanceNum != info.InstanceNum {
+			scale := ScaleServiceArgs{InstanceNum: svc.InstanceNum}
+			if sync {
+				err = client.SyncScaleService(ctx, args.Name, svc.Name, scale)
+			} else {
+				err = client.ScaleService(ctx, args.Name, svc.Name, scale)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	return nil
+}
+
+type RegionDeployStatus string
+
+const (
+	RegionDeploySucceeded = RegionDeployStatus("SUCCEEDED")
+	RegionDeployFailed    = RegionDeployStatus("FAILED")
+	RegionDeploySkipped   = RegionDeployStatus("SKIPPED")
+)
+
+type RegionDeployResult struct {
+	App      string             `json:"app"`
+	Region   string             `json:"region"`
+	Wave     int                `json:"wave"`
+	Status   RegionDeployStatus `json:"status"`
+	Duration time.Duration      `json:"duration"`
+	Err      error              `json:"-"`
+}
+
+type RegionDeployResults []RegionDeployResult
+
+func (p RegionDeployResults) Failed() (ret RegionDeployResults) {
+	for _, r := range p {
+		if r.Status == RegionDeployFailed {
+			ret = append(ret, r)
+		}
+	}
+	return
+}
+
+// WriteText writes a line per app.
+func (p RegionDeployResults) WriteText(w io.Writer) error {
+	for _, r := range p {
+		line := fmt.Sprintf("%s (%s) wave %d: %s", r.App, r.Region, r.Wave, r.Status)
+		if r.Status != RegionDeploySkipped {
+			line += fmt.Sprintf(" in %v", r.Duration)
+		}
+		if r.Err != nil {
+			line += ": " + r.Err.Error()
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type MultiRegionDeployOpts struct {
+	// App URIs to deploy to, the canary apps first.
+	Apps []string
+
+	// Number of apps deployed in the first wave, before the others are
+	// deployed in parallel. Default 1, negative for no canary wave.
+	Canary int
+
+	// Maximal number of apps deployed at once. Default 4.
+	Concurrency int
+
+	// Deploys to an app. Default ApplyStack of the manifest, waiting for
+	// the services to run.
+	Deploy func(ctx context.Context, client QcosClient, app string) error
+
+	Logger *logrus.Logger
+}
+
+// MultiRegionDeploy deploys the manifest to several apps in waves, using
+// the QcosClient of each app from account. No app is deployed after a
+// failure, the deployments in progress run to their end. The returned
+// results are in the order of the apps.
+func MultiRegionDeploy(ctx context.Context, account AccountClient,
+	manifest CreateStackArgs, opts MultiRegionDeployOpts) (ret RegionDeployResults, err error) {
+
+	if opts.Canary == 0 {
+		opts.Canary = 1
+	} else if opts.Canary < 0 {
+		opts.Canary = 0
+	}
+	if opts.Canary > len(opts.Apps) {
+		opts.Canary = len(opts.Apps)
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+	if opts.Deploy == nil {
+		opts.Deploy = func(ctx context.Context, client QcosClient, app string) error {
+			return ApplyStack(ctx, client, manifest, true)
+		}
+	}
+	log := firstLogger(opts.Logger, account.GetConfig().Logger)
+
+	apps, err := account.ListApps(ctx)
+	if err != nil {
+		return
+	}
+	regions := make(map[string]string)
+	for _, app := range apps {
+		regions[app.URI] = app.Region
+	}
+	ret = make(RegionDeployResults, len(opts.Apps))
+	for i, app := range opts.Apps {
+		ret[i] = RegionDeployResult{App: app, Region: regions[app], Wave: 1, Status: RegionDeploySkipped}
+		if opts.Canary > 0 && i >= opts.Canary {
+			ret[i].Wave = 2
+		}
+	}
+
+	var (
+		mu     sync.Mutex
+		failed error
+		wg     sync.WaitGroup
+	)
+	sem := make(chan struct{}, opts.Concurrency)
+	deploy := func(r *RegionDeployResult) {
+		defer func() { <-sem; wg.Done() }()
+		start := time.Now()
+		entry := log.WithFields(logrus.Fields{"app": r.App, "region": r.Region, "wave": r.Wave})
+		entry.Info("deploying")
+		err := func() error {
+			client, err := account.GetQcosClient(ctx, r.App)
+			if err != nil {
+				return err
+			}
+			return opts.Deploy(ctx, client, r.App)
+		}()
+		r.Duration = time.Since(start)
+		if err != nil {
+			entry.Errorf("deploy failed: %v", err)
+			r.Status, r.Err = RegionDeployFailed, err
+			mu.Lock()
+			if failed == nil {
+				failed = fmt.Errorf("deploy to %s failed: %v", r.App, err)
+			}
+			mu.Unlock()
+			return
+		}
+		entry.Infof("deployed in %v", r.Duration)
+		r.Status = RegionDeploySucceeded
+	}
+	run := func(results []RegionDeployResult) {
+		for i := range results {
+			sem <- struct{}{}
+			mu.Lock()
+			stop := failed != nil
+			mu.Unlock()
+			if stop || ctx.Err() != nil {
+				<-sem
+				break
+			}
+			wg.Add(1)
+			go deploy(&results[i])
+		}
+		wg.Wait()
+	}
+
+	run(ret[:opts.Canary])
+	if failed == nil {
+		run(ret[opts.Canary:])
+	}
+	if failed != nil {
+		return ret, failed
+	}
+	return ret, ctx.Err()
+}
@@ -0,0 +1,113 @@
+package kirksdk
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newMultiRegionTestAccount() *mockAccountClient {
+	account := &mockAccountClient{
+		apps: []AppInfo{
+			{URI: "acc.a1", Region: "nq"},
+			{URI: "acc.a2", Region: "hz"},
+			{URI: "acc.a3", Region: "na"},
+		},
+		clients: make(map[string]*mockQcosClient),
+	}
+	for _, app := range account.apps {
+		account.clients[app.URI] = newMockQcosClient()
+	}
+	return account
+}
+
+func TestApplyStack(t *testing.T) {
+	client := newRolloutTestClient()
+	manifest := CreateStackArgs{
+		Name: "web",
+		Services: []CreateServiceArgs{
+			{Name: "nginx", InstanceNum: 2, Spec: ServiceSpec{Image: "nginx:1.11"}},
+			{Name: "redis", InstanceNum: 1, Spec: ServiceSpec{Image: "redis"}},
+		},
+	}
+	assert.NoError(t, ApplyStack(context.TODO(), client, manifest, true))
+	assert.Equal(t, []string{
+		"UpdateStack web",
+		"UpdateService web/nginx",
+		"ScaleService web/nginx",
+		"CreateService web/redis",
+	}, client.calls)
+	assert.Equal(t, "nginx:1.11", client.services["web/nginx"].Spec.Image)
+	assert.Equal(t, 2, client.services["web/nginx"].InstanceNum)
+
+	client = newMockQcosClient()
+	assert.NoError(t, ApplyStack(context.TODO(), client, manifest, false))
+	assert.Equal(t, []string{"CreateStack web"}, client.calls)
+}
+
+func TestMultiRegionDeploy(t *testing.T) {
+	account := newMultiRegionTestAccount()
+	manifest := CreateStackArgs{
+		Name:     "web",
+		Services: []CreateServiceArgs{{Name: "nginx", InstanceNum: 1, Spec: ServiceSpec{Image: "nginx"}}},
+	}
+	results, err := MultiRegionDeploy(context.TODO(), account, manifest, MultiRegionDeployOpts{
+		Apps: []string{"acc.a1", "acc.a2", "acc.a3"},
+	})
+	assert.NoError(t, err)
+	for i, r := range results {
+		assert.Equal(t, RegionDeploySucceeded, r.Status)
+		assert.Equal(t, account.apps[i].Region, r.Region)
+		assert.Equal(t, []string{"CreateStack web"}, account.clients[r.App].calls)
+	}
+	assert.Equal(t, []int{1, 2, 2}, []int{results[0].Wave, results[1].Wave, results[2].Wave})
+}
+
+func TestMultiRegionDeployFailed(t *testing.T) {
+	account := newMultiRegionTestAccount()
+	failOn := "acc.a1"
+	deploy := func(ctx context.Context, client QcosClient, app string) error {
+		if app == failOn {
+			return errors.New("quota exceeded")
+		}
+		return nil
+	}
+	apps := []string{"acc.a1", "acc.a2", "acc.a3", "acc.a4"}
+
+	results, err := MultiRegionDeploy(context.TODO(), account, CreateStackArgs{}, MultiRegionDeployOpts{
+		Apps:   apps,
+		Deploy: deploy,
+	})
+	assert.EqualError(t, err, "deploy to acc.a1 failed: quota exceeded")
+	assert.Equal(t, RegionDeployFailed, results[0].Status)
+	assert.Equal(t, RegionDeploySkipped, results[1].Status)
+	assert.Equal(t, RegionDeploySkipped, results[3].Status)
+
+	failOn = "acc.a2"
+	results, err = MultiRegionDeploy(context.TODO(), account, CreateStackArgs{}, MultiRegionDeployOpts{
+		Apps:        apps,
+		Canary:      -1,
+		Concurrency: 1,
+		Deploy:      deploy,
+	})
+	assert.EqualError(t, err, "deploy to acc.a2 failed: quota exceeded")
+	assert.Equal(t, RegionDeployResults{results[1]}, results.Failed())
+	assert.Equal(t, RegionDeploySucceeded, results[0].Status)
+	assert.Equal(t, RegionDeploySkipped, results[2].Status)
+
+	// acc.a4 has no client
+	failOn = ""
+	results, err = MultiRegionDeploy(context.TODO(), account, CreateStackArgs{}, MultiRegionDeployOpts{
+		Apps:   []string{"acc.a4"},
+		Deploy: deploy,
+	})
+	assert.Error(t, err)
+	results[0].Duration = 0
+	var buf bytes.Buffer
+	results.WriteText(&buf)
+	assert.Equal(t, "acc.a4 () wave 1: FAILED in 0s: not found\n", buf.String())
+}
@@ -0,0 +1,503 @@
+package kirksdk
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"golang.org/x/net/context"
+)
+
+var ErrEmptyImage = errors.New("image could not be empty")
+
+// PatchState tells what a ServicePatch does to a field.
+type PatchState int
+
+const (
+	// The field is left unchanged and omitted from the update.
+	PatchUnchanged PatchState = iota
+	// The field is replaced with a new value.
+	PatchSet
+	// The field is cleared, and sent as an empty list.
+	PatchCleared
+)
+
+// ServicePatch describes field level changes to a service.
+//
+// Unlike UpdateServiceArgs, a patch distinguishes between leaving a field
+// unchanged and clearing it, and allows changing a single env var, host,
+// log collector or conf without resending the others. A patch is turned into
+// the minimal UpdateServiceArgs by applying it to the current export of the
+// service, see Apply and PatchService.
+type ServicePatch struct {
+	image        *string
+	workDir      *string
+	autoRestart  *string
+	unitType     *string
+	stopGraceSec *int
+
+	command    listPatch
+	entryPoint listPatch
+
+	envs          keyedPatch
+	hosts         keyedPatch
+	logCollectors keyedPatch
+	confs         keyedPatch
+	metadata      keyedPatch
+
+	updateParallelism *int
+	manualUpdate      bool
+}
+
+type listPatch struct {
+	state PatchState
+	value []string
+}
+
+type keyedEdit struct {
+	key   string
+	value interface{} // nil means unset
+}
+
+type keyedPatch struct {
+	cleared bool
+	edits   []keyedEdit
+}
+
+func (p *keyedPatch) set(key string, value interface{}) {
+	p.edits = append(p.edits, keyedEdit{key, value})
+}
+
+func (p *keyedPatch) unset(key string) {
+	p.edits = append(p.edits, keyedEdit{key, nil})
+}
+
+func (p *keyedPatch) clear() {
+	p.cleared = true
+	p.edits = nil
+}
+
+func (p *keyedPatch) state() PatchState {
+	switch {
+	case p.cleared && len(p.edits) == 0:
+		return PatchCleared
+	case p.cleared || len(p.edits) > 0:
+		return PatchSet
+	}
+	return PatchUnchanged
+}
+
+// keyedList is an ordered list of items indexed by key.
+type keyedList struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newKeyedList() *keyedList {
+	return &keyedList{values: make(map[string]interface{})}
+}
+
+func (l *keyedList) set(key string, value interface{}) {
+	if _, ok := l.values[key]; !ok {
+		l.keys = append(l.keys, key)
+	}
+	l.values[key] = value
+}
+
+func (l *keyedList) unset(key string) {
+	if _, ok := l.values[key]; !ok {
+		return
+	}
+	delete(l.values, key)
+	for i, k := range l.keys {
+		if k == key {
+			l.keys = append(l.keys[:i:i], l.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+func (p *keyedPatch) apply(current *keyedList) *keyedList {
+	ret := current
+	if p.cleared {
+		ret = newKeyedList()
+	}
+	for _, edit := range p.edits {
+		if edit.value == nil {
+			ret.unset(edit.key)
+		} else {
+			ret.set(edit.key, edit.value)
+		}
+	}
+	return ret
+}
+
+func NewServicePatch() *ServicePatch {
+	return &ServicePatch{}
+}
+
+func (p *ServicePatch) SetImage(image string) *ServicePatch {
+	p.image = &image
+	return p
+}
+
+func (p *ServicePatch) SetWorkDir(dir string) *ServicePatch {
+	p.workDir = &dir
+	return p
+}
+
+func (p *ServicePatch) SetAutoRestart(policy string) *ServicePatch {
+	p.autoRestart = &policy
+	return p
+}
+
+func (p *ServicePatch) SetUnitType(unitType string) *ServicePatch {
+	p.unitType = &unitType
+	return p
+}
+
+func (p *ServicePatch) SetStopGraceSec(sec int) *ServicePatch {
+	p.stopGraceSec = &sec
+	return p
+}
+
+func (p *ServicePatch) SetCommand(command ...string) *ServicePatch {
+	p.command = listPatch{PatchSet, command}
+	return p
+}
+
+func (p *ServicePatch) ClearCommand() *ServicePatch {
+	p.command = listPatch{PatchCleared, nil}
+	return p
+}
+
+func (p *ServicePatch) SetEntryPoint(entryPoint ...string) *ServicePatch {
+	p.entryPoint = listPatch{PatchSet, entryPoint}
+	return p
+}
+
+func (p *ServicePatch) ClearEntryPoint() *ServicePatch {
+	p.entryPoint = listPatch{PatchCleared, nil}
+	return p
+}
+
+// SetEnv sets env var key to value, keeping the position of an existing key.
+func (p *ServicePatch) SetEnv(key, value string) *ServicePatch {
+	p.envs.set(key, key+"="+value)
+	return p
+}
+
+func (p *ServicePatch) UnsetEnv(key string) *ServicePatch {
+	p.envs.unset(key)
+	return p
+}
+
+// ClearEnvs removes all env vars, including those set earlier in this patch.
+func (p *ServicePatch) ClearEnvs() *ServicePatch {
+	p.envs.clear()
+	return p
+}
+
+// SetHost maps hostname to ip in /etc/hosts of the containers.
+func (p *ServicePatch) SetHost(hostname, ip string) *ServicePatch {
+	p.hosts.set(hostname, hostname+":"+ip)
+	return p
+}
+
+func (p *ServicePatch) UnsetHost(hostname string) *ServicePatch {
+	p.hosts.unset(hostname)
+	return p
+}
+
+func (p *ServicePatch) ClearHosts() *ServicePatch {
+	p.hosts.clear()
+	return p
+}
+
+// SetLogCollector adds a log collector, replacing an existing collector of
+// the same directory.
+func (p *ServicePatch) SetLogCollector(spec LogCollectorSpec) *ServicePatch {
+	p.logCollectors.set(spec.Directory, spec)
+	return p
+}
+
+func (p *ServicePatch) UnsetLogCollector(directory string) *ServicePatch {
+	p.logCollectors.unset(directory)
+	return p
+}
+
+func (p *ServicePatch) ClearLogCollectors() *ServicePatch {
+	p.logCollectors.clear()
+	return p
+}
+
+// SetConf adds a conf, replacing an existing conf of the same namespace.
+func (p *ServicePatch) SetConf(spec ConfSpec) *ServicePatch {
+	p.confs.set(spec.Namespace, spec)
+	return p
+}
+
+func (p *ServicePatch) UnsetConf(namespace string) *ServicePatch {
+	p.confs.unset(namespace)
+	return p
+}
+
+func (p *ServicePatch) ClearConfs() *ServicePatch {
+	p.confs.clear()
+	return p
+}
+
+func (p *ServicePatch) SetMetadata(key, value string) *ServicePatch {
+	p.metadata.set(key, key+"="+value)
+	return p
+}
+
+func (p *ServicePatch) UnsetMetadata(key string) *ServicePatch {
+	p.metadata.unset(key)
+	return p
+}
+
+func (p *ServicePatch) SetUpdateParallelism(n int) *ServicePatch {
+	p.updateParallelism = &n
+	return p
+}
+
+// SetManualUpdate makes the update wait for DeployService to be rolled out.
+func (p *ServicePatch) SetManualUpdate(manual bool) *ServicePatch {
+	p.manualUpdate = manual
+	return p
+}
+
+// State returns what the patch does to field, named as in the JSON spec
+// (for example "image", "envs" or "logCollectors").
+func (p *ServicePatch) State(field string) PatchState {
+	switch field {
+	case "image":
+		return stringPatchState(p.image)
+	case "workDir":
+		return stringPatchState(p.workDir)
+	case "autoRestart":
+		return stringPatchState(p.autoRestart)
+	case "unitType":
+		return stringPatchState(p.unitType)
+	case "stopGraceSec":
+		if p.stopGraceSec != nil {
+			return PatchSet
+		}
+	case "updateParallelism":
+		if p.updateParallelism != nil {
+			return PatchSet
+		}
+	case "command":
+		return p.command.state
+	case "entryPoint":
+		return p.entryPoint.state
+	case "envs":
+		return p.envs.state()
+	case "hosts":
+		return p.hosts.state()
+	case "logCollectors":
+		return p.logCollectors.state()
+	case "confs":
+		return p.confs.state()
+	case "metadata":
+		return p.metadata.state()
+	}
+	return PatchUnchanged
+}
+
+func stringPatchState(s *string) PatchState {
+	if s != nil {
+		return PatchSet
+	}
+	return PatchUnchanged
+}
+
+// Apply computes the minimal UpdateServiceArgs that turns current into the
+// patched service. Only fields whose value changes are included in the spec;
+// metadata and update parallelism are always sent since the API does not
+// allow omitting them. changed is false if the patch has no effect.
+func (p *ServicePatch) Apply(current ServiceExportInfo) (args UpdateServiceArgs, changed bool, err error) {
+	cur := current.Spec
+	spec := &args.Spec
+
+	if p.image != nil && *p.image != cur.Image {
+		if *p.image == "" {
+			err = ErrEmptyImage
+			return
+		}
+		spec.Image = *p.image
+		changed = true
+	}
+	// empty scalars are omitted from the update, they can not be cleared
+	if p.workDir != nil && *p.workDir != cur.WorkDir {
+		if *p.workDir == "" {
+			err = errCannotClear("workDir")
+			return
+		}
+		spec.WorkDir = *p.workDir
+		changed = true
+	}
+	if p.autoRestart != nil && *p.autoRestart != cur.AutoRestart {
+		if *p.autoRestart == "" {
+			err = errCannotClear("autoRestart")
+			return
+		}
+		spec.AutoRestart = *p.autoRestart
+		changed = true
+	}
+	if p.unitType != nil && *p.unitType != cur.UnitType {
+		if *p.unitType == "" {
+			err = errCannotClear("unitType")
+			return
+		}
+		spec.UnitType = *p.unitType
+		changed = true
+	}
+	if p.stopGraceSec != nil && *p.stopGraceSec != cur.StopGraceSec {
+		if *p.stopGraceSec == 0 {
+			err = errCannotClear("stopGraceSec")
+			return
+		}
+		spec.StopGraceSec = *p.stopGraceSec
+		changed = true
+	}
+
+	if v, ok := p.command.apply(cur.Command); ok {
+		spec.Command = v
+		changed = true
+	}
+	if v, ok := p.entryPoint.apply(cur.EntryPoint); ok {
+		spec.EntryPoint = v
+		changed = true
+	}
+	if v, ok := applyStringsPatch(&p.envs, cur.Envs, envKey); ok {
+		spec.Envs = v
+		changed = true
+	}
+	if v, ok := applyStringsPatch(&p.hosts, cur.Hosts, hostKey); ok {
+		spec.Hosts = v
+		changed = true
+	}
+	if v, ok := applyLogCollectorsPatch(&p.logCollectors, cur.LogCollectors); ok {
+		spec.LogCollectors = v
+		changed = true
+	}
+	if v, ok := applyConfsPatch(&p.confs, cur.Confs); ok {
+		spec.Confs = v
+		changed = true
+	}
+
+	args.Metadata = current.Metadata
+	if v, ok := applyStringsPatch(&p.metadata, current.Metadata, envKey); ok {
+		args.Metadata = v
+		changed = true
+	}
+	args.UpdateParallelism = current.UpdateParallelism
+	if p.updateParallelism != nil && *p.updateParallelism != current.UpdateParallelism {
+		args.UpdateParallelism = *p.updateParallelism
+		changed = true
+	}
+	args.ManualUpdate = p.manualUpdate
+	return
+}
+
+func errCannotClear(field string) error {
+	return fmt.Errorf("%s could not be cleared", field)
+}
+
+// apply returns the new value and whether it differs from current. A cleared
+// list is returned as an empty, non nil slice so it is not omitted.
+func (p listPatch) apply(current []string) ([]string, bool) {
+	var v []string
+	switch p.state {
+	case PatchSet:
+		v = p.value
+	case PatchCleared:
+	default:
+		return nil, false
+	}
+	if v == nil {
+		v = []string{}
+	}
+	return v, !sameList(v, current)
+}
+
+func applyStringsPatch(p *keyedPatch, current []string, key func(string) string) ([]string, bool) {
+	if p.state() == PatchUnchanged {
+		return nil, false
+	}
+	l := newKeyedList()
+	for _, s := range current {
+		l.set(key(s), s)
+	}
+	l = p.apply(l)
+	v := make([]string, 0, len(l.keys))
+	for _, k := range l.keys {
+		v = append(v, l.values[k].(string))
+	}
+	return v, !sameList(v, current)
+}
+
+func applyLogCollectorsPatch(p *keyedPatch, current []LogCollectorSpec) ([]LogCollectorSpec, bool) {
+	if p.state() == PatchUnchanged {
+		return nil, false
+	}
+	l := newKeyedList()
+	for _, s := range current {
+		l.set(s.Directory, s)
+	}
+	l = p.apply(l)
+	v := make([]LogCollectorSpec, 0, len(l.keys))
+	for _, k := range l.keys {
+		v = append(v, l.values[k].(LogCollectorSpec))
+	}
+	return v, !sameList(v, current)
+}
+
+func applyConfsPatch(p *keyedPatch, current []ConfSpec) ([]ConfSpec, bool) {
+	if p.state() == PatchUnchanged {
+		return nil, false
+	}
+	l := newKeyedList()
+	for _, s := range current {
+		l.set(s.Namespace, s)
+	}
+	l = p.apply(l)
+	v := make([]ConfSpec, 0, len(l.keys))
+	for _, k := range l.keys {
+		v = append(v, l.values[k].(ConfSpec))
+	}
+	return v, !sameList(v, current)
+}
+
+// sameList compares two slices, treating nil and empty slices as equal.
+func sameList(a, b interface{}) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if va.Len() == 0 && vb.Len() == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// PatchService applies patch to the current export of the service and
+// submits the resulting update. No update is made if the patch does not
+// change anything.
+func PatchService(ctx context.Context, client QcosClient,
+	stackName string, serviceName string, patch *ServicePatch, sync bool) (changed bool, err error) {
+
+	current, err := client.GetServiceExport(ctx, stackName, serviceName)
+	if err != nil {
+		return
+	}
+	args, changed, err := patch.Apply(current)
+	if err != nil || !changed {
+		return
+	}
+	if sync {
+		err = client.SyncUpdateService(ctx, stackName, serviceName, args)
+	} else {
+		err = client.UpdateService(ctx, stackName, serviceName, args)
+	}
+	return
+}
@@ -0,0 +1,116 @@
+package kirksdk
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func patchTestExport() ServiceExportInfo {
+	return ServiceExportInfo{
+		Name:              "s1",
+		UpdateParallelism: 2,
+		Metadata:          []string{"team=payments"},
+		Spec: ServiceSpecExport{
+			Image:   "nginx:1.10",
+			Command: []string{"nginx"},
+			Envs:    []string{"a=1", "b=2", "c=3"},
+			Hosts:   []string{"earth:1.1.1.1"},
+			LogCollectors: []LogCollectorSpec{
+				{Directory: "/var/log", Patterns: []string{"*.log"}},
+			},
+		},
+	}
+}
+
+func TestServicePatchApply(t *testing.T) {
+	patch := NewServicePatch().
+		SetEnv("b", "20").
+		SetEnv("d", "4").
+		UnsetEnv("a").
+		SetHost("earth", "1.1.1.1").
+		ClearCommand()
+
+	assert.Equal(t, PatchSet, patch.State("envs"))
+	assert.Equal(t, PatchCleared, patch.State("command"))
+	assert.Equal(t, PatchUnchanged, patch.State("image"))
+
+	args, changed, err := patch.Apply(patchTestExport())
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, []string{"b=20", "c=3", "d=4"}, args.Spec.Envs)
+	assert.Equal(t, []string{"team=payments"}, args.Metadata)
+	assert.Equal(t, 2, args.UpdateParallelism)
+
+	testMarshal(t, args,
+		`{"manualUpdate":false,"metadata":["team=payments"],"spec":{"command":[],"envs":["b=20","c=3","d=4"]},"updateParallelism":2}`)
+}
+
+func TestServicePatchClear(t *testing.T) {
+	args, changed, err := NewServicePatch().
+		SetEnv("x", "1").
+		ClearEnvs().
+		UnsetLogCollector("/var/log").
+		Apply(patchTestExport())
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	testMarshal(t, args.Spec, `{"envs":[],"logCollectors":[]}`)
+
+	_, changed, err = NewServicePatch().
+		SetImage("nginx:1.10").
+		SetEnv("a", "1").
+		UnsetHost("mars").
+		Apply(patchTestExport())
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	_, _, err = NewServicePatch().SetImage("").Apply(patchTestExport())
+	assert.Equal(t, ErrEmptyImage, err)
+}
+
+func TestServicePatchScalars(t *testing.T) {
+	current := patchTestExport()
+	current.Spec.WorkDir = "/app"
+	current.Spec.StopGraceSec = 10
+
+	args, changed, err := NewServicePatch().
+		SetWorkDir("/srv").
+		SetAutoRestart("always").
+		SetUnitType("2U4G").
+		SetStopGraceSec(30).
+		Apply(current)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	testMarshal(t, args.Spec,
+		`{"autoRestart":"always","stopGraceSec":30,"workDir":"/srv","unitType":"2U4G"}`)
+
+	// zero values would be omitted from the update
+	_, _, err = NewServicePatch().SetWorkDir("").Apply(current)
+	assert.EqualError(t, err, "workDir could not be cleared")
+	_, _, err = NewServicePatch().SetStopGraceSec(0).Apply(current)
+	assert.EqualError(t, err, "stopGraceSec could not be cleared")
+
+	// unless already empty
+	_, changed, err = NewServicePatch().SetAutoRestart("").SetUnitType("").Apply(current)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+}
+
+func TestPatchService(t *testing.T) {
+	client := newMockQcosClient()
+	client.CreateStack(context.TODO(), CreateStackArgs{
+		Name: "web",
+		Services: []CreateServiceArgs{
+			patchTestExport().ToCreateServiceArgs(),
+		},
+	})
+	client.calls = nil
+
+	changed, err := PatchService(context.TODO(), client, "web", "s1", NewServicePatch().SetImage("nginx:1.11"), false)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, []string{"UpdateService web/s1"}, client.calls)
+	assert.Equal(t, "nginx:1.11", client.services["web/s1"].Spec.Image)
+}
@@ -0,0 +1,208 @@
+package kirksdk
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+type RollingRestartOpts struct {
+	// Number of containers restarted at once. Default 1.
+	BatchSize int
+
+	// Set the ratio of the containers to 0 on every AP port fronting the
+	// service before restarting them, and restore it afterwards.
+	Drain bool
+
+	// Time to wait after draining, for in-flight requests to finish.
+	DrainWait time.Duration
+
+	// Timeout of each batch to be running and healthy. Default 120s.
+	Timeout time.Duration
+
+	// Interval of polling the containers. Default 2s.
+	PollInterval time.Duration
+
+	// Only plan the batches and the ports to drain, without changing anything.
+	DryRun bool
+
+	Logger *logrus.Logger
+}
+
+type RollingRestartResult struct {
+	Batches    [][]string `json:"batches"`
+	DrainPorts []string   `json:"drainPorts"` // <apid>:<port>
+	Restarted  []string   `json:"restarted"`
+	Failed     []string   `json:"failed"`
+
+	// Drainer remembers the ratios of the containers left drained by a
+	// failed batch, UndrainContainer restores them.
+	Drainer *ContainerDrainer `json:"-"`
+}
+
+// RollingRestartService restarts the containers of a service batch by
+// batch. Each batch must be running, and healthy on the AP ports fronting
+// the service, before the next batch starts. It aborts at the first failed
+// batch, leaving its containers drained, as listed by ret.Drainer.
+func RollingRestartService(ctx context.Context, client QcosClient,
+	stackName, serviceName string, opts RollingRestartOpts) (ret RollingRestartResult, err error) {
+
+	if stackName == "" {
+		stackName = DefaultStack
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = waitTimeout
+	}
+	if opts.PollInterval == 0 {
+		opts.PollInterval = 2 * time.Second
+	}
+	log := loggerOf(client, opts.Logger)
+
+	svc, err := client.GetServiceInspect(ctx, stackName, serviceName)
+	if err != nil {
+		return
+	}
+	for i := 0; i < len(svc.ContainerIPs); i += opts.BatchSize {
+		end := i + opts.BatchSize
+		if end > len(svc.ContainerIPs) {
+			end = len(svc.ContainerIPs)
+		}
+		ret.Batches = append(ret.Batches, svc.ContainerIPs[i:end])
+	}
+	ports, err := serviceApPorts(ctx, client, stackName, serviceName)
+	if err != nil {
+		return
+	}
+	for _, port := range ports {
+		ret.DrainPorts = append(ret.DrainPorts, port.ApID+":"+port.Port)
+	}
+	if opts.DryRun {
+		for i, batch := range ret.Batches {
+			log.Infof("dry run: batch %d/%d restarts %s", i+1, len(ret.Batches), strings.Join(batch, ", "))
+		}
+		return
+	}
+
+	ret.Drainer = NewContainerDrainer(client)
+	for i, batch := range ret.Batches {
+		log.Infof("restarting batch %d/%d: %s", i+1, len(ret.Batches), strings.Join(batch, ", "))
+		if err = restartBatch(ctx, client, ret.Drainer, batch, ports, opts); err != nil {
+			ret.Failed = batch
+			err = fmt.Errorf("batch %d/%d: %v", i+1, len(ret.Batches), err)
+			return
+		}
+		ret.Restarted = append(ret.Restarted, batch...)
+	}
+	return
+}
+
+func restartBatch(ctx context.Context, client QcosClient, drainer *ContainerDrainer,
+	batch []string, ports []ApPortRef, opts RollingRestartOpts) (err error) {
+
+	if opts.Drain {
+		for _, ip := range batch {
+			if _, err = drainer.DrainContainer(ctx, ip); err != nil {
+				return
+			}
+		}
+		if err = sleepContext(ctx, opts.DrainWait); err != nil {
+			return
+		}
+	}
+
+	started := make(map[string]time.Time)
+	for _, ip := range batch {
+		var info ContainerInfo
+		if info, err = client.GetContainerInspect(ctx, ip); err != nil {
+			return
+		}
+		started[ip] = info.StartedAt
+		if err = client.RestartContainer(ctx, ip); err != nil {
+			return
+		}
+	}
+
+	deadline := time.Now().Add(opts.Timeout)
+	for _, ip := range batch {
+		if err = waitContainerRestarted(ctx, client, ip, started[ip], deadline, opts.PollInterval); err != nil {
+			return
+		}
+	}
+	for _, port := range ports {
+		if err = waitApBackendsHealthy(ctx, client, port, batch, deadline, opts.PollInterval); err != nil {
+			return
+		}
+	}
+
+	for _, ip := range batch {
+		if err = drainer.UndrainContainer(ctx, ip); err == ErrContainerNotDrained {
+			err = nil
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// waitContainerRestarted waits for the container to be running with a start
+// time other than started.
+func waitContainerRestarted(ctx context.Context, client QcosClient,
+	ip string, started time.Time, deadline time.Time, interval time.Duration) error {
+
+	for {
+		info, err := client.GetContainerInspect(ctx, ip)
+		if err != nil {
+			return err
+		}
+		if info.Status == StatusRunning && !info.StartedAt.Equal(started) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("container %s: timeout waiting for restart, status %s", ip, info.Status)
+		}
+		if err = sleepContext(ctx, interval); err != nil {
+			return err
+		}
+	}
+}
+
+// waitApBackendsHealthy waits for the health check of the port to report the
+// containers as healthy. Containers missing from the health check are
+// ignored.
+func waitApBackendsHealthy(ctx context.Context, client QcosClient,
+	port ApPortRef, ips []string, deadline time.Time, interval time.Duration) error {
+
+	for {
+		ret, err := client.GetHealthcheck(ctx, port.ApID, port.Port)
+		if err != nil {
+			return err
+		}
+		var bad []string
+		for backend, status := range ret {
+			for _, ip := range ips {
+				if backend != ip && !strings.HasPrefix(backend, ip+":") {
+					continue
+				}
+				if !strings.EqualFold(status, "ok") && !strings.EqualFold(status, "healthy") {
+					bad = append(bad, fmt.Sprintf("%s is %s", backend, status))
+				}
+			}
+		}
+		if len(bad) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("ap %s port %s: %s", port.ApID, port.Port, strings.Join(bad, ", "))
+		}
+		if err = sleepContext(ctx, interval); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package kirksdk
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newRestartTestClient() *mockQcosClient {
+	client := newRolloutTestClient()
+	info := client.serviceInfos["web/nginx"]
+	info.ContainerIPs = []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+	client.serviceInfos["web/nginx"] = info
+	client.containers["10.0.0.3"] = ContainerInfo{IP: "10.0.0.3", Stack: "web", Service: "nginx", Status: StatusRunning}
+	client.SetApPort(context.TODO(), "ap1", "80", SetApPortArgs{
+		Proto:       "HTTP",
+		BackendPort: 8080,
+		Backends:    []ApBackendArgs{{Stack: "web", Service: "nginx", Weight: 100}},
+	})
+	client.ApSetContainer(context.TODO(), "ap1", "80", []SetApContainerOptionsArgs{
+		{IP: "10.0.0.3", Ratio: 0.5},
+		{IP: "10.0.0.9", Ratio: 2},
+	})
+	client.SetApPort(context.TODO(), "ap2", "80", SetApPortArgs{
+		Proto:       "HTTP",
+		BackendPort: 8080,
+		Backends:    []ApBackendArgs{{Stack: "web", Service: "other", Weight: 100}},
+	})
+	client.healthchecks["ap1/80"] = map[string]string{
+		"10.0.0.1:8080": "ok", "10.0.0.2:8080": "ok", "10.0.0.3:8080": "ok", "10.0.0.9:8080": "down",
+	}
+	client.calls = nil
+	return client
+}
+
+func apRatios(client *mockQcosClient, apid, port string) map[string]float64 {
+	ret := make(map[string]float64)
+	for _, info := range client.aps[apid].Ports {
+		if info.FPort == port {
+			for _, opt := range info.ContainerOptions {
+				ret[opt.CotainerIP] = opt.Ratio
+			}
+		}
+	}
+	return ret
+}
+
+func TestRollingRestartService(t *testing.T) {
+	client := newRestartTestClient()
+
+	ret, err := RollingRestartService(context.TODO(), client, "web", "nginx", RollingRestartOpts{
+		BatchSize:    2,
+		Drain:        true,
+		PollInterval: time.Millisecond,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"10.0.0.1", "10.0.0.2"}, {"10.0.0.3"}}, ret.Batches)
+	assert.Equal(t, []string{"ap1:80"}, ret.DrainPorts)
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, ret.Restarted)
+	assert.Equal(t, []string{
+		"ApSetContainer ap1 80",
+		"ApSetContainer ap1 80",
+		"RestartContainer 10.0.0.1",
+		"RestartContainer 10.0.0.2",
+		"ApSetContainer ap1 80",
+		"ApSetContainer ap1 80",
+		"ApSetContainer ap1 80",
+		"RestartContainer 10.0.0.3",
+		"ApSetContainer ap1 80",
+	}, client.calls)
+	assert.Equal(t, map[string]float64{
+		"10.0.0.1": 1, "10.0.0.2": 1, "10.0.0.3": 0.5, "10.0.0.9": 2,
+	}, apRatios(client, "ap1", "80"))
+}
+
+func TestRollingRestartServiceFailed(t *testing.T) {
+	client := newRestartTestClient()
+	client.healthchecks["ap1/80"]["10.0.0.2:8080"] = "down"
+
+	ret, err := RollingRestartService(context.TODO(), client, "web", "nginx", RollingRestartOpts{
+		Drain:        true,
+		Timeout:      10 * time.Millisecond,
+		PollInterval: time.Millisecond,
+	})
+	assert.EqualError(t, err, "batch 2/3: ap ap1 port 80: 10.0.0.2:8080 is down")
+	assert.Equal(t, []string{"10.0.0.1"}, ret.Restarted)
+	assert.Equal(t, []string{"10.0.0.2"}, ret.Failed)
+	assert.Equal(t, map[string]float64{
+		"10.0.0.1": 1, "10.0.0.2": 0, "10.0.0.3": 0.5, "10.0.0.9": 2,
+	}, apRatios(client, "ap1", "80"))
+
+	// the failed batch can be undrained with the remembered ratios
+	assert.Equal(t, []string{"10.0.0.2"}, ret.Drainer.Drained())
+	assert.Equal(t, map[ApPortRef]float64{{ApID: "ap1", Port: "80"}: 1}, ret.Drainer.DrainedRatios("10.0.0.2"))
+	assert.NoError(t, ret.Drainer.UndrainContainer(context.TODO(), "10.0.0.2"))
+	assert.Equal(t, map[string]float64{
+		"10.0.0.1": 1, "10.0.0.2": 1, "10.0.0.3": 0.5, "10.0.0.9": 2,
+	}, apRatios(client, "ap1", "80"))
+}
+
+func TestRollingRestartServiceDryRun(t *testing.T) {
+	client := newRestartTestClient()
+
+	ret, err := RollingRestartService(context.TODO(), client, "web", "nginx", RollingRestartOpts{
+		BatchSize: 2,
+		Drain:     true,
+		DryRun:    true,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, ret.Batches, 2)
+	assert.Empty(t, ret.Restarted)
+	assert.Empty(t, client.calls)
+}
@@ -0,0 +1,375 @@
+package kirksdk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+var (
+	ErrKeyNotFound      = errors.New("key not found")
+	ErrRevisionNotFound = errors.New("revision not found")
+)
+
+// KVStore is a minimal key-value store. Keys are '/' separated paths.
+type KVStore interface {
+	// Get returns ErrKeyNotFound if key does not exist.
+	Get(key string) (value []byte, err error)
+	Put(key string, value []byte) error
+	// List returns all keys starting with prefix, in any order.
+	List(prefix string) (keys []string, err error)
+}
+
+type dirKV struct {
+	dir string
+}
+
+// NewDirKV returns a KVStore keeping each key in a file under dir. Every
+// segment of a key is escaped in the file path.
+func NewDirKV(dir string) KVStore {
+	return &dirKV{dir: dir}
+}
+
+func (p *dirKV) file(key string) string {
+	segs := strings.Split(key, "/")
+	for i, seg := range segs {
+		segs[i] = url.QueryEscape(seg)
+	}
+	return filepath.Join(p.dir, filepath.Join(segs...))
+}
+
+func (p *dirKV) Get(key string) ([]byte, error) {
+	b, err := ioutil.ReadFile(p.file(key))
+	if os.IsNotExist(err) {
+		return nil, ErrKeyNotFound
+	}
+	return b, err
+}
+
+func (p *dirKV) Put(key string, value []byte) (err error) {
+	file := p.file(key)
+	if err = os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return
+	}
+	tmp := file + ".tmp"
+	if err = ioutil.WriteFile(tmp, value, 0644); err != nil {
+		return
+	}
+	return os.Rename(tmp, file)
+}
+
+func (p *dirKV) List(prefix string) (keys []string, err error) {
+	err = filepath.Walk(p.dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.IsDir() || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(p.dir, path)
+		if err != nil {
+			return err
+		}
+		segs := strings.Split(filepath.ToSlash(rel), "/")
+		for i, seg := range segs {
+			if segs[i], err = url.QueryUnescape(seg); err != nil {
+				return nil
+			}
+		}
+		if key := strings.Join(segs, "/"); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	return
+}
+
+// ServiceRevision is the exported spec of a service at a revision.
+type ServiceRevision struct {
+	Stack     string            `json:"stack"`
+	Service   string            `json:"service"`
+	Revision  int               `json:"revision"`
+	CreatedAt time.Time         `json:"createdAt"`
+	Export    ServiceExportInfo `json:"export"`
+}
+
+// RevisionStore keeps the revisions of services.
+type RevisionStore interface {
+	Save(rev ServiceRevision) error
+	// Get returns ErrRevisionNotFound if the revision is not stored.
+	Get(stackName, serviceName string, revision int) (ServiceRevision, error)
+	// List returns the stored revisions, oldest first.
+	List(stackName, serviceName string) ([]ServiceRevision, error)
+}
+
+type kvRevisionStore struct {
+	kv KVStore
+}
+
+// NewKVRevisionStore stores revisions as JSON under the keys
+// "revisions/<stack>/<service>/<revision>".
+func NewKVRevisionStore(kv KVStore) RevisionStore {
+	return &kvRevisionStore{kv: kv}
+}
+
+// NewDirRevisionStore stores revisions as JSON files under dir.
+func NewDirRevisionStore(dir string) RevisionStore {
+	return NewKVRevisionStore(NewDirKV(dir))
+}
+
+func revisionPrefix(stackName, serviceName string) string {
+	return "revisions/" + stackName + "/" + serviceName + "/"
+}
+
+func (p *kvRevisionStore) Save(rev ServiceRevision) error {
+	b, err := json.Marshal(rev)
+	if err != nil {
+		return err
+	}
+	return p.kv.Put(revisionPrefix(rev.Stack, rev.Service)+strconv.Itoa(rev.Revision), b)
+}
+
+func (p *kvRevisionStore) Get(stackName, serviceName string, revision int) (ret ServiceRevision, err error) {
+	b, err := p.kv.Get(revisionPrefix(stackName, serviceName) + strconv.Itoa(revision))
+	if err != nil {
+		if err == ErrKeyNotFound {
+			err = ErrRevisionNotFound
+		}
+		return
+	}
+	err = json.Unmarshal(b, &ret)
+	return
+}
+
+func (p *kvRevisionStore) List(stackName, serviceName string) (ret []ServiceRevision, err error) {
+	prefix := revisionPrefix(stackName, serviceName)
+	keys, err := p.kv.List(prefix)
+	if err != nil {
+		return
+	}
+	var revisions []int
+	for _, key := range keys {
+		if n, err := strconv.Atoi(strings.TrimPrefix(key, prefix)); err == nil {
+			revisions = append(revisions, n)
+		}
+	}
+	sort.Ints(revisions)
+	for _, n := range revisions {
+		var rev ServiceRevision
+		if rev, err = p.Get(stackName, serviceName, n); err != nil {
+			return nil, err
+		}
+		ret = append(ret, rev)
+	}
+	return
+}
+
+// RevisionDiff is a changed field between two revisions. Fields are JSON
+// paths of ServiceExportInfo, lists are compared as a whole.
+type RevisionDiff struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// DiffServiceExports returns the changed fields from a to b, sorted by field.
+func DiffServiceExports(a, b ServiceExportInfo) (diffs []RevisionDiff) {
+	fa, fb := flattenJSON(a), flattenJSON(b)
+	fields := make(map[string]bool)
+	for k := range fa {
+		fields[k] = true
+	}
+	for k := range fb {
+		fields[k] = true
+	}
+	for field := range fields {
+		if fa[field] != fb[field] {
+			diffs = append(diffs, RevisionDiff{Field: field, Old: fa[field], New: fb[field]})
+		}
+	}
+	sort.Sort(revisionDiffs(diffs))
+	return
+}
+
+type revisionDiffs []RevisionDiff
+
+func (p revisionDiffs) Len() int           { return len(p) }
+func (p revisionDiffs) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p revisionDiffs) Less(i, j int) bool { return p[i].Field < p[j].Field }
+
+// flattenJSON maps the JSON paths of the leaves of v to their JSON encoding.
+// Empty lists and null are treated alike.
+func flattenJSON(v interface{}) map[string]string {
+	b, _ := json.Marshal(v)
+	var m interface{}
+	json.Unmarshal(b, &m)
+	ret := make(map[string]string)
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, e := range v {
+				walk(fieldPath(prefix, k), e)
+			}
+		case []interface{}:
+			if len(v) > 0 {
+				b, _ := json.Marshal(v)
+				ret[prefix] = string(b)
+			}
+		case nil:
+		default:
+			if !reflect.DeepEqual(v, reflect.Zero(reflect.TypeOf(v)).Interface()) {
+				b, _ := json.Marshal(v)
+				ret[prefix] = string(b)
+			}
+		}
+	}
+	walk("", m)
+	return ret
+}
+
+// RevisionHistoryEntry is a stored revision with its changes from the
+// previous stored revision.
+type RevisionHistoryEntry struct {
+	ServiceRevision
+	Diff []RevisionDiff `json:"diff"`
+}
+
+// RevisionClient is a QcosClient recording a revision of a service in Store
+// whenever it is updated through the client. Before an update, the current
+// revision is recorded too if missing, so that it can be rolled back to.
+//
+// A stored revision is never overwritten. UpdateService returns before the
+// service reports the new revision, which is then recorded by the next
+// update or Snapshot.
+type RevisionClient struct {
+	QcosClient
+	Store RevisionStore
+}
+
+func NewRevisionClient(client QcosClient, store RevisionStore) *RevisionClient {
+	return &RevisionClient{QcosClient: client, Store: store}
+}
+
+func (p *RevisionClient) UpdateService(ctx context.Context,
+	stackName string, serviceName string, args UpdateServiceArgs) (err error) {
+
+	if stackName == "" {
+		stackName = DefaultStack
+	}
+	if err = p.snapshot(ctx, stackName, serviceName); err != nil {
+		return
+	}
+	if err = p.QcosClient.UpdateService(ctx, stackName, serviceName, args); err != nil {
+		return
+	}
+	return p.snapshot(ctx, stackName, serviceName)
+}
+
+func (p *RevisionClient) SyncUpdateService(ctx context.Context,
+	stackName string, serviceName string, args UpdateServiceArgs) (err error) {
+
+	if stackName == "" {
+		stackName = DefaultStack
+	}
+	if err = p.snapshot(ctx, stackName, serviceName); err != nil {
+		return
+	}
+	if err = p.QcosClient.SyncUpdateService(ctx, stackName, serviceName, args); err != nil {
+		return
+	}
+	return p.snapshot(ctx, stackName, serviceName)
+}
+
+// Snapshot records the current revision of a service, if not stored yet.
+func (p *RevisionClient) Snapshot(ctx context.Context, stackName, serviceName string) error {
+	if stackName == "" {
+		stackName = DefaultStack
+	}
+	return p.snapshot(ctx, stackName, serviceName)
+}
+
+// snapshot records the current revision. The export is only recorded if the
+// revision is the same after it, so that it is not mixed with an update made
+// concurrently.
+func (p *RevisionClient) snapshot(ctx context.Context, stackName, serviceName string) (err error) {
+	info, err := p.QcosClient.GetServiceInspect(ctx, stackName, serviceName)
+	if err != nil {
+		return
+	}
+	for i := 0; i < 3; i++ {
+		if _, err = p.Store.Get(stackName, serviceName, info.Revision); err != ErrRevisionNotFound {
+			return
+		}
+		var export ServiceExportInfo
+		if export, err = p.QcosClient.GetServiceExport(ctx, stackName, serviceName); err != nil {
+			return
+		}
+		revision := info.Revision
+		if info, err = p.QcosClient.GetServiceInspect(ctx, stackName, serviceName); err != nil {
+			return
+		}
+		if info.Revision == revision {
+			return p.Store.Save(ServiceRevision{
+				Stack:     stackName,
+				Service:   serviceName,
+				Revision:  revision,
+				CreatedAt: time.Now(),
+				Export:    export,
+			})
+		}
+	}
+	return fmt.Errorf("%s/%s: revision changed while being recorded", stackName, serviceName)
+}
+
+// History returns the stored revisions of a service, oldest first, each with
+// its diff from the previous one.
+func (p *RevisionClient) History(stackName, serviceName string) (ret []RevisionHistoryEntry, err error) {
+	if stackName == "" {
+		stackName = DefaultStack
+	}
+	revs, err := p.Store.List(stackName, serviceName)
+	if err != nil {
+		return
+	}
+	for i, rev := range revs {
+		entry := RevisionHistoryEntry{ServiceRevision: rev}
+		if i > 0 {
+			entry.Diff = DiffServiceExports(revs[i-1].Export, rev.Export)
+		}
+		ret = append(ret, entry)
+	}
+	return
+}
+
+// RollbackToRevision updates the service to the spec, metadata and update
+// parallelism of a stored revision. The instance count and volumes are not
+// changed. The rollback itself is recorded as a new revision. An update
+// can not clear a field of the spec, so the rollback fails if a field empty
+// in the revision has been set since.
+func (p *RevisionClient) RollbackToRevision(ctx context.Context,
+	stackName, serviceName string, revision int, sync bool) (err error) {
+
+	if stackName == "" {
+		stackName = DefaultStack
+	}
+	rev, err := p.Store.Get(stackName, serviceName, revision)
+	if err != nil {
+		return fmt.Errorf("%s/%s revision %d: %v", stackName, serviceName, revision, err)
+	}
+	return restoreService(ctx, p, stackName, serviceName, rev.Export, sync)
+}
@@ -0,0 +1,198 @@
+package kirksdk
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memKV map[string][]byte
+
+func (p memKV) Get(key string) ([]byte, error) {
+	v, ok := p[key]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return v, nil
+}
+
+func (p memKV) Put(key string, value []byte) error {
+	p[key] = value
+	return nil
+}
+
+func (p memKV) List(prefix string) (keys []string, err error) {
+	for k := range p {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	return
+}
+
+func TestDirKV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kirk-kv")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	kv := NewDirKV(dir)
+	_, err = kv.Get("a/b")
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.NoError(t, kv.Put("a/b", []byte("1")))
+	assert.NoError(t, kv.Put("a/c d/e", []byte("2")))
+	assert.NoError(t, kv.Put("x", []byte("3")))
+
+	v, err := kv.Get("a/c d/e")
+	assert.NoError(t, err)
+	assert.Equal(t, "2", string(v))
+	keys, err := kv.List("a/")
+	assert.NoError(t, err)
+	sort.Strings(keys)
+	assert.Equal(t, []string{"a/b", "a/c d/e"}, keys)
+
+	keys, err = NewDirKV(dir + "/missing").List("")
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestDiffServiceExports(t *testing.T) {
+	a := ServiceExportInfo{
+		Metadata: []string{"team=web"},
+		Spec:     ServiceSpecExport{Image: "nginx:1.10", Command: []string{"nginx"}},
+	}
+	b := ServiceExportInfo{
+		Metadata:          []string{"team=web"},
+		UpdateParallelism: 2,
+		Spec:              ServiceSpecExport{Image: "nginx:1.11"},
+	}
+	assert.Equal(t, []RevisionDiff{
+		{Field: "spec.command", Old: `["nginx"]`},
+		{Field: "spec.image", Old: `"nginx:1.10"`, New: `"nginx:1.11"`},
+		{Field: "updateParallelism", New: "2"},
+	}, DiffServiceExports(a, b))
+	assert.Empty(t, DiffServiceExports(a, a))
+}
+
+func TestRevisionClient(t *testing.T) {
+	mock := newRolloutTestClient()
+	client := NewRevisionClient(mock, NewKVRevisionStore(memKV{}))
+	ctx := context.TODO()
+
+	for _, image := range []string{"nginx:1.11", "nginx:1.12"} {
+		err := client.UpdateService(ctx, "web", "nginx", UpdateServiceArgs{Spec: ServiceSpec{Image: image}})
+		assert.NoError(t, err)
+	}
+
+	history, err := client.History("web", "nginx")
+	assert.NoError(t, err)
+	assert.Len(t, history, 3)
+	var revs []int
+	for _, entry := range history {
+		revs = append(revs, entry.Revision)
+	}
+	assert.Equal(t, []int{0, 1, 2}, revs)
+	assert.Empty(t, history[0].Diff)
+	assert.Equal(t, []RevisionDiff{
+		{Field: "spec.image", Old: `"nginx:1.10"`, New: `"nginx:1.11"`},
+	}, history[1].Diff)
+
+	assert.NoError(t, client.RollbackToRevision(ctx, "web", "nginx", 0, false))
+	assert.Equal(t, "nginx:1.10", mock.services["web/nginx"].Spec.Image)
+	rev, err := client.Store.Get("web", "nginx", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, "nginx:1.10", rev.Export.Spec.Image)
+
+	err = client.RollbackToRevision(ctx, "web", "nginx", 9, false)
+	assert.EqualError(t, err, "web/nginx revision 9: revision not found")
+}
+
+func TestRollbackToRevisionNotRestored(t *testing.T) {
+	mock := newRolloutTestClient()
+	client := NewRevisionClient(mock, NewKVRevisionStore(memKV{}))
+	ctx := context.TODO()
+
+	err := client.UpdateService(ctx, "web", "nginx", UpdateServiceArgs{Spec: ServiceSpec{Image: "nginx:1.11", WorkDir: "/srv"}})
+	assert.NoError(t, err)
+	err = client.RollbackToRevision(ctx, "web", "nginx", 0, true)
+	assert.EqualError(t, err, "web/nginx: spec.workDir could not be restored")
+	assert.Equal(t, "nginx:1.10", mock.services["web/nginx"].Spec.Image)
+	assert.Equal(t, "/srv", mock.services["web/nginx"].Spec.WorkDir)
+}
+
+// racingExportClient updates the service once while it is being exported.
+type racingExportClient struct {
+	*mockQcosClient
+	raced bool
+}
+
+func (p *racingExportClient) GetServiceExport(ctx context.Context, stackName string, serviceName string) (ServiceExportInfo, error) {
+	if !p.raced {
+		p.raced = true
+		p.mockQcosClient.UpdateService(ctx, stackName, serviceName, UpdateServiceArgs{Spec: ServiceSpec{Image: "nginx:1.11"}})
+	}
+	return p.mockQcosClient.GetServiceExport(ctx, stackName, serviceName)
+}
+
+func TestRevisionClientSnapshotRace(t *testing.T) {
+	client := NewRevisionClient(&racingExportClient{mockQcosClient: newRolloutTestClient()}, NewKVRevisionStore(memKV{}))
+
+	assert.NoError(t, client.Snapshot(context.TODO(), "web", "nginx"))
+	_, err := client.Store.Get("web", "nginx", 0)
+	assert.Equal(t, ErrRevisionNotFound, err)
+	rev, err := client.Store.Get("web", "nginx", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "nginx:1.11", rev.Export.Spec.Image)
+}
+
+// asyncUpdateClient updates services, the revision being reported only
+// once the update is applied with applyUpdate.
+type asyncUpdateClient struct {
+	*mockQcosClient
+}
+
+func (p asyncUpdateClient) UpdateService(ctx context.Context, stackName string, serviceName string, args UpdateServiceArgs) error {
+	err := p.mockQcosClient.UpdateService(ctx, stackName, serviceName, args)
+	p.mu.Lock()
+	info := p.serviceInfos[stackName+"/"+serviceName]
+	info.Revision--
+	p.serviceInfos[stackName+"/"+serviceName] = info
+	p.mu.Unlock()
+	return err
+}
+
+func (p asyncUpdateClient) applyUpdate(stackName, serviceName string) {
+	p.mu.Lock()
+	info := p.serviceInfos[stackName+"/"+serviceName]
+	info.Revision++
+	p.serviceInfos[stackName+"/"+serviceName] = info
+	p.mu.Unlock()
+}
+
+func TestRevisionClientAsyncUpdate(t *testing.T) {
+	mock := asyncUpdateClient{newRolloutTestClient()}
+	client := NewRevisionClient(mock, NewKVRevisionStore(memKV{}))
+	ctx := context.TODO()
+
+	err := client.UpdateService(ctx, "web", "nginx", UpdateServiceArgs{Spec: ServiceSpec{Image: "nginx:1.11"}})
+	assert.NoError(t, err)
+	rev, err := client.Store.Get("web", "nginx", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "nginx:1.10", rev.Export.Spec.Image)
+	_, err = client.Store.Get("web", "nginx", 1)
+	assert.Equal(t, ErrRevisionNotFound, err)
+
+	mock.applyUpdate("web", "nginx")
+	assert.NoError(t, client.Snapshot(ctx, "web", "nginx"))
+	rev, err = client.Store.Get("web", "nginx", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "nginx:1.11", rev.Export.Spec.Image)
+	rev, err = client.Store.Get("web", "nginx", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "nginx:1.10", rev.Export.Spec.Image)
+}
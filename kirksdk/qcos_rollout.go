@@ -0,0 +1,427 @@
+package kirksdk
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+var ErrWaitTimeout = errors.New("timeout waiting for service")
+
+type RolloutPhase string
+
+const (
+	RolloutStarted     = RolloutPhase("STARTED")
+	RolloutStepDone    = RolloutPhase("STEP-DONE")
+	RolloutGatePassed  = RolloutPhase("GATE-PASSED")
+	RolloutGateFailed  = RolloutPhase("GATE-FAILED")
+	RolloutCompleted   = RolloutPhase("COMPLETED")
+	RolloutRollingBack = RolloutPhase("ROLLING-BACK")
+	RolloutRolledBack  = RolloutPhase("ROLLED-BACK")
+)
+
+// RolloutEvent reports the progress of a rollout. UpdateProgress and
+// UpdatingProgress are the numbers of updated and updating instances as
+// reported by the service.
+type RolloutEvent struct {
+	Stack            string       `json:"stack"`
+	Service          string       `json:"service"`
+	Phase            RolloutPhase `json:"phase"`
+	Step             int          `json:"step"`
+	Steps            int          `json:"steps"`
+	Gate             string       `json:"gate,omitempty"`
+	UpdateProgress   int          `json:"updateProgress"`
+	UpdatingProgress int          `json:"updatingProgress"`
+	InstanceNum      int          `json:"instanceNum"`
+	Err              error        `json:"-"`
+}
+
+// RolloutGate is checked after every step of a rollout. A non nil error
+// fails the gate and rolls the update back.
+type RolloutGate struct {
+	Name  string
+	Check func(ctx context.Context, client QcosClient, svc ServiceInfo) error
+}
+
+// RolloutError is returned when a rollout fails at Step (1-based, 0 if the
+// update could not be started). RolledBack reports whether the service was
+// changed and rolled back to its previous spec, RollbackErr why not if a
+// rollback was attempted.
+type RolloutError struct {
+	Step        int
+	Gate        string
+	Err         error
+	RolledBack  bool
+	RollbackErr error
+}
+
+func (p *RolloutError) Error() string {
+	msg := fmt.Sprintf("rollout failed at step %d", p.Step)
+	if p.Gate != "" {
+		msg += fmt.Sprintf(", gate %s", p.Gate)
+	}
+	msg += ": " + p.Err.Error()
+	if p.RollbackErr != nil {
+		msg += fmt.Sprintf(" (rollback failed: %v)", p.RollbackErr)
+	}
+	return msg
+}
+
+type CanaryRolloutOpts struct {
+	// Cumulative percentages of instances updated after each step, for
+	// example {10, 50, 100}. Defaults to one instance per step.
+	Steps []int
+
+	// Time to wait after a step before checking the gates.
+	Bake time.Duration
+
+	// Timeout of each step and of the final complete or rollback.
+	// Default 120s.
+	StepTimeout time.Duration
+
+	// Interval of polling the service. Default 2s.
+	PollInterval time.Duration
+
+	Gates   []RolloutGate
+	OnEvent func(RolloutEvent)
+	Logger  *logrus.Logger
+}
+
+// CanaryRollout updates a service step by step. It starts a manual update
+// with args, lets the given share of instances update at each step, and
+// checks the gates in between. The update is completed once all steps pass,
+// and rolled back when a step or a gate fails. Rolling back uses its own
+// context, so it is done even if ctx is canceled.
+func CanaryRollout(ctx context.Context, client QcosClient,
+	stackName, serviceName string, args UpdateServiceArgs, opts CanaryRolloutOpts) (err error) {
+
+	if stackName == "" {
+		stackName = DefaultStack
+	}
+	if opts.StepTimeout == 0 {
+		opts.StepTimeout = waitTimeout
+	}
+	if opts.PollInterval == 0 {
+		opts.PollInterval = 2 * time.Second
+	}
+	r := &canaryRollout{
+		client:  client,
+		stack:   stackName,
+		service: serviceName,
+		opts:    opts,
+		log:     loggerOf(client, opts.Logger),
+	}
+	return r.run(ctx, args)
+}
+
+type canaryRollout struct {
+	client  QcosClient
+	stack   string
+	service string
+	opts    CanaryRolloutOpts
+	log     *logrus.Logger
+	steps   int
+}
+
+func (p *canaryRollout) emit(phase RolloutPhase, step int, gate string, svc ServiceInfo, err error) {
+	ev := RolloutEvent{
+		Stack:            p.stack,
+		Service:          p.service,
+		Phase:            phase,
+		Step:             step,
+		Steps:            p.steps,
+		Gate:             gate,
+		UpdateProgress:   svc.UpdateProgress,
+		UpdatingProgress: svc.UpdatingProgress,
+		InstanceNum:      svc.InstanceNum,
+		Err:              err,
+	}
+	entry := p.log.WithFields(logrus.Fields{
+		"service": p.stack + "/" + p.service,
+		"step":    fmt.Sprintf("%d/%d", step, p.steps),
+	})
+	if err != nil {
+		entry.Warnf("rollout %s: %v", phase, err)
+	} else {
+		entry.Infof("rollout %s, %d/%d updated", phase, svc.UpdateProgress, svc.InstanceNum)
+	}
+	if p.opts.OnEvent != nil {
+		p.opts.OnEvent(ev)
+	}
+}
+
+func (p *canaryRollout) run(ctx context.Context, args UpdateServiceArgs) (err error) {
+	svc, err := p.client.GetServiceInspect(ctx, p.stack, p.service)
+	if err != nil {
+		return &RolloutError{Err: err}
+	}
+	targets := rolloutTargets(p.opts.Steps, svc.InstanceNum)
+	p.steps = len(targets)
+
+	args.ManualUpdate = true
+	if err = p.client.UpdateService(ctx, p.stack, p.service, args); err != nil {
+		return &RolloutError{Err: err}
+	}
+	p.emit(RolloutStarted, 0, "", svc, nil)
+
+	for i, target := range targets {
+		step := i + 1
+		svc, err = p.advance(ctx, target)
+		if err != nil {
+			return p.rollback(step, "", svc, err)
+		}
+		p.emit(RolloutStepDone, step, "", svc, nil)
+
+		if err = sleepContext(ctx, p.opts.Bake); err != nil {
+			return p.rollback(step, "", svc, err)
+		}
+		if svc, err = p.client.GetServiceInspect(ctx, p.stack, p.service); err != nil {
+			return p.rollback(step, "", svc, err)
+		}
+		for _, gate := range p.opts.Gates {
+			if err = gate.Check(ctx, p.client, svc); err != nil {
+				p.emit(RolloutGateFailed, step, gate.Name, svc, err)
+				return p.rollback(step, gate.Name, svc, err)
+			}
+			p.emit(RolloutGatePassed, step, gate.Name, svc, nil)
+		}
+	}
+
+	if err = p.client.DeployService(ctx, p.stack, p.service, DeployComplete()); err != nil {
+		return p.rollback(p.steps, "", svc, err)
+	}
+	svc, err = pollService(ctx, p.client, p.stack, p.service, p.opts.PollInterval, p.opts.StepTimeout, serviceDeployed)
+	if err != nil {
+		return &RolloutError{Step: p.steps, Err: err, RolledBack: false}
+	}
+	p.emit(RolloutCompleted, p.steps, "", svc, nil)
+	return nil
+}
+
+// advance lets the update continue until target instances are updated.
+func (p *canaryRollout) advance(ctx context.Context, target int) (svc ServiceInfo, err error) {
+	svc, err = p.client.GetServiceInspect(ctx, p.stack, p.service)
+	if err != nil {
+		return
+	}
+	if n := target - svc.UpdateProgress - svc.UpdatingProgress; n > 0 {
+		if err = p.client.DeployService(ctx, p.stack, p.service, DeployContinue(n)); err != nil {
+			return
+		}
+	}
+	return pollService(ctx, p.client, p.stack, p.service, p.opts.PollInterval, p.opts.StepTimeout,
+		func(svc ServiceInfo) (bool, error) {
+			if svc.Status == StatusFault {
+				return false, fmt.Errorf("service is %s", svc.Status)
+			}
+			return svc.UpdateProgress >= target && svc.UpdatingProgress == 0, nil
+		})
+}
+
+func (p *canaryRollout) rollback(step int, gate string, svc ServiceInfo, cause error) error {
+	p.emit(RolloutRollingBack, step, gate, svc, cause)
+	ret := &RolloutError{Step: step, Gate: gate, Err: cause}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.opts.StepTimeout)
+	defer cancel()
+	err := p.client.DeployService(ctx, p.stack, p.service, DeployRollback())
+	if err == nil {
+		svc, err = pollService(ctx, p.client, p.stack, p.service, p.opts.PollInterval, p.opts.StepTimeout, serviceDeployed)
+	}
+	if err != nil {
+		p.log.Errorf("rollback %s/%s failed: %v", p.stack, p.service, err)
+		ret.RollbackErr = err
+		return ret
+	}
+	ret.RolledBack = true
+	p.emit(RolloutRolledBack, step, gate, svc, nil)
+	return ret
+}
+
+// rolloutTargets converts cumulative percentages to increasing instance
+// counts, the last one being all instances.
+func rolloutTargets(steps []int, instances int) (targets []int) {
+	if instances <= 0 {
+		return []int{0}
+	}
+	if len(steps) == 0 {
+		for i := 1; i <= instances; i++ {
+			targets = append(targets, i)
+		}
+		return
+	}
+	pcts := append([]int(nil), steps...)
+	sort.Ints(pcts)
+	for _, pct := range pcts {
+		n := (pct*instances + 99) / 100
+		if n < 1 {
+			n = 1
+		}
+		if n > instances {
+			n = instances
+		}
+		if len(targets) == 0 || n > targets[len(targets)-1] {
+			targets = append(targets, n)
+		}
+	}
+	if targets[len(targets)-1] != instances {
+		targets = append(targets, instances)
+	}
+	return
+}
+
+func serviceDeployed(svc ServiceInfo) (bool, error) {
+	return svc.State == StateDeployed, nil
+}
+
+// pollService inspects the service every interval until cond returns true or
+// an error, or timeout expires.
+func pollService(ctx context.Context, client QcosClient, stackName, serviceName string,
+	interval, timeout time.Duration, cond func(ServiceInfo) (bool, error)) (svc ServiceInfo, err error) {
+
+	deadline := time.Now().Add(timeout)
+	for {
+		svc, err = client.GetServiceInspect(ctx, stackName, serviceName)
+		if err != nil {
+			return
+		}
+		var ok bool
+		if ok, err = cond(svc); ok || err != nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			return svc, ErrWaitTimeout
+		}
+		if err = sleepContext(ctx, interval); err != nil {
+			return
+		}
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// ContainersRunningGate checks that every container of the service is
+// running.
+func ContainersRunningGate() RolloutGate {
+	return RolloutGate{
+		Name: "containers-running",
+		Check: func(ctx context.Context, client QcosClient, svc ServiceInfo) error {
+			var bad []string
+			for _, ip := range svc.ContainerIPs {
+				info, err := client.GetContainerInspect(ctx, ip)
+				if err != nil {
+					return err
+				}
+				if info.Status != StatusRunning {
+					bad = append(bad, fmt.Sprintf("%s is %s", ip, info.Status))
+				}
+			}
+			if len(bad) > 0 {
+				return errors.New(strings.Join(bad, ", "))
+			}
+			return nil
+		},
+	}
+}
+
+// ApHealthGate checks that every backend of an AP port is healthy, as
+// reported by GetHealthcheck. A backend is healthy if its status is "ok" or
+// "healthy", case insensitive.
+func ApHealthGate(apid, port string) RolloutGate {
+	return RolloutGate{
+		Name: "ap-health " + apid + ":" + port,
+		Check: func(ctx context.Context, client QcosClient, svc ServiceInfo) error {
+			ret, err := client.GetHealthcheck(ctx, apid, port)
+			if err != nil {
+				return err
+			}
+			var bad []string
+			for backend, status := range ret {
+				if !strings.EqualFold(status, "ok") && !strings.EqualFold(status, "healthy") {
+					bad = append(bad, fmt.Sprintf("%s is %s", backend, status))
+				}
+			}
+			if len(bad) > 0 {
+				sort.Strings(bad)
+				return errors.New(strings.Join(bad, ", "))
+			}
+			return nil
+		},
+	}
+}
+
+type ErrorRateGateOpts struct {
+	// Query of the access log search selecting the requests of the service.
+	Query string
+	// Sort order of the search, so that the most recent requests come first.
+	Sort string
+	// Number of requests examined. Default 1000.
+	Sample int
+	// The gate passes if fewer requests are found.
+	MinRequests int
+	// Maximum share of responses with status code 5xx, between 0 and 1.
+	MaxRate float64
+	// Only the requests collected within Window before the check are
+	// examined, so that those served before the step are left out. Set it
+	// to about the bake time of the rollout. Default 1 minute.
+	Window time.Duration
+}
+
+// ErrorRateGate checks the share of 5xx responses in the access logs.
+func ErrorRateGate(opts ErrorRateGateOpts) RolloutGate {
+	if opts.Sample == 0 {
+		opts.Sample = 1000
+	}
+	if opts.Window == 0 {
+		opts.Window = time.Minute
+	}
+	return RolloutGate{
+		Name: "error-rate",
+		Check: func(ctx context.Context, client QcosClient, svc ServiceInfo) error {
+			now := time.Now()
+			query := fmt.Sprintf("collectedAtNano:[%d TO %d]", now.Add(-opts.Window).UnixNano(), now.UnixNano())
+			if opts.Query != "" {
+				query = "(" + opts.Query + ") AND " + query
+			}
+			res, err := client.SearchContainerLogs(ctx, SearchContainerLogsArgs{
+				RepoType: "access",
+				Query:    query,
+				Size:     opts.Sample,
+				Sort:     opts.Sort,
+			})
+			if err != nil {
+				return err
+			}
+			if len(res.Data) == 0 || len(res.Data) < opts.MinRequests {
+				return nil
+			}
+			errs := 0
+			for _, hit := range res.Data {
+				if hit.StatusCode >= 500 {
+					errs++
+				}
+			}
+			rate := float64(errs) / float64(len(res.Data))
+			if rate > opts.MaxRate {
+				return fmt.Errorf("error rate %.2f%% of %d requests exceeds %.2f%%",
+					rate*100, len(res.Data), opts.MaxRate*100)
+			}
+			return nil
+		},
+	}
+}
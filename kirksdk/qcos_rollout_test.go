@@ -0,0 +1,154 @@
+package kirksdk
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRolloutTargets(t *testing.T) {
+	assert.Equal(t, []int{1, 2, 3}, rolloutTargets(nil, 3))
+	assert.Equal(t, []int{1, 5, 10}, rolloutTargets([]int{10, 50, 100}, 10))
+	assert.Equal(t, []int{1, 2}, rolloutTargets([]int{50, 10}, 2))
+	assert.Equal(t, []int{1, 4}, rolloutTargets([]int{1, 25}, 4))
+}
+
+func newRolloutTestClient() *mockQcosClient {
+	client := newMockQcosClient()
+	client.CreateStack(context.TODO(), CreateStackArgs{
+		Name: "web",
+		Services: []CreateServiceArgs{
+			{Name: "nginx", InstanceNum: 4, Spec: ServiceSpec{Image: "nginx:1.10"}},
+		},
+	})
+	client.serviceInfos["web/nginx"] = ServiceInfo{
+		Name:         "nginx",
+		Stack:        "web",
+		State:        StateDeployed,
+		Status:       StatusRunning,
+		ContainerIPs: []string{"10.0.0.1", "10.0.0.2"},
+	}
+	client.containers["10.0.0.1"] = ContainerInfo{IP: "10.0.0.1", Stack: "web", Service: "nginx", Status: StatusRunning}
+	client.containers["10.0.0.2"] = ContainerInfo{IP: "10.0.0.2", Stack: "web", Service: "nginx", Status: StatusRunning}
+	client.calls = nil
+	return client
+}
+
+func TestCanaryRollout(t *testing.T) {
+	client := newRolloutTestClient()
+	client.healthchecks["ap1/80"] = map[string]string{"10.0.0.1": "OK", "10.0.0.2": "ok"}
+
+	var phases []RolloutPhase
+	err := CanaryRollout(context.TODO(), client, "web", "nginx",
+		UpdateServiceArgs{Spec: ServiceSpec{Image: "nginx:1.11"}},
+		CanaryRolloutOpts{
+			Steps:        []int{25, 100},
+			PollInterval: time.Millisecond,
+			Gates:        []RolloutGate{ContainersRunningGate(), ApHealthGate("ap1", "80")},
+			OnEvent: func(ev RolloutEvent) {
+				phases = append(phases, ev.Phase)
+			},
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"UpdateService web/nginx",
+		"DeployService web/nginx CONTINUE 1",
+		"DeployService web/nginx CONTINUE 3",
+		"DeployService web/nginx COMPLETE",
+	}, client.calls)
+	assert.Equal(t, []RolloutPhase{
+		RolloutStarted,
+		RolloutStepDone, RolloutGatePassed, RolloutGatePassed,
+		RolloutStepDone, RolloutGatePassed, RolloutGatePassed,
+		RolloutCompleted,
+	}, phases)
+	assert.Equal(t, StateDeployed, client.serviceInfos["web/nginx"].State)
+}
+
+func TestCanaryRolloutGateFailed(t *testing.T) {
+	client := newRolloutTestClient()
+	step := 0
+	failing := RolloutGate{
+		Name: "second-step",
+		Check: func(ctx context.Context, client QcosClient, svc ServiceInfo) error {
+			if step++; step == 2 {
+				return errors.New("too slow")
+			}
+			return nil
+		},
+	}
+
+	var last RolloutEvent
+	err := CanaryRollout(context.TODO(), client, "web", "nginx",
+		UpdateServiceArgs{Spec: ServiceSpec{Image: "nginx:1.11"}},
+		CanaryRolloutOpts{
+			Steps:        []int{25, 50, 100},
+			PollInterval: time.Millisecond,
+			Gates:        []RolloutGate{failing},
+			OnEvent: func(ev RolloutEvent) {
+				last = ev
+			},
+		})
+	assert.Equal(t, &RolloutError{Step: 2, Gate: "second-step", Err: errors.New("too slow"), RolledBack: true}, err)
+	assert.Equal(t, "rollout failed at step 2, gate second-step: too slow", err.Error())
+	assert.Equal(t, "DeployService web/nginx ROLLBACK", client.calls[len(client.calls)-1])
+	assert.Equal(t, RolloutRolledBack, last.Phase)
+	assert.Equal(t, 3, last.Steps)
+}
+
+func TestCanaryRolloutRollbackFailed(t *testing.T) {
+	client := newRolloutTestClient()
+	client.errs["DeployService web/nginx ROLLBACK"] = errors.New("busy")
+	failing := RolloutGate{
+		Name: "always",
+		Check: func(ctx context.Context, client QcosClient, svc ServiceInfo) error {
+			return errors.New("too slow")
+		},
+	}
+
+	err := CanaryRollout(context.TODO(), client, "web", "nginx",
+		UpdateServiceArgs{Spec: ServiceSpec{Image: "nginx:1.11"}},
+		CanaryRolloutOpts{PollInterval: time.Millisecond, Gates: []RolloutGate{failing}})
+	assert.Equal(t, &RolloutError{Step: 1, Gate: "always", Err: errors.New("too slow"), RollbackErr: errors.New("busy")}, err)
+	assert.Equal(t, "rollout failed at step 1, gate always: too slow (rollback failed: busy)", err.Error())
+
+	// no rollback was attempted
+	err = &RolloutError{Step: 3, Err: errors.New("timeout")}
+	assert.Equal(t, "rollout failed at step 3: timeout", err.Error())
+
+	// nothing to roll back if the update was not started
+	err = CanaryRollout(context.TODO(), client, "web", "redis", UpdateServiceArgs{}, CanaryRolloutOpts{})
+	if assert.IsType(t, &RolloutError{}, err) {
+		assert.False(t, err.(*RolloutError).RolledBack)
+		assert.Equal(t, 0, err.(*RolloutError).Step)
+	}
+}
+
+func TestErrorRateGate(t *testing.T) {
+	client := newRolloutTestClient()
+	client.accessLogs = []Hit{{StatusCode: 200}, {StatusCode: 502}, {StatusCode: 200}, {StatusCode: 404}}
+
+	gate := ErrorRateGate(ErrorRateGateOpts{Query: "requestApp:web", MaxRate: 0.3})
+	assert.NoError(t, gate.Check(context.TODO(), client, ServiceInfo{}))
+
+	gate = ErrorRateGate(ErrorRateGateOpts{MaxRate: 0.2})
+	assert.EqualError(t, gate.Check(context.TODO(), client, ServiceInfo{}),
+		"error rate 25.00% of 4 requests exceeds 20.00%")
+
+	gate = ErrorRateGate(ErrorRateGateOpts{MaxRate: 0.2, MinRequests: 10})
+	assert.NoError(t, gate.Check(context.TODO(), client, ServiceInfo{}))
+
+	// the requests are searched within the window before the check
+	var from, to int64
+	_, err := fmt.Sscanf(client.calls[0], "SearchContainerLogs access (requestApp:web) AND collectedAtNano:[%d TO %d]", &from, &to)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(time.Minute), to-from)
+	_, err = fmt.Sscanf(client.calls[1], "SearchContainerLogs access collectedAtNano:[%d TO %d]", &from, &to)
+	assert.NoError(t, err)
+	assert.InDelta(t, time.Now().UnixNano(), to, float64(time.Second))
+}
@@ -0,0 +1,225 @@
+package kirksdk
+
+import (
+	"fmt"
+	"strings"
+)
+
+type selectorOp string
+
+const (
+	selectorEquals    = selectorOp("=")
+	selectorNotEquals = selectorOp("!=")
+	selectorIn        = selectorOp("in")
+	selectorNotIn     = selectorOp("notin")
+	selectorExists    = selectorOp("exists")
+	selectorNotExists = selectorOp("!")
+)
+
+type selectorRequirement struct {
+	key    string
+	op     selectorOp
+	values []string
+}
+
+// Selector matches the metadata of stacks, services and jobs, in the label
+// selector syntax:
+//
+//	team=payments,tier!=batch      equality and inequality
+//	env in (prod,staging)          set membership
+//	env notin (dev)                set exclusion
+//	canary                         the key exists
+//	!canary                        the key does not exist
+//
+// All requirements must match. The empty selector matches everything.
+type Selector struct {
+	reqs []selectorRequirement
+}
+
+// ParseSelector parses a selector string, see Selector.
+func ParseSelector(s string) (ret Selector, err error) {
+	for _, term := range splitSelectorTerms(s) {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		var req selectorRequirement
+		req, err = parseSelectorTerm(term)
+		if err != nil {
+			return Selector{}, err
+		}
+		ret.reqs = append(ret.reqs, req)
+	}
+	return
+}
+
+// MustParseSelector is like ParseSelector but panics on error.
+func MustParseSelector(s string) Selector {
+	ret, err := ParseSelector(s)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// splitSelectorTerms splits s at commas outside parentheses.
+func splitSelectorTerms(s string) (terms []string) {
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(terms, s[start:])
+}
+
+func parseSelectorTerm(term string) (req selectorRequirement, err error) {
+	invalid := func() (selectorRequirement, error) {
+		return selectorRequirement{}, fmt.Errorf("invalid selector %q", term)
+	}
+
+	if strings.HasPrefix(term, "!") && !strings.Contains(term, "=") {
+		req = selectorRequirement{key: strings.TrimSpace(term[1:]), op: selectorNotExists}
+	} else if i := strings.Index(term, "!="); i >= 0 {
+		req = selectorRequirement{key: term[:i], op: selectorNotEquals, values: []string{term[i+2:]}}
+	} else if i := strings.Index(term, "=="); i >= 0 {
+		req = selectorRequirement{key: term[:i], op: selectorEquals, values: []string{term[i+2:]}}
+	} else if i := strings.Index(term, "="); i >= 0 {
+		req = selectorRequirement{key: term[:i], op: selectorEquals, values: []string{term[i+1:]}}
+	} else if fields := strings.Fields(term); len(fields) >= 2 {
+		req.key = fields[0]
+		switch fields[1] {
+		case "in":
+			req.op = selectorIn
+		case "notin":
+			req.op = selectorNotIn
+		default:
+			return invalid()
+		}
+		set := strings.TrimSpace(strings.TrimPrefix(term, fields[0]))
+		set = strings.TrimSpace(strings.TrimPrefix(set, fields[1]))
+		if !strings.HasPrefix(set, "(") || !strings.HasSuffix(set, ")") {
+			return invalid()
+		}
+		for _, v := range strings.Split(set[1:len(set)-1], ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				req.values = append(req.values, v)
+			}
+		}
+		if len(req.values) == 0 {
+			return invalid()
+		}
+	} else {
+		req = selectorRequirement{key: term, op: selectorExists}
+	}
+
+	req.key = strings.TrimSpace(req.key)
+	for i := range req.values {
+		req.values[i] = strings.TrimSpace(req.values[i])
+	}
+	if !metadataKeyRegexp.MatchString(req.key) {
+		return invalid()
+	}
+	return req, nil
+}
+
+// Empty returns true if the selector has no requirement.
+func (p Selector) Empty() bool {
+	return len(p.reqs) == 0
+}
+
+// Matches reports whether metadata satisfies the selector. Entries are
+// parsed leniently: an entry without '=' is a key with an empty value, and
+// for duplicate keys the last entry wins.
+func (p Selector) Matches(metadata []string) bool {
+	labels := make(map[string]string, len(metadata))
+	for _, entry := range metadata {
+		labels[envKey(entry)] = strings.TrimPrefix(entry[len(envKey(entry)):], "=")
+	}
+	for _, req := range p.reqs {
+		value, ok := labels[req.key]
+		switch req.op {
+		case selectorExists:
+			if !ok {
+				return false
+			}
+		case selectorNotExists:
+			if ok {
+				return false
+			}
+		case selectorEquals, selectorIn:
+			if !ok || !containsString(req.values, value) {
+				return false
+			}
+		case selectorNotEquals, selectorNotIn:
+			if ok && containsString(req.values, value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (p Selector) String() string {
+	terms := make([]string, 0, len(p.reqs))
+	for _, req := range p.reqs {
+		switch req.op {
+		case selectorExists:
+			terms = append(terms, req.key)
+		case selectorNotExists:
+			terms = append(terms, "!"+req.key)
+		case selectorIn, selectorNotIn:
+			terms = append(terms, fmt.Sprintf("%s %s (%s)", req.key, req.op, strings.Join(req.values, ",")))
+		default:
+			terms = append(terms, req.key+string(req.op)+req.values[0])
+		}
+	}
+	return strings.Join(terms, ",")
+}
+
+// FilterStacks returns the stacks whose metadata matches sel.
+func FilterStacks(stacks []StackInfo, sel Selector) (ret []StackInfo) {
+	for _, stack := range stacks {
+		if sel.Matches(stack.Metadata) {
+			ret = append(ret, stack)
+		}
+	}
+	return
+}
+
+// FilterServices returns the services whose metadata matches sel.
+func FilterServices(services []ServiceInfo, sel Selector) (ret []ServiceInfo) {
+	for _, svc := range services {
+		if sel.Matches(svc.Metadata) {
+			ret = append(ret, svc)
+		}
+	}
+	return
+}
+
+// FilterJobs returns the jobs whose metadata matches sel.
+func FilterJobs(jobs []JobInfo, sel Selector) (ret []JobInfo) {
+	for _, job := range jobs {
+		if sel.Matches(job.Metadata) {
+			ret = append(ret, job)
+		}
+	}
+	return
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package kirksdk
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectorMatches(t *testing.T) {
+	md := []string{"team=payments", "tier=web", "canary"}
+
+	cases := []struct {
+		sel     string
+		matched bool
+	}{
+		{"", true},
+		{"team=payments", true},
+		{"team==payments,tier!=batch", true},
+		{"team=payments,tier=batch", false},
+		{"tier in (web, api)", true},
+		{"tier notin (web)", false},
+		{"env notin (prod)", true},
+		{"canary", true},
+		{"!canary", false},
+		{"!env", true},
+		{"env!=prod", true},
+	}
+	for _, c := range cases {
+		sel, err := ParseSelector(c.sel)
+		assert.NoError(t, err, c.sel)
+		assert.Equal(t, c.matched, sel.Matches(md), c.sel)
+	}
+
+	for _, s := range []string{"tier in web", "tier in ()", "=x", "a b"} {
+		_, err := ParseSelector(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestSelectorString(t *testing.T) {
+	sel := MustParseSelector(" team = payments ,tier!=batch,env in (a,b),!canary")
+	assert.Equal(t, "team=payments,tier!=batch,env in (a,b),!canary", sel.String())
+}
+
+func TestFilterServices(t *testing.T) {
+	services := []ServiceInfo{
+		{Name: "api", Metadata: []string{"tier=web"}},
+		{Name: "worker", Metadata: []string{"tier=batch"}},
+	}
+	ret := FilterServices(services, MustParseSelector("tier!=batch"))
+	assert.Equal(t, 1, len(ret))
+	assert.Equal(t, "api", ret[0].Name)
+
+	jobs := FilterJobs([]JobInfo{{Name: "j", Metadata: []string{"tier=batch"}}}, MustParseSelector("tier=batch"))
+	assert.Equal(t, 1, len(jobs))
+}
@@ -0,0 +1,242 @@
+package kirksdk
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+type SyncOpts struct {
+	// Concurrency, Include and Exclude as in CopyToContainer. Excluded
+	// remote files are neither updated nor deleted.
+	CopyOpts
+
+	// Delete the remote files not found locally. Directories are kept.
+	Delete bool
+
+	// Used by WatchSyncToContainer, to look for local changes every
+	// PollInterval, default 1s, and report each sync to OnSync.
+	PollInterval time.Duration
+	OnSync       func(SyncResult, error)
+}
+
+type SyncResult struct {
+	Uploaded  []string `json:"uploaded"`
+	Deleted   []string `json:"deleted"`
+	Unchanged int      `json:"unchanged"`
+	Bytes     int64    `json:"bytes"`
+}
+
+func (p SyncResult) String() string {
+	return fmt.Sprintf("%d uploaded (%d bytes), %d deleted, %d unchanged",
+		len(p.Uploaded), p.Bytes, len(p.Deleted), p.Unchanged)
+}
+
+// SyncToContainer updates a directory in the container from a local
+// directory, like rsync. A file is uploaded unless the remote one has the
+// same size, and is not older than the local one.
+func SyncToContainer(ctx context.Context, client QcosClient,
+	ip, localDir, remoteDir string, opts SyncOpts) (ret SyncResult, err error) {
+
+	return newContainerSyncer(client, ip, localDir, remoteDir, opts).sync(ctx)
+}
+
+// WatchSyncToContainer syncs the directories as SyncToContainer, then again
+// each time local files change, until ctx is done. Remote files changed
+// since they were uploaded are detected by their ETag, and uploaded again
+// at the next sync. A failed sync is retried at the next poll.
+func WatchSyncToContainer(ctx context.Context, client QcosClient,
+	ip, localDir, remoteDir string, opts SyncOpts) error {
+
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	syncer := newContainerSyncer(client, ip, localDir, remoteDir, opts)
+	var last map[localFile]bool
+	for {
+		files, err := listLocalFiles(localDir, &syncer.opts.CopyOpts, syncer.log)
+		snapshot := make(map[localFile]bool, len(files))
+		for _, f := range files {
+			snapshot[f] = true
+		}
+		if err != nil || !sameLocalFiles(last, snapshot) {
+			var ret SyncResult
+			if err == nil {
+				ret, err = syncer.sync(ctx)
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != nil {
+				syncer.log.Warnf("sync failed: %v", err)
+				snapshot = nil
+			} else if len(ret.Uploaded) > 0 || len(ret.Deleted) > 0 {
+				syncer.log.Infof("synced: %v", ret)
+			}
+			if opts.OnSync != nil {
+				opts.OnSync(ret, err)
+			}
+			last = snapshot
+		}
+		if err := sleepContext(ctx, opts.PollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+func sameLocalFiles(a, b map[localFile]bool) bool {
+	if a == nil || len(a) != len(b) {
+		return false
+	}
+	for f := range b {
+		if !a[f] {
+			return false
+		}
+	}
+	return true
+}
+
+// syncedFile is the state of a file after it was synced.
+type syncedFile struct {
+	size  int64
+	mtime time.Time
+	etag  string // of the remote file
+}
+
+type containerSyncer struct {
+	client    QcosClient
+	ip        string
+	localDir  string
+	remoteDir string
+	opts      SyncOpts
+	log       *logrus.Entry
+
+	mu     sync.Mutex
+	synced map[string]syncedFile
+}
+
+func newContainerSyncer(client QcosClient, ip, localDir, remoteDir string, opts SyncOpts) *containerSyncer {
+	return &containerSyncer{
+		client:    client,
+		ip:        ip,
+		localDir:  localDir,
+		remoteDir: remoteDir,
+		opts:      opts,
+		log:       loggerOf(client, opts.Logger).WithField("container", ip),
+		synced:    make(map[string]syncedFile),
+	}
+}
+
+func (p *containerSyncer) sync(ctx context.Context) (ret SyncResult, err error) {
+	if err = p.opts.CopyOpts.validate(); err != nil {
+		return
+	}
+	files, err := listLocalFiles(p.localDir, &p.opts.CopyOpts, p.log)
+	if err != nil {
+		return
+	}
+	remoteFiles, err := listContainerFiles(ctx, p.client, p.ip, p.remoteDir, &p.opts.CopyOpts)
+	if err == ErrNoSuchEntry {
+		err = nil
+	}
+	if err != nil {
+		return
+	}
+	remote := make(map[string]ContainerFileInfo, len(remoteFiles))
+	for _, f := range remoteFiles {
+		remote[f.rel] = f.info
+	}
+
+	local := make(map[string]localFile, len(files))
+	var uploads []string
+	for _, f := range files {
+		local[f.rel] = f
+		if info, ok := remote[f.rel]; ok && p.unchanged(f, info) {
+			ret.Unchanged++
+			continue
+		}
+		uploads = append(uploads, f.rel)
+	}
+
+	dirs := newContainerDirs(p.client, p.ip)
+	for _, rel := range uploads {
+		if err = dirs.mkdirAll(ctx, path.Dir(path.Join(p.remoteDir, rel))); err != nil {
+			return
+		}
+	}
+	copied, uploaded, err := copyFiles(ctx, uploads, p.opts.CopyOpts, func(ctx context.Context, rel string) (n int64, err error) {
+		return p.upload(ctx, rel, local[rel])
+	})
+	ret.Bytes = copied.Bytes
+	ret.Uploaded = uploaded
+	if err != nil {
+		return
+	}
+
+	if p.opts.Delete {
+		for _, f := range remoteFiles {
+			if _, ok := local[f.rel]; ok {
+				continue
+			}
+			p.log.Debugf("delete %s", f.rel)
+			if err = p.client.DeleteInContainer(ctx, p.ip, f.info.Path); err != nil && err != ErrNoSuchEntry {
+				return ret, fmt.Errorf("%s: %v", f.rel, err)
+			}
+			err = nil
+			ret.Deleted = append(ret.Deleted, f.rel)
+			p.mu.Lock()
+			delete(p.synced, f.rel)
+			p.mu.Unlock()
+		}
+	}
+	sort.Strings(ret.Deleted)
+	return
+}
+
+// unchanged tells if the remote file is up to date. Once a file is synced,
+// it is compared to the state it was synced in, which detects remote
+// changes. Otherwise, the remote file must have the same size and not be
+// older, mtimes being known to the second.
+func (p *containerSyncer) unchanged(f localFile, info ContainerFileInfo) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if synced, ok := p.synced[f.rel]; ok {
+		return synced.size == f.size && synced.mtime.Equal(f.mtime) && synced.etag == info.ETag
+	}
+	if info.Size != f.size || f.mtime.Truncate(time.Second).After(info.ModTime) {
+		return false
+	}
+	p.synced[f.rel] = syncedFile{size: f.size, mtime: f.mtime, etag: info.ETag}
+	return true
+}
+
+func (p *containerSyncer) upload(ctx context.Context, rel string, f localFile) (n int64, err error) {
+	file, err := os.Open(filepath.Join(p.localDir, filepath.FromSlash(rel)))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	p.log.Debugf("upload %s", rel)
+	r := &countingReader{r: file}
+	remotePath := path.Join(p.remoteDir, rel)
+	if err = p.client.UploadToContainer(ctx, p.ip, remotePath, r); err != nil {
+		return
+	}
+	// the local file may have changed while uploaded, it is then synced
+	// again the next time
+	info, err := StatContainerPath(ctx, p.client, p.ip, remotePath)
+	if err != nil {
+		return
+	}
+	p.mu.Lock()
+	p.synced[rel] = syncedFile{size: f.size, mtime: f.mtime, etag: info.ETag}
+	p.mu.Unlock()
+	return r.n, nil
+}
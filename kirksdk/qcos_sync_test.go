@@ -0,0 +1,138 @@
+package kirksdk
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncToContainer(t *testing.T) {
+	ts, fs := newWebdavTestServer(map[string]string{
+		"/srv/":               "",
+		"/srv/www/":           "",
+		"/srv/www/stale.html": "old",
+		"/srv/www/keep.tmp":   "excluded",
+	})
+	defer ts.Close()
+	client := NewQcosClient(QcosConfig{Host: ts.URL})
+
+	local, err := ioutil.TempDir("", "kirk-sync")
+	assert.NoError(t, err)
+	defer os.RemoveAll(local)
+	writeLocalFiles(t, local, map[string]string{
+		"index.html":   "<html>",
+		"css/site.css": "body {}",
+	})
+	// written before the remote files of the same size
+	past := time.Now().Add(-time.Hour)
+	writeLocalFiles(t, local, map[string]string{"stale.html": "new"})
+	os.Chtimes(filepath.Join(local, "stale.html"), past, past)
+
+	opts := SyncOpts{CopyOpts: CopyOpts{Exclude: []string{"*.tmp"}}, Delete: true}
+	syncer := newContainerSyncer(client, "10.0.0.1", local, "/srv/www", opts)
+	ret, err := syncer.sync(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"css/site.css", "index.html"}, ret.Uploaded)
+	assert.Equal(t, 1, ret.Unchanged)
+	assert.Equal(t, int64(13), ret.Bytes)
+	assert.Empty(t, ret.Deleted)
+
+	// nothing changed
+	ret, err = syncer.sync(context.TODO())
+	assert.NoError(t, err)
+	assert.Empty(t, ret.Uploaded)
+	assert.Equal(t, 3, ret.Unchanged)
+
+	// changed locally, changed remotely, deleted locally
+	writeLocalFiles(t, local, map[string]string{"index.html": "<html><body>"})
+	f, _ := fs.OpenFile("/srv/www/css/site.css", os.O_WRONLY|os.O_TRUNC, 0)
+	f.Write([]byte("body{}!"))
+	f.Close()
+	os.Remove(filepath.Join(local, "stale.html"))
+	ret, err = syncer.sync(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"css/site.css", "index.html"}, ret.Uploaded)
+	assert.Equal(t, []string{"stale.html"}, ret.Deleted)
+	assert.Equal(t, "2 uploaded (19 bytes), 1 deleted, 0 unchanged", ret.String())
+	assert.Equal(t, map[string]string{
+		"/srv/www/index.html":   "<html><body>",
+		"/srv/www/css/site.css": "body {}",
+		"/srv/www/keep.tmp":     "excluded",
+	}, readContainerFiles(t, fs, "/srv/www"))
+
+	// into a new directory
+	ret, err = SyncToContainer(context.TODO(), client, "10.0.0.1", local, "/srv/new", SyncOpts{})
+	assert.NoError(t, err)
+	assert.Len(t, ret.Uploaded, 2)
+}
+
+func TestSyncToContainerFailed(t *testing.T) {
+	h, _ := newWebdavTestHandler(map[string]string{"/srv/": ""})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" && strings.HasSuffix(r.URL.Path, "/z.html") {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	client := NewQcosClient(QcosConfig{Host: ts.URL})
+
+	local, err := ioutil.TempDir("", "kirk-sync")
+	assert.NoError(t, err)
+	defer os.RemoveAll(local)
+	writeLocalFiles(t, local, map[string]string{"a.html": "a", "b.html": "b", "z.html": "z"})
+
+	ret, err := SyncToContainer(context.TODO(), client, "10.0.0.1", local, "/srv",
+		SyncOpts{CopyOpts: CopyOpts{Concurrency: 1}})
+	assert.Error(t, err)
+	assert.Equal(t, []string{"a.html", "b.html"}, ret.Uploaded)
+	assert.Equal(t, int64(2), ret.Bytes)
+}
+
+func TestWatchSyncToContainer(t *testing.T) {
+	ts, fs := newWebdavTestServer(map[string]string{"/app/": ""})
+	defer ts.Close()
+	client := NewQcosClient(QcosConfig{Host: ts.URL})
+
+	local, err := ioutil.TempDir("", "kirk-sync")
+	assert.NoError(t, err)
+	defer os.RemoveAll(local)
+	writeLocalFiles(t, local, map[string]string{"main.js": "v1"})
+
+	results := make(chan SyncResult)
+	ctx, cancel := context.WithCancel(context.TODO())
+	done := make(chan error)
+	go func() {
+		done <- WatchSyncToContainer(ctx, client, "10.0.0.1", local, "/app", SyncOpts{
+			PollInterval: 10 * time.Millisecond,
+			OnSync: func(ret SyncResult, err error) {
+				assert.NoError(t, err)
+				results <- ret
+			},
+		})
+	}()
+
+	ret := <-results
+	assert.Equal(t, []string{"main.js"}, ret.Uploaded)
+
+	writeLocalFiles(t, local, map[string]string{"main.js": "v2", "lib/util.js": "u"})
+	ret = <-results
+	assert.Equal(t, []string{"lib/util.js", "main.js"}, ret.Uploaded)
+	assert.Equal(t, map[string]string{
+		"/app/main.js":     "v2",
+		"/app/lib/util.js": "u",
+	}, readContainerFiles(t, fs, "/app"))
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+}
@@ -0,0 +1,324 @@
+package kirksdk
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"golang.org/x/net/context"
+)
+
+type TemplateParamType string
+
+const (
+	TemplateString = TemplateParamType("string")
+	TemplateInt    = TemplateParamType("int")
+	TemplateFloat  = TemplateParamType("float")
+	TemplateBool   = TemplateParamType("bool")
+)
+
+// TemplateParam declares a typed top-level value of a stack template.
+// Values not declared as parameters are passed to the template unchecked.
+type TemplateParam struct {
+	Name        string            `json:"name"`
+	Type        TemplateParamType `json:"type"`
+	Default     interface{}       `json:"default,omitempty"`
+	Required    bool              `json:"required,omitempty"`
+	Description string            `json:"description,omitempty"`
+}
+
+// StackTemplate renders a stack manifest in JSON with text/template. The
+// values are available as .Values. Besides the text/template builtins, the
+// template may use:
+//
+//	toJSON v            v encoded in JSON, e.g. a quoted string
+//	quote s             s as a JSON string
+//	default d v         v, or d if v is empty
+//	required msg v      v, failing with msg if v is empty
+//	b64enc s, b64dec s  base64 encoding
+//	indent n s          s with every line indented by n spaces
+//	imageDigest image   digest of the image in the registry
+//	pinImage image      image referred to by its digest
+type StackTemplate struct {
+	Name   string
+	Text   string
+	Params []TemplateParam
+
+	// Used by imageDigest and pinImage.
+	Index IndexClient
+}
+
+// ParseTemplateParams reads parameter declarations in a JSON array.
+func ParseTemplateParams(r io.Reader) (params []TemplateParam, err error) {
+	err = json.NewDecoder(r).Decode(&params)
+	return
+}
+
+// LoadTemplateValues reads and merges values files in JSON, later files
+// overriding earlier ones.
+func LoadTemplateValues(files ...string) (values map[string]interface{}, err error) {
+	values = make(map[string]interface{})
+	for _, file := range files {
+		var f *os.File
+		if f, err = os.Open(file); err != nil {
+			return
+		}
+		var v map[string]interface{}
+		err = json.NewDecoder(f).Decode(&v)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		MergeTemplateValues(values, v)
+	}
+	return
+}
+
+// MergeTemplateValues merges src into dst. Nested objects are merged, other
+// values of src replace those of dst.
+func MergeTemplateValues(dst, src map[string]interface{}) {
+	for k, v := range src {
+		sub, ok1 := v.(map[string]interface{})
+		dsub, ok2 := dst[k].(map[string]interface{})
+		if ok1 && ok2 {
+			MergeTemplateValues(dsub, sub)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// SetTemplateValue sets a value from a "a.b.c=value" expression, as given on
+// a command line. The value is kept as a string, it is converted to the type
+// of its parameter when rendering.
+func SetTemplateValue(values map[string]interface{}, expr string) error {
+	i := strings.Index(expr, "=")
+	if i <= 0 {
+		return fmt.Errorf("invalid value %q, expected name=value", expr)
+	}
+	path := strings.Split(expr[:i], ".")
+	m := values
+	for _, k := range path[:len(path)-1] {
+		sub, ok := m[k].(map[string]interface{})
+		if !ok {
+			sub = make(map[string]interface{})
+			m[k] = sub
+		}
+		m = sub
+	}
+	m[path[len(path)-1]] = expr[i+1:]
+	return nil
+}
+
+// ResolveValues checks the values against the parameters, converting them
+// to the declared types and filling in the defaults. values is not changed.
+func (p *StackTemplate) ResolveValues(values map[string]interface{}) (map[string]interface{}, error) {
+	ret := make(map[string]interface{}, len(values)+len(p.Params))
+	for k, v := range values {
+		ret[k] = v
+	}
+	var errs ValidationErrors
+	for _, param := range p.Params {
+		field := fieldPath("values", param.Name)
+		v, ok := ret[param.Name]
+		if !ok || v == nil {
+			if param.Required {
+				errs.add(field, "is required")
+				continue
+			}
+			if v = param.Default; v == nil {
+				continue
+			}
+		}
+		typed, err := convertTemplateValue(param.Type, v)
+		if err != nil {
+			errs.add(field, "%v", err)
+			continue
+		}
+		ret[param.Name] = typed
+	}
+	return ret, errs.err()
+}
+
+func convertTemplateValue(typ TemplateParamType, v interface{}) (interface{}, error) {
+	s, isString := v.(string)
+	switch typ {
+	case "", TemplateString:
+		if isString {
+			return s, nil
+		}
+		switch v.(type) {
+		case float64, int, bool:
+			return fmt.Sprint(v), nil
+		}
+	case TemplateInt:
+		switch v := v.(type) {
+		case int:
+			return v, nil
+		case float64:
+			if v == math.Trunc(v) {
+				return int(v), nil
+			}
+		case string:
+			if n, err := strconv.Atoi(v); err == nil {
+				return n, nil
+			}
+		}
+	case TemplateFloat:
+		switch v := v.(type) {
+		case float64:
+			return v, nil
+		case int:
+			return float64(v), nil
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f, nil
+			}
+		}
+	case TemplateBool:
+		switch v := v.(type) {
+		case bool:
+			return v, nil
+		case string:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b, nil
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown type %s", typ)
+	}
+	return nil, fmt.Errorf("%#v is not of type %s", v, typ)
+}
+
+// Execute renders the template to text.
+func (p *StackTemplate) Execute(ctx context.Context, values map[string]interface{}) ([]byte, error) {
+	values, err := p.ResolveValues(values)
+	if err != nil {
+		return nil, err
+	}
+	name := p.Name
+	if name == "" {
+		name = "stack"
+	}
+	tmpl, err := template.New(name).Funcs(p.funcs(ctx)).Option("missingkey=error").Parse(p.Text)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, map[string]interface{}{"Values": values}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Render renders the template, and decodes and validates the manifest.
+func (p *StackTemplate) Render(ctx context.Context, values map[string]interface{}) (ret CreateStackArgs, err error) {
+	b, err := p.Execute(ctx, values)
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(b, &ret); err != nil {
+		return ret, fmt.Errorf("rendered manifest: %v", err)
+	}
+	err = ret.Validate()
+	return
+}
+
+func (p *StackTemplate) funcs(ctx context.Context) template.FuncMap {
+	return template.FuncMap{
+		"toJSON": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+		"quote": func(v interface{}) string {
+			b, _ := json.Marshal(fmt.Sprint(v))
+			return string(b)
+		},
+		"default": func(d, v interface{}) interface{} {
+			if isEmptyTemplateValue(v) {
+				return d
+			}
+			return v
+		},
+		"required": func(msg string, v interface{}) (interface{}, error) {
+			if isEmptyTemplateValue(v) {
+				return nil, fmt.Errorf("%s", msg)
+			}
+			return v, nil
+		},
+		"b64enc": func(s string) string {
+			return base64.StdEncoding.EncodeToString([]byte(s))
+		},
+		"b64dec": func(s string) (string, error) {
+			b, err := base64.StdEncoding.DecodeString(s)
+			return string(b), err
+		},
+		"indent": func(n int, s string) string {
+			pad := strings.Repeat(" ", n)
+			return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+		},
+		"imageDigest": func(image string) (string, error) {
+			digest, err := p.imageDigest(ctx, image)
+			return digest.String(), err
+		},
+		"pinImage": func(image string) (string, error) {
+			digest, err := p.imageDigest(ctx, image)
+			repo, _, _ := splitImageRef(image)
+			return repo + "@" + digest.String(), err
+		},
+	}
+}
+
+func isEmptyTemplateValue(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case bool:
+		return !v
+	case int:
+		return v == 0
+	case float64:
+		return v == 0
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+// imageDigest looks up "[registry/]username/repo[:tag]" in the registry.
+// An image already referred to by digest is not looked up.
+func (p *StackTemplate) imageDigest(ctx context.Context, image string) (Digest, error) {
+	repo, tag, digest := splitImageRef(image)
+	if digest != "" {
+		return Digest(digest), nil
+	}
+	if tag == "" {
+		tag = "latest"
+	}
+	parts := strings.Split(repo, "/")
+	if len(parts) > 2 && strings.ContainsAny(parts[0], ".:") {
+		parts = parts[1:]
+	}
+	if len(parts) != 2 {
+		return "", fmt.Errorf("image %s: expected [registry/]username/repo[:tag]", image)
+	}
+	if p.Index == nil {
+		return "", fmt.Errorf("image %s: no index client to look up the digest", image)
+	}
+	config, err := p.Index.GetImageConfig(ctx, parts[0], parts[1], tag)
+	if err != nil {
+		return "", fmt.Errorf("image %s: %v", image, err)
+	}
+	return config.Digest, nil
+}
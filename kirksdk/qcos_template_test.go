@@ -0,0 +1,111 @@
+package kirksdk
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockIndexClient struct {
+	IndexClient
+	digests map[string]Digest // key: username/repo:reference
+}
+
+func (p *mockIndexClient) GetImageConfig(ctx context.Context, username, repo, reference string) (*ImageConfig, error) {
+	digest, ok := p.digests[username+"/"+repo+":"+reference]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return &ImageConfig{Digest: digest}, nil
+}
+
+const testStackTemplate = `{
+  "name": "web",
+  "services": [{
+    "name": "nginx",
+    "instanceNum": {{ .Values.replicas }},
+    "spec": {
+      "image": {{ pinImage (printf "index.qiniu.com/acme/nginx:%s" .Values.tag) | quote }},
+      "unitType": {{ .Values.unitType | quote }},
+      "envs": [
+        "DEBUG={{ .Values.debug }}",
+        "SECRET={{ b64enc .Values.secret }}"
+      ]
+    }
+  }]
+}`
+
+var testTemplateParams = []TemplateParam{
+	{Name: "replicas", Type: TemplateInt, Default: 1},
+	{Name: "tag", Required: true},
+	{Name: "unitType", Default: "1U1G"},
+	{Name: "debug", Type: TemplateBool, Default: false},
+}
+
+func TestStackTemplateRender(t *testing.T) {
+	tmpl := &StackTemplate{
+		Text:   testStackTemplate,
+		Params: testTemplateParams,
+		Index:  &mockIndexClient{digests: map[string]Digest{"acme/nginx:1.11": "sha256:abc"}},
+	}
+
+	dir, err := ioutil.TempDir("", "kirk-values")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "base.json"), []byte(`{"replicas": 2, "tag": "1.10", "secret": "s"}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "prod.json"), []byte(`{"tag": "1.11"}`), 0644)
+	values, err := LoadTemplateValues(filepath.Join(dir, "base.json"), filepath.Join(dir, "prod.json"))
+	assert.NoError(t, err)
+	assert.NoError(t, SetTemplateValue(values, "replicas=3"))
+	assert.NoError(t, SetTemplateValue(values, "debug=true"))
+
+	args, err := tmpl.Render(context.TODO(), values)
+	assert.NoError(t, err)
+	svc := args.Services[0]
+	assert.Equal(t, 3, svc.InstanceNum)
+	assert.Equal(t, "index.qiniu.com/acme/nginx@sha256:abc", svc.Spec.Image)
+	assert.Equal(t, "1U1G", svc.Spec.UnitType)
+	assert.Equal(t, []string{"DEBUG=true", "SECRET=cw=="}, svc.Spec.Envs)
+
+	values["tag"] = "2.0"
+	_, err = tmpl.Render(context.TODO(), values)
+	assert.Contains(t, err.Error(), "image index.qiniu.com/acme/nginx:2.0: not found")
+}
+
+func TestStackTemplateResolveValues(t *testing.T) {
+	tmpl := &StackTemplate{Params: testTemplateParams}
+	_, err := tmpl.ResolveValues(map[string]interface{}{"replicas": 1.5, "debug": "yes"})
+	assert.Equal(t, ValidationErrors{
+		{Field: "values.replicas", Msg: "1.5 is not of type int"},
+		{Field: "values.tag", Msg: "is required"},
+		{Field: "values.debug", Msg: `"yes" is not of type bool`},
+	}, err)
+
+	values, err := tmpl.ResolveValues(map[string]interface{}{"tag": 1.0, "extra": "x"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"replicas": 1, "tag": "1", "unitType": "1U1G", "debug": false, "extra": "x",
+	}, values)
+}
+
+func TestStackTemplateFuncs(t *testing.T) {
+	tmpl := &StackTemplate{Text: `{{ indent 2 "a\nb" }}|{{ default "d" .Values.x }}|{{ toJSON .Values.list }}`}
+	b, err := tmpl.Execute(context.TODO(), map[string]interface{}{"x": "", "list": []interface{}{"a"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "  a\n  b|d|[\"a\"]", string(b))
+
+	tmpl = &StackTemplate{Text: `{{ required "x is required" .Values.x }}`}
+	_, err = tmpl.Execute(context.TODO(), map[string]interface{}{"x": ""})
+	assert.True(t, strings.HasSuffix(err.Error(), "x is required"))
+
+	tmpl = &StackTemplate{Text: `{{ .Values.missing }}`}
+	_, err = tmpl.Execute(context.TODO(), map[string]interface{}{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,38 @@
+//go:build darwin || freebsd
+// +build darwin freebsd
+
+package kirksdk
+
+import (
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	ioctlReadTermios  = unix.TIOCGETA
+	ioctlWriteTermios = unix.TIOCSETA
+)
+
+// struct pollfd, which unix does not define on these systems
+type pollFd struct {
+	fd      int32
+	events  int16
+	revents int16
+}
+
+const pollIn = 0x1
+
+// waitReadable waits at most timeout for fd to be readable.
+func waitReadable(fd uintptr, timeout time.Duration) (bool, error) {
+	fds := pollFd{fd: int32(fd), events: pollIn}
+	n, _, errno := unix.Syscall(unix.SYS_POLL, uintptr(unsafe.Pointer(&fds)), 1, uintptr(timeout/time.Millisecond))
+	if errno == unix.EINTR {
+		return false, nil
+	}
+	if errno != 0 {
+		return false, errno
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,33 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package kirksdk
+
+import (
+	"os"
+	"time"
+)
+
+type termState struct{}
+
+func isTerminal(fd uintptr) bool {
+	return false
+}
+
+func makeRaw(fd uintptr) (*termState, error) {
+	return nil, ErrNotImplement
+}
+
+func restoreTerm(fd uintptr, state *termState) error {
+	return ErrNotImplement
+}
+
+func termSize(fd uintptr) (width, height int, err error) {
+	return 0, 0, ErrNotImplement
+}
+
+func notifyResize(ch chan<- os.Signal) {}
+
+func waitReadable(fd uintptr, timeout time.Duration) (bool, error) {
+	return false, ErrNotImplement
+}
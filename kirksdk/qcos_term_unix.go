@@ -0,0 +1,72 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package kirksdk
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+type termState struct {
+	termios unix.Termios
+}
+
+type winsize struct {
+	Row    uint16
+	Col    uint16
+	Xpixel uint16
+	Ypixel uint16
+}
+
+func ioctl(fd, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, fd, req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func isTerminal(fd uintptr) bool {
+	var termios unix.Termios
+	return ioctl(fd, ioctlReadTermios, unsafe.Pointer(&termios)) == nil
+}
+
+// makeRaw puts the terminal into raw mode, returning its previous state.
+func makeRaw(fd uintptr) (*termState, error) {
+	var old termState
+	if err := ioctl(fd, ioctlReadTermios, unsafe.Pointer(&old.termios)); err != nil {
+		return nil, err
+	}
+	raw := old.termios
+	raw.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	raw.Oflag &^= unix.OPOST
+	raw.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	raw.Cflag &^= unix.CSIZE | unix.PARENB
+	raw.Cflag |= unix.CS8
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err := ioctl(fd, ioctlWriteTermios, unsafe.Pointer(&raw)); err != nil {
+		return nil, err
+	}
+	return &old, nil
+}
+
+func restoreTerm(fd uintptr, state *termState) error {
+	return ioctl(fd, ioctlWriteTermios, unsafe.Pointer(&state.termios))
+}
+
+func termSize(fd uintptr) (width, height int, err error) {
+	var ws winsize
+	if err = ioctl(fd, unix.TIOCGWINSZ, unsafe.Pointer(&ws)); err != nil {
+		return
+	}
+	return int(ws.Col), int(ws.Row), nil
+}
+
+func notifyResize(ch chan<- os.Signal) {
+	signal.Notify(ch, syscall.SIGWINCH)
+}
@@ -0,0 +1,430 @@
+package kirksdk
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var nameRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+const maxNameLen = 63
+
+// Values accepted for SetApPortArgs.Proto.
+var KnownApProtos = []string{"HTTP", "HTTPS", "TCP", "UDP"}
+
+// Values accepted for ApProxyOpts.NextUpstreamCond.
+var KnownNextUpstreamConds = []string{
+	"error", "timeout", "invalid_header",
+	"http_500", "http_502", "http_503", "http_504", "http_403", "http_404",
+	"off",
+}
+
+// Values accepted for CreateApArgs.Type.
+var KnownApTypes = []string{ApTypePublicIPStr, ApTypePrivateIPStr, ApTypeDomainStr, ApTypeOutwardIPStr}
+
+// FieldError reports a problem with a single field. Field is the JSON path
+// of the field, for example "services[0].spec.envs[1]".
+type FieldError struct {
+	Field string `json:"field"`
+	Msg   string `json:"msg"`
+}
+
+func (p FieldError) Error() string {
+	if p.Field == "" {
+		return p.Msg
+	}
+	return p.Field + ": " + p.Msg
+}
+
+// ValidationErrors is returned by the Validate methods and holds every
+// problem found.
+type ValidationErrors []FieldError
+
+func (p ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(p))
+	for _, e := range p {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (p *ValidationErrors) add(field string, format string, args ...interface{}) {
+	*p = append(*p, FieldError{field, fmt.Sprintf(format, args...)})
+}
+
+func (p ValidationErrors) err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return p
+}
+
+func fieldPath(prefix, field string) string {
+	if prefix == "" {
+		return field
+	}
+	if strings.HasPrefix(field, "[") {
+		return prefix + field
+	}
+	return prefix + "." + field
+}
+
+func indexPath(prefix string, i int) string {
+	return fmt.Sprintf("%s[%d]", prefix, i)
+}
+
+func (p *ValidationErrors) checkName(field, name string) {
+	switch {
+	case name == "":
+		p.add(field, "is required")
+	case len(name) > maxNameLen:
+		p.add(field, "must be at most %d characters", maxNameLen)
+	case !nameRegexp.MatchString(name):
+		p.add(field, "%q must consist of lower case letters, digits and '-', and start and end with a letter or digit", name)
+	}
+}
+
+func (p *ValidationErrors) checkKeyValues(field string, entries []string, parse func([]string) (*KeyValues, error)) {
+	seen := make(map[string]bool)
+	for i, entry := range entries {
+		kv, err := parse([]string{entry})
+		if err != nil {
+			p.add(indexPath(field, i), "%v", err.(*KeyValueError).Err)
+			continue
+		}
+		key := kv.Keys()[0]
+		if seen[key] {
+			p.add(indexPath(field, i), "duplicate key %q", key)
+		}
+		seen[key] = true
+	}
+}
+
+// checkAbsPath rejects relative paths, including "~/": the directories are
+// used in the container as is, and like docker, which requires an absolute
+// working directory, the runtime does not expand "~" to a home directory.
+func (p *ValidationErrors) checkAbsPath(field, dir string) {
+	if !path.IsAbs(dir) {
+		p.add(field, "%q must be an absolute path", dir)
+	} else if path.Clean(dir) != dir && path.Clean(dir)+"/" != dir {
+		p.add(field, "%q is not a clean path", dir)
+	}
+}
+
+func (p *ValidationErrors) checkLogCollectors(field string, collectors []LogCollectorSpec) {
+	seen := make(map[string]bool)
+	for i, c := range collectors {
+		f := indexPath(field, i)
+		p.checkAbsPath(fieldPath(f, "directory"), c.Directory)
+		if seen[path.Clean(c.Directory)] {
+			p.add(fieldPath(f, "directory"), "duplicate directory %q", c.Directory)
+		}
+		seen[path.Clean(c.Directory)] = true
+		if len(c.Patterns) == 0 {
+			p.add(fieldPath(f, "patterns"), "is required")
+		}
+		for j, pattern := range c.Patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				p.add(indexPath(fieldPath(f, "patterns"), j), "invalid pattern %q", pattern)
+			}
+		}
+	}
+}
+
+func (p *ValidationErrors) checkConfs(field string, confs []ConfSpec) {
+	for i, c := range confs {
+		if c.Namespace == "" {
+			p.add(fieldPath(indexPath(field, i), "namespace"), "is required")
+		}
+	}
+}
+
+func (p *ValidationErrors) checkPort(field string, port int) {
+	if port < 1 || port > 65535 {
+		p.add(field, "port %d out of range [1, 65535]", port)
+	}
+}
+
+// Validate checks the stack and all its services.
+func (p CreateStackArgs) Validate() error {
+	var errs ValidationErrors
+	errs.checkName("name", p.Name)
+	errs.checkKeyValues("metadata", p.Metadata, ParseMetadata)
+	seen := make(map[string]bool)
+	for i, svc := range p.Services {
+		f := indexPath("services", i)
+		svc.validate(&errs, f)
+		if svc.Name != "" && seen[svc.Name] {
+			errs.add(fieldPath(f, "name"), "duplicate service %q", svc.Name)
+		}
+		seen[svc.Name] = true
+	}
+	return errs.err()
+}
+
+// Validate checks the service, its spec and volumes.
+func (p CreateServiceArgs) Validate() error {
+	var errs ValidationErrors
+	p.validate(&errs, "")
+	return errs.err()
+}
+
+func (p CreateServiceArgs) validate(errs *ValidationErrors, prefix string) {
+	errs.checkName(fieldPath(prefix, "name"), p.Name)
+	if p.InstanceNum < 0 {
+		errs.add(fieldPath(prefix, "instanceNum"), "must not be negative")
+	}
+	if p.UpdateParallelism < 0 {
+		errs.add(fieldPath(prefix, "updateParallelism"), "must not be negative")
+	}
+	errs.checkKeyValues(fieldPath(prefix, "metadata"), p.Metadata, ParseMetadata)
+	if p.Spec.Image == "" {
+		errs.add(fieldPath(prefix, "spec.image"), "is required")
+	}
+	p.Spec.validate(errs, fieldPath(prefix, "spec"))
+
+	if len(p.Volumes) > 0 && !p.Stateful {
+		errs.add(fieldPath(prefix, "volumes"), "volumes require a stateful service")
+	}
+	names := make(map[string]bool)
+	mounts := make(map[string]bool)
+	for i, v := range p.Volumes {
+		f := indexPath(fieldPath(prefix, "volumes"), i)
+		errs.checkName(fieldPath(f, "name"), v.Name)
+		if names[v.Name] {
+			errs.add(fieldPath(f, "name"), "duplicate volume %q", v.Name)
+		}
+		names[v.Name] = true
+		errs.checkAbsPath(fieldPath(f, "mountPath"), v.MountPath)
+		if path.Clean(v.MountPath) == "/" {
+			errs.add(fieldPath(f, "mountPath"), "could not mount on /")
+		}
+		if mounts[path.Clean(v.MountPath)] {
+			errs.add(fieldPath(f, "mountPath"), "duplicate mount path %q", v.MountPath)
+		}
+		mounts[path.Clean(v.MountPath)] = true
+		if v.UnitType == "" {
+			errs.add(fieldPath(f, "unitType"), "is required")
+		}
+	}
+}
+
+// Validate checks the spec. Empty fields are valid since they fall back to
+// the defaults or current values.
+func (p ServiceSpec) Validate() error {
+	var errs ValidationErrors
+	p.validate(&errs, "")
+	return errs.err()
+}
+
+func (p ServiceSpec) validate(errs *ValidationErrors, prefix string) {
+	errs.checkKeyValues(fieldPath(prefix, "envs"), p.Envs, ParseEnvs)
+	errs.checkKeyValues(fieldPath(prefix, "hosts"), p.Hosts, ParseHosts)
+	errs.checkLogCollectors(fieldPath(prefix, "logCollectors"), p.LogCollectors)
+	errs.checkConfs(fieldPath(prefix, "confs"), p.Confs)
+	if p.StopGraceSec < 0 {
+		errs.add(fieldPath(prefix, "stopGraceSec"), "must not be negative")
+	}
+	if p.WorkDir != "" {
+		errs.checkAbsPath(fieldPath(prefix, "workDir"), p.WorkDir)
+	}
+}
+
+// Validate checks the job and all its tasks, including task dependencies.
+func (p CreateJobArgs) Validate() error {
+	var errs ValidationErrors
+	errs.checkName("name", p.Name)
+	errs.checkKeyValues("metadata", p.Metadata, ParseMetadata)
+	if p.Timeout < 0 {
+		errs.add("timeout", "must not be negative")
+	}
+	if len(p.Spec) == 0 {
+		errs.add("spec", "at least one task is required")
+	}
+	for _, name := range sortedTaskNames(p.Spec) {
+		f := "spec." + name
+		errs.checkName(f, name)
+		task := p.Spec[name]
+		task.validate(&errs, f)
+		for i, dep := range task.Deps {
+			if _, ok := p.Spec[dep]; !ok {
+				errs.add(indexPath(fieldPath(f, "deps"), i), "unknown task %q", dep)
+			} else if dep == name {
+				errs.add(indexPath(fieldPath(f, "deps"), i), "task depends on itself")
+			}
+		}
+	}
+	if cycle := jobTaskCycle(p.Spec); cycle != nil {
+		errs.add("spec", "dependency cycle: %s", strings.Join(cycle, " -> "))
+	}
+	return errs.err()
+}
+
+func sortedTaskNames(spec map[string]JobTaskSpec) []string {
+	names := make([]string, 0, len(spec))
+	for name := range spec {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// jobTaskCycle returns the tasks forming a dependency cycle, if any.
+func jobTaskCycle(spec map[string]JobTaskSpec) []string {
+	deps := make(map[string][]string, len(spec))
+	for name, task := range spec {
+		deps[name] = task.Deps
+	}
+	return dependencyCycle(sortedTaskNames(spec), deps)
+}
+
+// dependencyCycle returns the names forming a dependency cycle, if any.
+// Dependencies on unknown names and on oneself are ignored.
+func dependencyCycle(names []string, deps map[string][]string) []string {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int)
+	var stack []string
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		state[name] = visiting
+		stack = append(stack, name)
+		for _, dep := range deps[name] {
+			if _, ok := deps[dep]; !ok || dep == name {
+				continue
+			}
+			switch state[dep] {
+			case visiting:
+				for i, n := range stack {
+					if n == dep {
+						return append(append([]string(nil), stack[i:]...), dep)
+					}
+				}
+			case 0:
+				if cycle := visit(dep); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = done
+		return nil
+	}
+	for _, name := range names {
+		if state[name] == 0 {
+			if cycle := visit(name); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// Validate checks the task spec.
+func (p JobTaskSpec) Validate() error {
+	var errs ValidationErrors
+	p.validate(&errs, "")
+	return errs.err()
+}
+
+func (p JobTaskSpec) validate(errs *ValidationErrors, prefix string) {
+	if p.Image == "" {
+		errs.add(fieldPath(prefix, "image"), "is required")
+	}
+	errs.checkKeyValues(fieldPath(prefix, "envs"), p.Envs, ParseEnvs)
+	errs.checkKeyValues(fieldPath(prefix, "hosts"), p.Hosts, ParseHosts)
+	errs.checkLogCollectors(fieldPath(prefix, "logCollectors"), p.LogCollectors)
+	errs.checkConfs(fieldPath(prefix, "confs"), p.Confs)
+	if p.InstanceNum < 0 {
+		errs.add(fieldPath(prefix, "instanceNum"), "must not be negative")
+	}
+	if p.WorkDir != "" {
+		errs.checkAbsPath(fieldPath(prefix, "workDir"), p.WorkDir)
+	}
+}
+
+// Validate checks the port settings, proxy options, health check and backends.
+func (p SetApPortArgs) Validate() error {
+	var errs ValidationErrors
+	if !containsString(KnownApProtos, p.Proto) {
+		errs.add("proto", "unknown proto %q, must be one of %s", p.Proto, strings.Join(KnownApProtos, ", "))
+	}
+	errs.checkPort("backendPort", p.BackendPort)
+	if p.SessionTmoSec < 0 {
+		errs.add("sessionTimeoutSec", "must not be negative")
+	}
+	if opt := p.ProxyOpt; opt != nil {
+		if opt.FailTimeoutMS < 0 {
+			errs.add("proxyOptions.failTimeoutMs", "must not be negative")
+		}
+		if opt.MaxFails < 0 {
+			errs.add("proxyOptions.maxFails", "must not be negative")
+		}
+		if opt.NextUpstreamTries < 0 {
+			errs.add("proxyOptions.nextUpstreamTries", "must not be negative")
+		}
+		for i, cond := range opt.NextUpstreamCond {
+			if !containsString(KnownNextUpstreamConds, cond) {
+				errs.add(indexPath("proxyOptions.nextUpstreamCond", i), "unknown condition %q", cond)
+			}
+		}
+		if containsString(opt.NextUpstreamCond, "off") && len(opt.NextUpstreamCond) > 1 {
+			errs.add("proxyOptions.nextUpstreamCond", "\"off\" could not be combined with other conditions")
+		}
+	}
+	if hc := p.HealthCheck; hc != nil && hc.Enabled {
+		if p.Proto == "HTTP" || p.Proto == "HTTPS" {
+			if !strings.HasPrefix(hc.Path, "/") {
+				errs.add("healthCheck.path", "%q must start with /", hc.Path)
+			}
+		}
+		for i, code := range hc.HttpOkCodes {
+			if code < 100 || code > 599 {
+				errs.add(indexPath("healthCheck.httpOkCodes", i), "invalid http code %d", code)
+			}
+		}
+	}
+	if len(p.Backends) == 0 {
+		errs.add("backends", "at least one backend is required")
+	}
+	for i, b := range p.Backends {
+		f := indexPath("backends", i)
+		errs.checkName(fieldPath(f, "stack"), b.Stack)
+		errs.checkName(fieldPath(f, "service"), b.Service)
+		if b.Weight < 0 {
+			errs.add(fieldPath(f, "weight"), "must not be negative")
+		}
+	}
+	return errs.err()
+}
+
+// Validate checks the AP type, bandwidth and domain auth settings.
+func (p CreateApArgs) Validate() error {
+	var errs ValidationErrors
+	if !containsString(KnownApTypes, p.Type) {
+		errs.add("type", "unknown type %q, must be one of %s", p.Type, strings.Join(KnownApTypes, ", "))
+	}
+	if p.Bandwidth < 0 {
+		errs.add("bandwidthMbps", "must not be negative")
+	}
+	if (p.RequireAuth != "" || len(p.UIDWhiteList) > 0 || len(p.UIDBlackList) > 0) && p.Type != ApTypeDomainStr {
+		errs.add("requireAuth", "domain auth is only supported by %s", ApTypeDomainStr)
+	}
+	for i, uid := range p.UIDWhiteList {
+		if _, err := strconv.ParseUint(uid, 10, 32); err != nil {
+			errs.add(indexPath("uidWhiteList", i), "invalid uid %q", uid)
+		}
+	}
+	for i, uid := range p.UIDBlackList {
+		if _, err := strconv.ParseUint(uid, 10, 32); err != nil {
+			errs.add(indexPath("uidBlackList", i), "invalid uid %q", uid)
+		}
+	}
+	return errs.err()
+}
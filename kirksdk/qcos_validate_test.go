@@ -0,0 +1,115 @@
+package kirksdk
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func validationFields(err error) (fields []string) {
+	if err == nil {
+		return nil
+	}
+	for _, e := range err.(ValidationErrors) {
+		fields = append(fields, e.Field)
+	}
+	return
+}
+
+func TestValidateStack(t *testing.T) {
+	args := CreateStackArgs{
+		Name: "web",
+		Services: []CreateServiceArgs{
+			{
+				Name:        "nginx",
+				InstanceNum: 2,
+				Spec: ServiceSpec{
+					Image:       "nginx:1.10",
+					AutoRestart: "on-failure",
+					Envs:        []string{"A=1", "B=2"},
+					Hosts:       []string{"earth:1.1.1.1"},
+				},
+				Stateful: true,
+				Volumes:  []VolumeSpec{{Name: "data", MountPath: "/data", UnitType: "SSD1_16G"}},
+			},
+		},
+	}
+	assert.NoError(t, args.Validate())
+
+	args.Name = "Web_1"
+	args.Services[0].Spec.Envs = []string{"A=1", "1B=2", "A=3"}
+	args.Services[0].Spec.Hosts = []string{"earth=1.1.1.1"}
+	args.Services[0].Stateful = false
+	args.Services[0].Volumes = append(args.Services[0].Volumes, VolumeSpec{Name: "data", MountPath: "data/", UnitType: "SSD1_16G"})
+	args.Services = append(args.Services, CreateServiceArgs{Name: "nginx"})
+
+	err := args.Validate()
+	assert.Equal(t, []string{
+		"name",
+		"services[0].spec.envs[1]",
+		"services[0].spec.envs[2]",
+		"services[0].spec.hosts[0]",
+		"services[0].volumes",
+		"services[0].volumes[1].name",
+		"services[0].volumes[1].mountPath",
+		"services[1].spec.image",
+		"services[1].name",
+	}, validationFields(err))
+	assert.Contains(t, err.Error(), `services[0].spec.envs[2]: duplicate key "A"`)
+}
+
+func TestValidateJob(t *testing.T) {
+	args := CreateJobArgs{
+		Name: "backup",
+		Spec: map[string]JobTaskSpec{
+			"dump":   {Image: "mysql"},
+			"upload": {Image: "qshell", Deps: []string{"dump"}},
+		},
+	}
+	assert.NoError(t, args.Validate())
+
+	args.Spec["dump"] = JobTaskSpec{Image: "mysql", Deps: []string{"upload", "missing"}}
+	args.Spec["upload"] = JobTaskSpec{Deps: []string{"dump"}, WorkDir: "tmp"}
+	assert.Equal(t, []string{
+		"spec.dump.deps[1]",
+		"spec.upload.image",
+		"spec.upload.workDir",
+		"spec",
+	}, validationFields(args.Validate()))
+}
+
+func TestValidateAp(t *testing.T) {
+	port := SetApPortArgs{
+		Proto:       "HTTP",
+		BackendPort: 80,
+		ProxyOpt:    &DefaultApProxyOpts,
+		HealthCheck: &ApHealthCheckOpts{Enabled: true, Path: "/health", HttpOkCodes: []int{200}},
+		Backends:    []ApBackendArgs{{Stack: "web", Service: "nginx", Weight: 100}},
+	}
+	assert.NoError(t, port.Validate())
+
+	port.Proto = "http"
+	port.BackendPort = 70000
+	port.ProxyOpt = &ApProxyOpts{MaxFails: -1, NextUpstreamCond: []string{"off", "http_418"}}
+	port.HealthCheck = &ApHealthCheckOpts{Enabled: true, HttpOkCodes: []int{999}}
+	port.Backends = nil
+	assert.Equal(t, []string{
+		"proto",
+		"backendPort",
+		"proxyOptions.maxFails",
+		"proxyOptions.nextUpstreamCond[1]",
+		"proxyOptions.nextUpstreamCond",
+		"healthCheck.httpOkCodes[0]",
+		"backends",
+	}, validationFields(port.Validate()))
+
+	ap := CreateApArgs{Type: ApTypeDomainStr, RequireAuth: "qiniu", UIDWhiteList: []string{"1380000000"}}
+	assert.NoError(t, ap.Validate())
+
+	ap = CreateApArgs{Type: ApTypePrivateIPStr, Bandwidth: -1, UIDBlackList: []string{"abc"}}
+	assert.Equal(t, []string{
+		"bandwidthMbps",
+		"requireAuth",
+		"uidBlackList[0]",
+	}, validationFields(ap.Validate()))
+}
@@ -0,0 +1,184 @@
+package kirksdk
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+var ErrNotDir = errors.New("not a directory")
+
+// ContainerFileInfo describes a file in a container, as reported by
+// StatContainerFile.
+type ContainerFileInfo struct {
+	Path        string    `json:"path"` // absolute path in the container
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	IsDir       bool      `json:"isDir"`
+	ModTime     time.Time `json:"modTime"`
+	ETag        string    `json:"etag,omitempty"`
+	ContentType string    `json:"contentType,omitempty"`
+}
+
+type davMultiStatus struct {
+	Responses []davResponse `xml:"DAV: response"`
+}
+
+type davResponse struct {
+	Href      string        `xml:"DAV: href"`
+	Status    string        `xml:"DAV: status"`
+	Propstats []davPropstat `xml:"DAV: propstat"`
+}
+
+type davPropstat struct {
+	Status string `xml:"DAV: status"`
+	Prop   struct {
+		ResourceType struct {
+			Collection *struct{} `xml:"DAV: collection"`
+		} `xml:"DAV: resourcetype"`
+		ContentLength string `xml:"DAV: getcontentlength"`
+		LastModified  string `xml:"DAV: getlastmodified"`
+		ETag          string `xml:"DAV: getetag"`
+		ContentType   string `xml:"DAV: getcontenttype"`
+	} `xml:"DAV: prop"`
+}
+
+// davStatusOK tells if the status line of a multistatus response is 2xx.
+// A missing status is taken as OK.
+func davStatusOK(status string) bool {
+	fields := strings.Fields(status)
+	return len(fields) < 2 || strings.HasPrefix(fields[1], "2")
+}
+
+// ParseMultiStatus decodes the 207 Multi-Status body returned by
+// StatContainerFile. Entries reported as not found are left out.
+func ParseMultiStatus(r io.Reader) (infos []ContainerFileInfo, err error) {
+	var ms davMultiStatus
+	if err = xml.NewDecoder(r).Decode(&ms); err != nil {
+		return
+	}
+	for _, resp := range ms.Responses {
+		if !davStatusOK(resp.Status) {
+			continue
+		}
+		info := ContainerFileInfo{Path: davHrefPath(resp.Href)}
+		info.Name = path.Base(info.Path)
+		found := false
+		for _, ps := range resp.Propstats {
+			if !davStatusOK(ps.Status) {
+				continue
+			}
+			found = true
+			prop := ps.Prop
+			if prop.ResourceType.Collection != nil {
+				info.IsDir = true
+			}
+			if prop.ContentLength != "" {
+				info.Size, _ = strconv.ParseInt(strings.TrimSpace(prop.ContentLength), 10, 64)
+			}
+			if prop.LastModified != "" {
+				info.ModTime, _ = http.ParseTime(strings.TrimSpace(prop.LastModified))
+			}
+			if prop.ETag != "" {
+				info.ETag = strings.TrimSpace(prop.ETag)
+			}
+			if prop.ContentType != "" {
+				info.ContentType = strings.TrimSpace(prop.ContentType)
+			}
+		}
+		if found || len(resp.Propstats) == 0 {
+			infos = append(infos, info)
+		}
+	}
+	return
+}
+
+// davHrefPath returns the path in the container of a href like
+// /v3/containers/<ip>/webdav/files/<filePath>.
+func davHrefPath(href string) string {
+	if u, err := url.Parse(href); err == nil {
+		href = u.Path
+	}
+	const files = "/webdav/files"
+	if i := strings.Index(href, files); i >= 0 {
+		href = href[i+len(files):]
+	}
+	return path.Clean("/" + href)
+}
+
+// StatContainerPath returns the info of a file or directory in the
+// container, ErrNoSuchEntry if it does not exist. It fails if the response
+// describes other paths only.
+func StatContainerPath(ctx context.Context, client QcosClient, ip, filePath string) (ret ContainerFileInfo, err error) {
+	infos, err := statContainerPath(ctx, client, ip, filePath, 0)
+	if err != nil {
+		return
+	}
+	want := path.Clean("/" + filePath)
+	for _, info := range infos {
+		if info.Path == want {
+			return info, nil
+		}
+	}
+	if len(infos) == 0 {
+		return ret, ErrNoSuchEntry
+	}
+	return ret, fmt.Errorf("stat %s: got %s instead", want, infos[0].Path)
+}
+
+// ListContainerDir lists a directory in the container, sorted by path. The
+// directory itself is not listed. Depth 0 defaults to 1, the entries of the
+// directory, -1 lists the whole tree.
+func ListContainerDir(ctx context.Context, client QcosClient,
+	ip, dir string, args StatContainerFileArgs) (infos []ContainerFileInfo, err error) {
+
+	if args.Depth == 0 {
+		args.Depth = 1
+	}
+	all, err := statContainerPath(ctx, client, ip, dir, args.Depth)
+	if err != nil {
+		return
+	}
+	if len(all) == 0 {
+		return nil, ErrNoSuchEntry
+	}
+	self := path.Clean("/" + dir)
+	for _, info := range all {
+		if info.Path == self {
+			if !info.IsDir {
+				return nil, ErrNotDir
+			}
+			continue
+		}
+		infos = append(infos, info)
+	}
+	sort.Sort(containerFilesByPath(infos))
+	return
+}
+
+func statContainerPath(ctx context.Context, client QcosClient,
+	ip, filePath string, depth int) (infos []ContainerFileInfo, err error) {
+
+	rc, err := client.StatContainerFile(ctx, ip, filePath, StatContainerFileArgs{Depth: depth})
+	if err != nil {
+		return
+	}
+	defer rc.Close()
+	return ParseMultiStatus(rc)
+}
+
+type containerFilesByPath []ContainerFileInfo
+
+func (p containerFilesByPath) Len() int           { return len(p) }
+func (p containerFilesByPath) Less(i, j int) bool { return p[i].Path < p[j].Path }
+func (p containerFilesByPath) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -0,0 +1,260 @@
+package kirksdk
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/webdav"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newWebdavTestServer serves the files of 10.0.0.1 from an in-memory file
+// system.
+func newWebdavTestServer(files map[string]string) (*httptest.Server, webdav.FileSystem) {
+	h, fs := newWebdavTestHandler(files)
+	return httptest.NewServer(h), fs
+}
+
+// newWebdavTestHandler serves the files of 10.0.0.1. Unlike webdav.Handler,
+// it checks If-Match and If-None-Match on PUT.
+func newWebdavTestHandler(files map[string]string) (http.Handler, webdav.FileSystem) {
+	fs := webdav.NewMemFS()
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names) // parents first
+	for _, name := range names {
+		content := files[name]
+		if strings.HasSuffix(name, "/") {
+			fs.Mkdir(name, 0755)
+			continue
+		}
+		f, err := fs.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			panic(err)
+		}
+		f.Write([]byte(content))
+		f.Close()
+	}
+	const prefix = "/v3/containers/10.0.0.1/webdav/files"
+	h := &webdav.Handler{
+		Prefix:     prefix,
+		FileSystem: fs,
+		LockSystem: webdav.NewMemLS(),
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" {
+			etag := ""
+			if fi, err := fs.Stat(strings.TrimPrefix(r.URL.Path, prefix)); err == nil {
+				// as computed by webdav.Handler
+				etag = fmt.Sprintf(`"%x%x"`, fi.ModTime().UnixNano(), fi.Size())
+			}
+			ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+			if ifMatch != "" && (etag == "" || ifMatch != "*" && ifMatch != etag) ||
+				ifNoneMatch != "" && etag != "" && (ifNoneMatch == "*" || ifNoneMatch == etag) {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	}), fs
+}
+
+func TestStatContainerPath(t *testing.T) {
+	ts, _ := newWebdavTestServer(map[string]string{
+		"/etc/":           "",
+		"/etc/nginx/":     "",
+		"/etc/nginx.conf": "worker_processes 1;\n",
+		"/etc/hosts":      "127.0.0.1 localhost\n",
+	})
+	defer ts.Close()
+	client := NewQcosClient(QcosConfig{Host: ts.URL})
+
+	info, err := StatContainerPath(context.TODO(), client, "10.0.0.1", "/etc/nginx.conf")
+	assert.NoError(t, err)
+	assert.Equal(t, "/etc/nginx.conf", info.Path)
+	assert.Equal(t, "nginx.conf", info.Name)
+	assert.Equal(t, int64(20), info.Size)
+	assert.False(t, info.IsDir)
+	assert.NotEmpty(t, info.ETag)
+	assert.WithinDuration(t, time.Now(), info.ModTime, time.Minute)
+
+	info, err = StatContainerPath(context.TODO(), client, "10.0.0.1", "etc/")
+	assert.NoError(t, err)
+	assert.Equal(t, "/etc", info.Path)
+	assert.True(t, info.IsDir)
+
+	_, err = StatContainerPath(context.TODO(), client, "10.0.0.1", "/etc/passwd")
+	assert.Equal(t, ErrNoSuchEntry, err)
+
+	// a response about another path
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>
+<D:multistatus xmlns:D="DAV:">
+  <D:response>
+    <D:href>/v3/containers/10.0.0.1/webdav/files/etc/hosts</D:href>
+    <D:propstat>
+      <D:prop><D:resourcetype/></D:prop>
+      <D:status>HTTP/1.1 200 OK</D:status>
+    </D:propstat>
+  </D:response>
+</D:multistatus>`)
+	}))
+	defer other.Close()
+	client = NewQcosClient(QcosConfig{Host: other.URL})
+	_, err = StatContainerPath(context.TODO(), client, "10.0.0.1", "/etc/nginx.conf")
+	assert.EqualError(t, err, "stat /etc/nginx.conf: got /etc/hosts instead")
+}
+
+func TestListContainerDir(t *testing.T) {
+	ts, _ := newWebdavTestServer(map[string]string{
+		"/etc/":              "",
+		"/etc/nginx/":        "",
+		"/etc/nginx/default": "server {}",
+		"/etc/hosts":         "127.0.0.1 localhost\n",
+	})
+	defer ts.Close()
+	client := NewQcosClient(QcosConfig{Host: ts.URL})
+
+	infos, err := ListContainerDir(context.TODO(), client, "10.0.0.1", "/etc", StatContainerFileArgs{})
+	assert.NoError(t, err)
+	var paths []string
+	for _, info := range infos {
+		paths = append(paths, info.Path)
+	}
+	assert.Equal(t, []string{"/etc/hosts", "/etc/nginx"}, paths)
+	assert.True(t, infos[1].IsDir)
+
+	_, err = ListContainerDir(context.TODO(), client, "10.0.0.1", "/etc/hosts", StatContainerFileArgs{})
+	assert.Equal(t, ErrNotDir, err)
+
+	_, err = ListContainerDir(context.TODO(), client, "10.0.0.1", "/var", StatContainerFileArgs{})
+	assert.Equal(t, ErrNoSuchEntry, err)
+}
+
+func TestParseMultiStatus(t *testing.T) {
+	body := `<?xml version="1.0" encoding="UTF-8"?>
+<D:multistatus xmlns:D="DAV:">
+  <D:response>
+    <D:href>/v3/containers/10.0.0.1/webdav/files/var/log/</D:href>
+    <D:propstat>
+      <D:prop>
+        <D:resourcetype><D:collection/></D:resourcetype>
+        <D:getlastmodified>Mon, 02 Jan 2006 15:04:05 GMT</D:getlastmodified>
+      </D:prop>
+      <D:status>HTTP/1.1 200 OK</D:status>
+    </D:propstat>
+  </D:response>
+  <D:response>
+    <D:href>/v3/containers/10.0.0.1/webdav/files/var/log/app%20error.log</D:href>
+    <D:propstat>
+      <D:prop>
+        <D:resourcetype/>
+        <D:getcontentlength>1024</D:getcontentlength>
+        <D:getetag>"abc"</D:getetag>
+        <D:getcontenttype>text/plain</D:getcontenttype>
+      </D:prop>
+      <D:status>HTTP/1.1 200 OK</D:status>
+    </D:propstat>
+    <D:propstat>
+      <D:prop><D:getcontentlanguage/></D:prop>
+      <D:status>HTTP/1.1 404 Not Found</D:status>
+    </D:propstat>
+  </D:response>
+  <D:response>
+    <D:href>/v3/containers/10.0.0.1/webdav/files/var/log/gone</D:href>
+    <D:status>HTTP/1.1 404 Not Found</D:status>
+  </D:response>
+</D:multistatus>`
+
+	infos, err := ParseMultiStatus(strings.NewReader(body))
+	assert.NoError(t, err)
+	if assert.Len(t, infos, 2) {
+		assert.Equal(t, "/var/log", infos[0].Path)
+		assert.True(t, infos[0].IsDir)
+		assert.Equal(t, time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC), infos[0].ModTime)
+		assert.Equal(t, ContainerFileInfo{
+			Path:        "/var/log/app error.log",
+			Name:        "app error.log",
+			Size:        1024,
+			ETag:        `"abc"`,
+			ContentType: "text/plain",
+		}, infos[1])
+	}
+}
+
+func TestContainerFileOperations(t *testing.T) {
+	ts, _ := newWebdavTestServer(map[string]string{
+		"/etc/":           "",
+		"/etc/nginx.conf": "worker_processes 1;\n",
+	})
+	defer ts.Close()
+	client := NewQcosClient(QcosConfig{Host: ts.URL})
+	ctx := context.TODO()
+	content := func(filePath string) string {
+		rc, err := client.DownloadFromContainer(ctx, "10.0.0.1", filePath)
+		if err != nil {
+			return err.Error()
+		}
+		defer rc.Close()
+		b, _ := ioutil.ReadAll(rc)
+		return string(b)
+	}
+
+	err := client.CopyInContainer(ctx, "10.0.0.1", "/etc/nginx.conf", "/etc/nginx.conf.bak", CopyInContainerArgs{})
+	assert.NoError(t, err)
+	assert.Equal(t, "worker_processes 1;\n", content("/etc/nginx.conf.bak"))
+	err = client.CopyInContainer(ctx, "10.0.0.1", "/etc/nginx.conf", "/etc/nginx.conf.bak", CopyInContainerArgs{})
+	assert.Equal(t, ErrPreconditionFailed, err)
+	err = client.CopyInContainer(ctx, "10.0.0.1", "/etc/nginx.conf", "/etc/nginx.conf.bak", CopyInContainerArgs{Overwrite: true})
+	assert.NoError(t, err)
+
+	err = client.MoveInContainer(ctx, "10.0.0.1", "/etc/nginx.conf.bak", "/etc/nginx.conf.old", MoveInContainerArgs{})
+	assert.NoError(t, err)
+	assert.Equal(t, ErrNoSuchEntry.Error(), content("/etc/nginx.conf.bak"))
+	assert.Equal(t, "worker_processes 1;\n", content("/etc/nginx.conf.old"))
+	err = client.CopyInContainer(ctx, "10.0.0.1", "/etc/missing", "/etc/other", CopyInContainerArgs{})
+	assert.Equal(t, ErrNoSuchEntry, err)
+	// the parent of the destination is missing
+	err = client.CopyInContainer(ctx, "10.0.0.1", "/etc/nginx.conf", "/backup/nginx.conf", CopyInContainerArgs{})
+	assert.Equal(t, ErrConflict, err)
+
+	assert.NoError(t, client.DeleteInContainer(ctx, "10.0.0.1", "/etc/nginx.conf.old"))
+	assert.Equal(t, ErrNoSuchEntry, client.DeleteInContainer(ctx, "10.0.0.1", "/etc/nginx.conf.old"))
+
+	assert.NoError(t, client.MkdirInContainer(ctx, "10.0.0.1", "/etc/nginx"))
+	assert.Equal(t, ErrNoSuchEntry, client.MkdirInContainer(ctx, "10.0.0.1", "/var/log/nginx"))
+	assert.Equal(t, ErrResultError, client.MkdirInContainer(ctx, "10.0.0.1", "/etc/nginx"))
+
+	// replace the config only if unchanged
+	info, err := StatContainerPath(ctx, client, "10.0.0.1", "/etc/nginx.conf")
+	assert.NoError(t, err)
+	err = client.UploadToContainerWithArgs(ctx, "10.0.0.1", "/etc/nginx.conf",
+		strings.NewReader("worker_processes 2;\n"), UploadToContainerArgs{IfMatch: info.ETag})
+	assert.NoError(t, err)
+	assert.Equal(t, "worker_processes 2;\n", content("/etc/nginx.conf"))
+	err = client.UploadToContainerWithArgs(ctx, "10.0.0.1", "/etc/nginx.conf",
+		strings.NewReader("worker_processes 3;\n"), UploadToContainerArgs{IfMatch: info.ETag})
+	assert.Equal(t, ErrPreconditionFailed, err)
+	assert.Equal(t, "worker_processes 2;\n", content("/etc/nginx.conf"))
+
+	// create only if missing
+	err = client.UploadToContainerWithArgs(ctx, "10.0.0.1", "/etc/nginx.conf",
+		strings.NewReader(""), UploadToContainerArgs{IfNoneMatch: "*"})
+	assert.Equal(t, ErrPreconditionFailed, err)
+	err = client.UploadToContainerWithArgs(ctx, "10.0.0.1", "/etc/mime.types",
+		strings.NewReader("text/html html\n"), UploadToContainerArgs{IfNoneMatch: "*"})
+	assert.NoError(t, err)
+	assert.Equal(t, "text/html html\n", content("/etc/mime.types"))
+}